@@ -1,12 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"syscall"
 
 	"github.com/moblang/mob/pkg/compiler"
+	"github.com/moblang/mob/pkg/format"
+	"github.com/moblang/mob/pkg/interp"
+	"github.com/moblang/mob/pkg/lint"
+	"github.com/moblang/mob/pkg/project"
+	"github.com/moblang/mob/pkg/vm"
 )
 
 const (
@@ -43,8 +58,24 @@ func main() {
 		handleServe()
 	case "lint":
 		handleLint()
+	case "fmt":
+		handleFmt()
+	case "disasm":
+		handleDisasm()
+	case "explain":
+		handleExplain()
+	case "clean":
+		handleClean()
+	case "init":
+		handleInit()
+	case "add":
+		handleAdd()
+	case "remove":
+		handleRemove()
+	case "vendor":
+		handleVendor()
 	default:
-		if strings.HasSuffix(command, ".mob") {
+		if strings.HasSuffix(command, ".mob") || strings.HasSuffix(command, vm.Extension) {
 			handleRunWithFile(command)
 		} else {
 			os.Stderr.WriteString("Unknown command: " + command + "\n\n")
@@ -67,15 +98,24 @@ func printUsage() {
   mob <file.mob>                  Shortcut for 'mob run'
 
 📚 Commands:
-  run <file.mob>                  Compile and execute .mob file
-  build <file.mob>                Compile to native binary
-  serve <file.mob>                Start HTTP server
-  lint <path>                     Run linter on .mob files
+  init [name]                     Create a new project
+  run [file.mob]                  Compile and execute .mob file
+  build [file.mob]                Compile to native binary
+  serve [file.mob]                Start HTTP server
+  lint [path]                     Run linter on .mob files
+  fmt [path]                      Format .mob files
+  disasm <file>                   Show the bytecode of a .mobc or .mob file
+  explain <code>                  Explain an error code, such as E0201
+  add <name>[@version]            Add a dependency to mob.toml
+  remove <name>                   Remove a dependency from mob.toml
+  vendor                          Copy dependencies into vendor/
+  clean --cache                   Remove cached builds
   version, -v, --version          Show version information
   info                            Show detailed system information
   help [command]                  Show help for a command
 
 💡 Examples:
+  mob init hello && cd hello && mob run
   mob run main.mob
   mob build main.mob && ./main
   mob examples/hello.mob
@@ -108,6 +148,18 @@ func printCommandHelp(cmd string) {
 		printServeHelp()
 	case "lint":
 		printLintHelp()
+	case "fmt":
+		printFmtHelp()
+	case "disasm":
+		printDisasmHelp()
+	case "explain":
+		printExplainHelp()
+	case "clean":
+		printCleanHelp()
+	case "init":
+		printInitHelp()
+	case "add", "remove", "vendor":
+		printDependencyHelp()
 	case "version":
 		printVersionHelp()
 	case "info":
@@ -116,7 +168,7 @@ func printCommandHelp(cmd string) {
 		printUsage()
 	default:
 		os.Stderr.WriteString("No help available for: " + cmd + "\n\n")
-		os.Stderr.WriteString("Available commands: run, build, serve, lint, version, info, help\n")
+		os.Stderr.WriteString("Available commands: init, run, build, serve, lint, fmt, disasm, explain, add, remove, vendor, clean, version, info, help\n")
 		os.Exit(1)
 	}
 }
@@ -131,22 +183,33 @@ func printRunHelp() {
   Compiles and executes a .mob file in one step.
 
 ⚡ Usage:
-  mob run <file.mob>
-  mob <file.mob>            (shortcut)
+  mob run [options] <file.mob> [--] [args...]
+  mob run [options] [-- args...]    (runs the entry from mob.toml)
+  mob <file.mob> [args...]  (shortcut)
+  mob run <file.mobc> [args...]     (runs bytecode from 'mob build --backend=vm')
 
 📋 Examples:
   mob run main.mob
   mob examples/hello.mob
-  mob path/to/myapp.mob
+  mob run app.mob input.txt --count 3
+  mob run app.mob -- --verbose    (passes --verbose to the program)
 
-🔧 Options:
+🔧 Options (before the file):
   --verbose    Show detailed compilation information
   --debug      Enable debug mode
+  --interp     Run with the interpreter instead of compiling
+
+💬 Program Arguments:
+  - Everything after the file is passed to the program
+  - Read them in Mob with args()
+  - stdin is connected to the program
+  - mob exits with the program's exit code
 
 💡 Notes:
-  - Creates a temporary binary and executes it
-  - The binary is removed after execution
-  - Useful for quick testing and development
+  - Builds the program once and caches the binary
+  - Unchanged programs start from the cache without rebuilding
+  - Cache lives in $XDG_CACHE_HOME/mob (see 'mob clean --cache')
+  - Without a Go toolchain, programs run with the interpreter
   - For production, use 'mob build' instead
 
 🔗 See Also:
@@ -169,20 +232,63 @@ func printBuildHelp() {
 
 ⚡ Usage:
   mob build <file.mob>
+  mob build               (builds the entry from mob.toml)
 
 📋 Examples:
   mob build main.mob
   mob build examples/hello.mob
   mob build -o myapp src/app.mob
+  mob build --emit=go main.mob
+  mob build --emit=llvm main.mob > main.ll  (clang main.ll -o main)
+  mob build --target=linux/arm64 main.mob   (-> main-linux-arm64)
+  mob build --os=windows main.mob           (-> main-windows-amd64.exe)
+  mob build --target=wasm main.mob          (-> main-wasip1-wasm.wasm)
+  mob build --target=js/wasm main.mob       (-> main-js-wasm.wasm + wasm_exec.js)
+  mob build --all-targets
+  mob build --release --reproducible
+  mob build --backend=c main.mob            (-> main, built with cc)
+  mob build --backend=vm main.mob           (-> main.mobc)
+  mob build --format=json main.mob
 
 🔧 Options:
-  -o, --output <name>   Specify output binary name (default: main)
+  -o, --output <name>   Specify output binary name (default: main, or
+                        the output from mob.toml)
+  --emit <stage>        Stop after a stage and print its result:
+                        tokens, ast, ast-json, go, c or llvm
+  --target <os/arch>    Cross-compile, e.g. --target=linux/arm64;
+                        wasm is WASI (wasip1/wasm), js/wasm is browsers
+                        and Node.js
+  --os <os>             Target operating system (GOOS)
+  --arch <arch>         Target architecture (GOARCH)
+  --all-targets         Build every target listed in mob.toml
+  --cgo                 Enable cgo (builds are static by default)
+  --release             Strip symbols and paths (-trimpath, -s -w)
+  --reproducible        Build twice in a fixed directory and check both
+                        binaries are identical
+  --backend <go|c|vm>   go builds a native binary (default); c builds
+                        one from C99 with the system cc ($CC), for the
+                        host only; vm compiles to bytecode in
+                        <output>.mobc, run with 'mob run'
+  --format <text|json>  json prints {"diagnostics": [...]} on stdout,
+                        with codes, ranges and suggested fixes, even
+                        when the build succeeds; progress goes to stderr
 
 💡 Notes:
   - Generates a persistent native binary
   - Binary can be distributed and run without mob
-  - Supports Linux (amd64, arm64)
+  - Cross-compiles to any platform Go supports ('go tool dist list')
+  - Cross builds are named <output>-<os>-<arch> unless -o is given;
+    --all-targets always uses -o as the base name
+  - Binaries are static (CGO_ENABLED=0) unless --cgo is given
+  - Programs read their version (from mob.toml) and git commit with
+    'import mob.build' and mob.build.version() / mob.build.commit()
+  - --emit=llvm prints the whole program as one LLVM IR module that
+    only needs the C library; clang compiles it when it is installed
+  - js/wasm programs call the host with 'import js': js.call(fn, args...),
+    js.get(name) and js.set(name, value)
   - Output binary is optimized for performance
+  - Each diagnostic has a stable code, such as E0201; 'mob explain
+    E0201' describes it
 
 📦 After Building:
   ./main                  # Execute the binary
@@ -208,6 +314,7 @@ func printServeHelp() {
 
 ⚡ Usage:
   mob serve <file.mob>
+  mob serve               (serves the entry from mob.toml)
 
 🔧 Options:
   --port <number>         Specify port (default: 8080)
@@ -236,41 +343,276 @@ func printServeHelp() {
 `)
 }
 
+func printDisasmHelp() {
+	os.Stdout.WriteString(`
+╔═══════════════════════════════════════════════════════════════╗
+║                    mob disasm <file>                           ║
+╚═══════════════════════════════════════════════════════════════╝
+
+📖 Description:
+  Print the bytecode the VM runs, for debugging.
+
+⚡ Usage:
+  mob disasm <file.mobc>
+  mob disasm <file.mob>   (compiles to bytecode first)
+
+📋 Examples:
+  mob build --backend=vm main.mob && mob disasm main.mobc
+  mob disasm examples/hello.mob
+
+💡 Notes:
+  - Lists the constant pool, the init order and every function
+  - Each instruction shows its offset, the source line:column where
+    a new one starts, the opcode and its operands
+
+🔗 See Also:
+  mob help build
+  mob help run
+
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+
+`)
+}
+
+func printExplainHelp() {
+	os.Stdout.WriteString(`
+╔═══════════════════════════════════════════════════════════════╗
+║                    mob explain <code>                          ║
+╚═══════════════════════════════════════════════════════════════╝
+
+📖 Description:
+  Print the long-form explanation of a diagnostic code, with an
+  example of code that triggers it and how to fix it.
+
+⚡ Usage:
+  mob explain <code>
+  mob explain             (lists every code)
+
+📋 Examples:
+  mob explain E0201
+  mob explain L0104
+  mob explain naming
+
+💡 Notes:
+  - E codes are compiler errors, L codes are lint rules
+  - Codes are stable; they appear in 'mob build --format=json' and
+    'mob lint --format=json|sarif'
+  - A lint rule can also be looked up by name
+
+🔗 See Also:
+  mob help build
+  mob help lint
+
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+
+`)
+}
+
 func printLintHelp() {
 	os.Stdout.WriteString(`
 ╔═══════════════════════════════════════════════════════════════╗
-║                      mob lint <path>                           ║
+║                      mob lint [path]                           ║
 ╚═══════════════════════════════════════════════════════════════╝
 
 📖 Description:
-  Run the native linter on .mob files.
+  Run the native linter on .mob files: the compiler's checks plus
+  style and correctness rules. Directories are searched recursively,
+  skipping hidden directories and the project's vendor/.
+  Without a path, the project containing the current directory is
+  linted.
 
 ⚡ Usage:
-  mob lint <path>
+  mob lint [path] [--fix | --diff] [--rule name=level] [--format=text|json|sarif]
 
 📋 Examples:
   mob lint .
   mob lint src/
   mob lint main.mob
+  mob lint . --rule naming=error --rule unused-import=off
+  mob lint --diff
+  mob lint --fix
+  mob lint --format=sarif > mob.sarif
+
+🔧 Rules:
+  unused-import     L0101  Imported modules and names that are never called
+  shadowing         L0102  Functions and imports that hide a builtin
+  unreachable-code  L0103  Statements after a return
+  naming            L0104  Function names that are not snake_case
+
+  The compiler's checks (syntax, undefined names, circular imports)
+  always run.
+
+⚙️ Options:
+  --fix               Apply the fixes rules suggest, then report what is left
+  --diff              Show the fixes as a unified diff without applying them
+                      (exits with status 1 when there is something to fix)
+  --rule name=level   Set a rule to error, warning or off
+  --format <format>   text (default), json like 'mob build --format=json',
+                      or sarif, a SARIF 2.1.0 log for code scanning
+
+💡 Notes:
+  - Rules are warnings unless configured otherwise
+  - Levels can be set per project in a [lint] section of mob.toml:
+      [lint]
+      naming = "error"
+  - Silence a rule with a comment on the line, or alone on the
+    line above: # mob:ignore naming, shadowing
+  - Exits with status 1 only when there are errors
+  - Fixes remove unused imports, delete unreachable code and rename
+    functions to snake_case; renames are only offered in files no
+    other file imports, since calls from other files would not follow
+  - Each file is rewritten at once, never left half fixed
+
+🔗 See Also:
+  mob help build
+  mob help run
+
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+
+`)
+}
+
+func printFmtHelp() {
+	os.Stdout.WriteString(`
+╔═══════════════════════════════════════════════════════════════╗
+║                       mob fmt [path]                           ║
+╚═══════════════════════════════════════════════════════════════╝
+
+📖 Description:
+  Format .mob files in the canonical Mob style. Directories are
+  searched recursively, skipping hidden directories and the
+  project's vendor/. Without a path, the project containing the
+  current directory is formatted, or the current directory.
 
-🔧 Checks:
-  - Circular import detection
-  - Unused import detection
-  - Type checking
-  - Syntax validation
-  - Code style violations
+⚡ Usage:
+  mob fmt [path] [-w | --check]
+
+📋 Examples:
+  mob fmt main.mob            (prints the formatted file)
+  mob fmt -w .
+  mob fmt --check
+
+⚙️ Options:
+  -w          Write the result back to the files instead of printing it
+  --check     List the files that are not formatted and exit with
+              status 1 if there are any, for CI
+
+🎨 Style:
+  - 4 spaces of indentation
+  - One space after commas, none inside parentheses or around dots
+  - Calls longer than 80 columns get one argument per line, with a
+    trailing comma
+  - One blank line around functions and after the imports; other
+    blank lines are kept, at most one in a row
+  - Comments are kept, re-indented, with a space after '#'
 
 💡 Notes:
-  - Linter is integrated into the compiler
-  - Automatically runs during compilation
-  - Can be run separately for quick checks
-  - Supports multiple files and directories
+  - Files with syntax errors are reported and left unchanged
+  - Formatting never changes what a program does; mob fmt checks it
+    before writing
+  - Formatting is idempotent: formatted files do not change
 
-⚠️ Status:
-  Basic linting available, full implementation in v0.1.0
+🔗 See Also:
+  mob help lint
+
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+
+`)
+}
+
+func printInitHelp() {
+	os.Stdout.WriteString(`
+╔═══════════════════════════════════════════════════════════════╗
+║                       mob init [name]                          ║
+╚═══════════════════════════════════════════════════════════════╝
+
+📖 Description:
+  Create a new Mob project.
+
+⚡ Usage:
+  mob init            (in the current directory)
+  mob init <name>     (in a new directory called <name>)
+
+📦 Creates:
+  mob.toml      Project manifest (name, version, entry, targets)
+  main.mob      Hello world entry point
+  tests/        Directory for tests
+  .gitignore    Ignores the built binary
+
+💡 Notes:
+  - Existing files are never overwritten
+  - Inside a project, 'mob run', 'mob build' and 'mob serve' use the
+    entry from mob.toml when no file is given
+  - Imports are resolved from the 'sources' directories in mob.toml
+
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+
+`)
+}
+
+func printDependencyHelp() {
+	os.Stdout.WriteString(`
+╔═══════════════════════════════════════════════════════════════╗
+║                mob add | mob remove | mob vendor               ║
+╚═══════════════════════════════════════════════════════════════╝
+
+📖 Description:
+  Manage the dependencies listed in mob.toml.
+
+⚡ Usage:
+  mob add <name>[@<constraint>]          From the local registry
+  mob add <name> --path <dir>            From a local directory
+  mob remove <name>
+  mob vendor
+
+📋 Examples:
+  mob add strutil --path ../strutil
+  mob add mathx                          (latest, as ^X.Y.Z)
+  mob add mathx@~1.4
+  mob remove mathx
+
+🔧 Constraints:
+  ^1.2.3        >=1.2.3, <2.0.0 (default for a bare version)
+  ~1.2.3        >=1.2.3, <1.3.0
+  =1.2.3        exactly 1.2.3
+  >=1.0.0, <3   comparators separated by commas
+
+💡 Notes:
+  - Registry packages live in <registry>/<name>/<version>/, where the
+    registry is $MOB_REGISTRY, 'registry' in mob.toml or ~/.mob/registry
+  - mob.lock records each version, source and content hash; commit it
+  - 'mob vendor' copies every dependency into vendor/, which is then
+    used instead of the sources and checked against mob.lock
+  - 'import strutil' loads the entry of strutil; 'import strutil.text'
+    loads text.mob from its source dirs
+  - No network access is needed
+
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+
+`)
+}
+
+func printCleanHelp() {
+	os.Stdout.WriteString(`
+╔═══════════════════════════════════════════════════════════════╗
+║                      mob clean --cache                         ║
+╚═══════════════════════════════════════════════════════════════╝
+
+📖 Description:
+  Remove build artifacts kept by mob.
+
+⚡ Usage:
+  mob clean --cache
+
+🔧 Options:
+  --cache      Remove the build cache used by 'mob run'
+
+💡 Notes:
+  - The cache lives in $XDG_CACHE_HOME/mob (~/.cache/mob by default)
+  - Entries are keyed by source content, compiler version and options
+  - Removing it is always safe; programs are rebuilt on the next run
 
 🔗 See Also:
-  mob help build
   mob help run
 
 ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
@@ -351,7 +693,11 @@ Compiler:    moblang (transpiles to Go)
 System:
   OS:       ` + runtime.GOOS + `
   Arch:     ` + runtime.GOARCH + `
-  Go:       ` + runtime.Version() + `
+  Go:       ` + runtime.Version() + ` (mob itself)
+  Cache:    ` + cacheDir() + `
+
+Go Toolchain (builds programs):
+` + toolchainInfo() + `
 
 ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
 
@@ -366,41 +712,169 @@ Resources:
 `)
 }
 
+// toolchainInfo describes the Go toolchain mob builds with, or why
+// there is none.
+func toolchainInfo() string {
+	goroot := ""
+	if manifest, err := project.Find("."); err == nil {
+		goroot = manifest.GoRootDir()
+	}
+
+	tc, err := compiler.FindToolchain(context.Background(), goroot)
+	if err != nil {
+		return "  Status:   not usable\n  Problem:  " + strings.TrimPrefix(err.Error(), compiler.ErrNoToolchain.Error()+": ")
+	}
+	return "  Version:  go" + tc.Version + " (minimum go" + compiler.MinGoVersion + ")\n" +
+		"  Path:     " + tc.Go + "\n" +
+		"  Found by: " + tc.Source
+}
+
+func cacheDir() string {
+	cache, err := compiler.OpenBuildCache()
+	if err != nil {
+		return "unavailable (" + err.Error() + ")"
+	}
+	return cache.Dir()
+}
+
 func handleRun() {
-	if len(os.Args) < 3 {
-		os.Stderr.WriteString("Usage: mob run <file.mob>\n")
+	options, filename, programArgs := splitRunArgs(os.Args[2:])
+	manifest := loadManifest(filename)
+	if filename == "" && manifest != nil {
+		filename = manifestEntry(manifest)
+	}
+	if filename == "" {
+		os.Stderr.WriteString("Usage: mob run <file.mob> [args...]\n")
 		os.Stderr.WriteString("Use 'mob help run' for more information\n")
 		os.Exit(1)
 	}
 
-	filename := os.Args[2]
-
-	verbose := false
-	if len(os.Args) > 3 && os.Args[3] == "--verbose" {
-		verbose = true
+	verbose, interpret := false, false
+	for _, opt := range options {
+		switch opt {
+		case "--verbose":
+			verbose = true
+		case "--interp":
+			interpret = true
+		}
 	}
 
-	if verbose {
+	switch {
+	case verbose && interpret:
+		os.Stdout.WriteString("[VERBOSE] Interpreting " + filename + "...\n")
+	case verbose:
 		os.Stdout.WriteString("[VERBOSE] Compiling " + filename + "...\n")
-	} else {
+	default:
 		os.Stdout.WriteString("Running " + filename + "...\n")
 	}
 
-	comp := compiler.NewCompiler()
-	if err := comp.CompileAndRun(filename); err != nil {
-		os.Stderr.WriteString("Error: " + err.Error() + "\n")
-		os.Exit(1)
-	}
+	runProgram(manifest, filename, programArgs, interpret)
 }
 
 func handleRunWithFile(filename string) {
 	os.Stdout.WriteString("Running " + filename + "...\n")
 
-	comp := compiler.NewCompiler()
-	if err := comp.CompileAndRun(filename); err != nil {
-		os.Stderr.WriteString("Error: " + err.Error() + "\n")
-		os.Exit(1)
+	_, _, programArgs := splitRunArgs(os.Args[1:])
+	runProgram(loadManifest(filename), filename, programArgs, false)
+}
+
+// splitRunArgs separates mob options, which come before the file, from
+// the program arguments after it. A "--" right after the file is dropped
+// so programs can receive arguments that look like mob options; without
+// a file, arguments after "--" go to the manifest's entry.
+func splitRunArgs(args []string) (options []string, filename string, programArgs []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return options, "", args[i+1:]
+		}
+		if strings.HasPrefix(arg, "-") {
+			options = append(options, arg)
+			continue
+		}
+
+		filename = arg
+		programArgs = args[i+1:]
+		if len(programArgs) > 0 && programArgs[0] == "--" {
+			programArgs = programArgs[1:]
+		}
+		return options, filename, programArgs
+	}
+	return options, "", nil
+}
+
+// runProgram runs filename and exits with the program's own status. It
+// uses the interpreter when asked to, or when there is no Go toolchain
+// to build the program with.
+func runProgram(manifest *project.Manifest, filename string, args []string, interpret bool) {
+	if vm.IsBytecode(filename) {
+		runBytecode(filename, args)
+		return
+	}
+
+	comp := newCompiler(manifest)
+	if !interpret {
+		err := comp.CompileAndRun(filename, args...)
+		if !errors.Is(err, compiler.ErrNoToolchain) {
+			if err != nil {
+				if code, ok := compiler.ExitCode(err); ok {
+					os.Exit(code)
+				}
+				exitWithError(err)
+			}
+			return
+		}
+		os.Stderr.WriteString("Note: " + err.Error() + "\nRunning with the interpreter instead.\n")
+	}
+
+	res := loadProgram(comp, filename)
+	runInProcess(func(ctx context.Context, rt *compiler.Runtime) error {
+		return interp.Run(ctx, res, rt)
+	}, args)
+}
+
+// loadProgram runs the front end on filename and exits on errors.
+func loadProgram(comp *compiler.Compiler, filename string) *compiler.Result {
+	res, err := comp.Load(filename)
+	if err != nil {
+		exitWithError(err)
+	}
+	if res.Diagnostics.HasErrors() {
+		exitWithError(res.Diagnostics)
+	}
+	return res
+}
+
+// runBytecode runs a .mobc file built by 'mob build --backend=vm'.
+func runBytecode(filename string, args []string) {
+	prog, err := vm.ReadFile(filename)
+	if err != nil {
+		exitWithError(err)
+	}
+	runInProcess(func(ctx context.Context, rt *compiler.Runtime) error {
+		return vm.Run(ctx, prog, rt)
+	}, args)
+}
+
+// runInProcess runs a program inside mob, with the interpreter or the
+// VM, and exits like the compiled program would: 2 for runtime errors,
+// as Go panics do, and 128+signal when interrupted.
+func runInProcess(run func(ctx context.Context, rt *compiler.Runtime) error, args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, &compiler.Runtime{Stdout: os.Stdout, Args: args})
+	if err == nil {
+		return
+	}
+	var runtimeErr *compiler.RuntimeError
+	switch {
+	case errors.As(err, &runtimeErr):
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(2)
+	case ctx.Err() != nil:
+		os.Exit(130)
 	}
+	exitWithError(err)
 }
 
 func handleBuild() {
@@ -411,7 +885,16 @@ func handleBuild() {
 	}
 
 	outputName := "main"
+	outputSet := false
 	filename := ""
+	emit := compiler.EmitNone
+	target := ""
+	goos, goarch := "", ""
+	allTargets := false
+	cgo := false
+	release, reproducible := false, false
+	backend := "go"
+	format := "text"
 
 	// Parse arguments
 	args := os.Args[2:]
@@ -419,32 +902,399 @@ func handleBuild() {
 		if args[i] == "-o" || args[i] == "--output" {
 			if i+1 < len(args) {
 				outputName = args[i+1]
+				outputSet = true
 				i++
 			}
+		} else if args[i] == "--emit" || strings.HasPrefix(args[i], "--emit=") {
+			// A missing stage is an error, not a full build.
+			_, value, _ := flagValue(args, &i, "--emit")
+			mode, err := compiler.ParseEmitMode(value)
+			if err != nil {
+				exitWithError(err)
+			}
+			emit = mode
+		} else if name, value, ok := flagValue(args, &i, "--target", "--os", "--arch", "--backend"); ok {
+			switch name {
+			case "--backend":
+				backend = value
+			case "--target":
+				target = value
+			case "--os":
+				goos = value
+			case "--arch":
+				goarch = value
+			}
+		} else if _, value, ok := flagValue(args, &i, "--format"); ok {
+			format = value
+		} else if args[i] == "--all-targets" {
+			allTargets = true
+		} else if args[i] == "--cgo" {
+			cgo = true
+		} else if args[i] == "--release" {
+			release = true
+		} else if args[i] == "--reproducible" {
+			reproducible = true
 		} else if !strings.HasPrefix(args[i], "-") && filename == "" {
 			filename = args[i]
 		}
 	}
 
+	manifest := loadManifest(filename)
+	if filename == "" && manifest != nil {
+		filename = manifestEntry(manifest)
+		if !outputSet {
+			outputName = manifest.Output
+		}
+	}
+
 	if filename == "" {
 		os.Stderr.WriteString("Usage: mob build <file.mob>\n")
 		os.Stderr.WriteString("Use 'mob help build' for more information\n")
 		os.Exit(1)
 	}
 
+	switch format {
+	case "text":
+	case "json":
+		if emit != compiler.EmitNone {
+			exitWithError(errors.New("--emit prints code; it cannot be combined with --format=json"))
+		}
+		useJSON()
+		// Every way out of a successful build returns here.
+		defer writeDiagnosticsJSON(nil)
+	default:
+		exitWithError(fmt.Errorf("unknown format %q: use text or json", format))
+	}
+
+	comp := newCompiler(manifest)
+	comp.CGO = cgo
+	comp.Release = release
+	comp.Reproducible = reproducible
+
+	switch backend {
+	case "go":
+	case "c":
+		if allTargets || target != "" || goos != "" || goarch != "" {
+			exitWithError(errors.New("--backend=c builds for the host only; it cannot be combined with targets"))
+		}
+		comp.Backend = compiler.BackendC
+	case "vm":
+		if emit != compiler.EmitNone || allTargets || target != "" || goos != "" || goarch != "" {
+			exitWithError(errors.New("--backend=vm builds portable bytecode; it cannot be combined with --emit or targets"))
+		}
+		if !outputSet {
+			outputName += vm.Extension
+		}
+		buildBytecode(comp, filename, outputName)
+		return
+	default:
+		exitWithError(fmt.Errorf("unknown backend %q: use go, c or vm", backend))
+	}
+
+	if emit != compiler.EmitNone {
+		if allTargets {
+			exitWithError(errors.New("--emit prints one program; it cannot be combined with --all-targets"))
+		}
+		comp.Emit = emit
+		// The zero Target is the host, which the c backend requires.
+		if target != "" || goos != "" || goarch != "" {
+			comp.Target = parseTarget(target, goos, goarch)
+		}
+		if err := comp.Compile(filename, outputName); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	if allTargets {
+		if manifest == nil || len(manifest.Targets) == 0 {
+			exitWithError(errors.New("--all-targets needs a 'targets' list in mob.toml"))
+		}
+		for _, t := range manifest.Targets {
+			buildTarget(comp, filename, outputName, t, "", "", true)
+		}
+		return
+	}
+
+	if target != "" || goos != "" || goarch != "" {
+		buildTarget(comp, filename, outputName, target, goos, goarch, !outputSet)
+		return
+	}
+
 	os.Stdout.WriteString("Building " + filename + "...\n")
 
-	comp := compiler.NewCompiler()
 	if err := comp.Compile(filename, outputName); err != nil {
+		exitWithError(err)
+	}
+
+	os.Stdout.WriteString("Build successful! Output: ./" + outputName + "\n")
+	printReproducible(comp, outputName)
+}
+
+// printReproducible shows the digest a reproducible build was verified
+// against, so it can be compared with other machines.
+func printReproducible(comp *compiler.Compiler, outputName string) {
+	if !comp.Reproducible {
+		return
+	}
+	data, err := os.ReadFile(outputName)
+	if err != nil {
+		exitWithError(err)
+	}
+	os.Stdout.WriteString(fmt.Sprintf("Reproducible: two builds match (sha256 %x)\n", sha256.Sum256(data)))
+}
+
+// flagValue matches args[*i] against names, in "--name value" or
+// "--name=value" form, advancing *i past a separate value.
+func flagValue(args []string, i *int, names ...string) (name, value string, ok bool) {
+	for _, name := range names {
+		if args[*i] == name && *i+1 < len(args) {
+			*i++
+			return name, args[*i], true
+		}
+		if value, ok := strings.CutPrefix(args[*i], name+"="); ok {
+			return name, value, true
+		}
+	}
+	return "", "", false
+}
+
+// buildBytecode compiles filename to a .mobc file for the VM.
+func buildBytecode(comp *compiler.Compiler, filename, outputName string) {
+	os.Stdout.WriteString("Building " + filename + " (bytecode)...\n")
+
+	prog, err := vm.Compile(loadProgram(comp, filename))
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := prog.WriteFile(outputName); err != nil {
+		exitWithError(err)
+	}
+	os.Stdout.WriteString("Build successful! Output: " + outputName + " (run it with 'mob run " + outputName + "')\n")
+}
+
+// parseTarget combines the target flags: target is "os/arch"; goos and
+// goarch override its parts, and either alone keeps the host's other
+// half.
+func parseTarget(target, goos, goarch string) compiler.Target {
+	t := compiler.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if target != "" {
+		var err error
+		if t, err = compiler.ParseTarget(target); err != nil {
+			exitWithError(err)
+		}
+	}
+	if goos != "" {
+		t.OS = goos
+	}
+	if goarch != "" {
+		t.Arch = goarch
+	}
+	return t
+}
+
+// buildTarget cross-compiles filename for the target flags (see
+// parseTarget). With perTarget the binary is named output-os-arch.
+func buildTarget(comp *compiler.Compiler, filename, outputName, target, goos, goarch string, perTarget bool) {
+	t := parseTarget(target, goos, goarch)
+	comp.Target = t
+	if perTarget {
+		outputName = t.OutputName(outputName)
+	}
+
+	os.Stdout.WriteString("Building " + filename + " for " + t.String() + "...\n")
+	if err := comp.Compile(filename, outputName); err != nil {
+		exitWithError(err)
+	}
+	os.Stdout.WriteString("Build successful! Output: ./" + outputName + "\n")
+	if t.OS == "js" {
+		os.Stdout.WriteString("Load it with wasm_exec.js, written next to it (see https://go.dev/wiki/WebAssembly)\n")
+	}
+	printReproducible(comp, outputName)
+}
+
+// newCompiler returns a compiler configured for the CLI, with the build
+// cache enabled when its directory can be located. Inside a project,
+// imports are resolved from the manifest's source dirs.
+func newCompiler(manifest *project.Manifest) *compiler.Compiler {
+	comp := compiler.NewCompiler()
+	comp.Version = Version
+	if manifest != nil {
+		comp.ProjectRoot = manifest.Dir
+		comp.SourceDirs = manifest.SourceDirs()
+		comp.Build.Version = manifest.Version
+		comp.GoRoot = manifest.GoRootDir()
+		for _, pkg := range resolveDependencies(manifest) {
+			comp.Packages = append(comp.Packages, compiler.Package{
+				Name:       pkg.Name,
+				Entry:      pkg.Entry(),
+				SourceDirs: pkg.SourceDirs(),
+			})
+		}
+	} else if cwd, err := os.Getwd(); err == nil {
+		comp.ProjectRoot = cwd
+	}
+	root := comp.ProjectRoot
+	comp.LookupCommit = func() string { return gitCommit(root) }
+	if cache, err := compiler.OpenBuildCache(); err == nil {
+		comp.Cache = cache
+	}
+	return comp
+}
+
+// gitCommit describes the checked out revision of the repository holding
+// dir, marked -dirty with uncommitted changes, or "" outside git.
+func gitCommit(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--short=12", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	commit := strings.TrimSpace(string(out))
+
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	if err == nil && len(bytes.TrimSpace(status)) > 0 {
+		commit += "-dirty"
+	}
+	return commit
+}
+
+// loadManifest returns the manifest of the project containing the
+// working directory, or nil outside a project. When the command works on
+// file, a path, the project only applies if it contains file: other
+// files are compiled on their own, even when the manifest is invalid.
+func loadManifest(file string) *project.Manifest {
+	path, err := project.FindPath(".")
+	if errors.Is(err, project.ErrNoManifest) {
+		return nil
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	if file != "" && !within(filepath.Dir(path), file) {
+		return nil
+	}
+	manifest, err := project.Load(path)
+	if err != nil {
+		exitWithError(err)
+	}
+	return manifest
+}
+
+// within reports whether path is dir or below it.
+func within(dir, path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveDependencies resolves the project's dependencies and updates
+// mob.lock when they changed.
+func resolveDependencies(manifest *project.Manifest) []*project.Package {
+	lock, err := project.LoadLock(manifest.Dir)
+	if err != nil {
+		exitWithError(err)
+	}
+	pkgs, err := project.Resolve(manifest, lock)
+	if err != nil {
+		exitWithError(err)
+	}
+	// Commands that only read the project leave mob.lock alone unless
+	// a dependency moved on.
+	if resolved := project.NewLock(pkgs); resolved.String() != lock.String() {
+		if err := resolved.Write(manifest.Dir); err != nil {
+			exitWithError(err)
+		}
+	}
+	return pkgs
+}
+
+// requireManifest is loadManifest for commands that only work inside a
+// project.
+func requireManifest() *project.Manifest {
+	manifest := loadManifest("")
+	if manifest == nil {
+		exitWithError(fmt.Errorf("%w; run 'mob init' to create a project", project.ErrNoManifest))
+	}
+	return manifest
+}
+
+// manifestEntry is the manifest's entry file, shown relative to the
+// working directory when possible.
+func manifestEntry(manifest *project.Manifest) string {
+	entry := manifest.EntryPath()
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, entry); err == nil {
+			return rel
+		}
+	}
+	return entry
+}
+
+// exitWithError prints compiler diagnostics one per line, or a plain
+// error message, and exits with a failure status.
+// jsonOut is the real standard output of a command run with
+// --format=json, which reports diagnostics there as JSON. The command's
+// other output goes to os.Stdout, which then is standard error.
+var jsonOut *os.File
+
+// useJSON switches the running command to JSON output.
+func useJSON() {
+	jsonOut = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+// writeDiagnosticsJSON writes {"diagnostics": [...]} to jsonOut.
+func writeDiagnosticsJSON(diags compiler.Diagnostics) {
+	if diags == nil {
+		diags = compiler.Diagnostics{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Diagnostics compiler.Diagnostics `json:"diagnostics"`
+	}{diags}, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	jsonOut.Write(append(data, '\n'))
+}
+
+func exitWithError(err error) {
+	var diags compiler.Diagnostics
+	if jsonOut != nil {
+		// Errors that are not diagnostics, such as a missing file,
+		// become one without a position.
+		if !errors.As(err, &diags) {
+			diags = compiler.Diagnostics{{Severity: compiler.SeverityError, Message: err.Error()}}
+		}
+		writeDiagnosticsJSON(diags)
+		os.Exit(1)
+	}
+	if !errors.As(err, &diags) {
 		os.Stderr.WriteString("Error: " + err.Error() + "\n")
 		os.Exit(1)
 	}
 
-	os.Stdout.WriteString("Build successful! Output: ./" + outputName + "\n")
+	internal := false
+	for _, d := range diags {
+		os.Stderr.WriteString(d.String() + "\n")
+		internal = internal || d.Internal
+	}
+	if internal {
+		os.Stderr.WriteString("\nThis is a bug in the mob compiler, please report it at " + RepoURL + "/issues\n")
+	}
+	os.Exit(1)
 }
 
 func handleServe() {
-	if len(os.Args) < 3 {
+	filename := ""
+	if len(os.Args) >= 3 {
+		filename = os.Args[2]
+	} else if manifest := loadManifest(""); manifest != nil {
+		filename = manifestEntry(manifest)
+	}
+	if filename == "" {
 		os.Stderr.WriteString("Usage: mob serve <file.mob>\n")
 		os.Stderr.WriteString("Use 'mob help serve' for more information\n")
 		os.Exit(1)
@@ -453,12 +1303,527 @@ func handleServe() {
 	os.Stderr.WriteString("Coming in v0.1.0\n")
 }
 
-func handleLint() {
+func handleExplain() {
+	if len(os.Args) < 3 {
+		for _, e := range compiler.Explanations() {
+			os.Stdout.WriteString(fmt.Sprintf("%s  %s\n", e.Code, e.Title))
+		}
+		rules := lint.Rules()
+		sort.Slice(rules, func(i, j int) bool { return rules[i].Code < rules[j].Code })
+		for _, r := range rules {
+			os.Stdout.WriteString(fmt.Sprintf("%s  %s (lint rule %s)\n", r.Code, strings.TrimSuffix(r.Doc, "."), r.Name))
+		}
+		return
+	}
+
+	code := strings.ToUpper(os.Args[2])
+	if e, ok := compiler.Explain(code); ok {
+		os.Stdout.WriteString(e.Code + ": " + e.Title + "\n\n" + e.Text)
+		return
+	}
+	r, ok := lint.LookupCode(code)
+	if !ok {
+		r, ok = lint.Lookup(os.Args[2])
+	}
+	if ok {
+		os.Stdout.WriteString(fmt.Sprintf("%s: %s (lint rule %s, %s by default)\n\n%s", r.Code, strings.TrimSuffix(r.Doc, "."), r.Name, r.Severity, r.Explanation))
+		return
+	}
+	exitWithError(fmt.Errorf("unknown code %q: run 'mob explain' to list them", os.Args[2]))
+}
+
+func handleDisasm() {
 	if len(os.Args) < 3 {
-		os.Stderr.WriteString("Usage: mob lint <path>\n")
+		os.Stderr.WriteString("Usage: mob disasm <file.mobc|file.mob>\n")
+		os.Stderr.WriteString("Use 'mob help disasm' for more information\n")
+		os.Exit(1)
+	}
+
+	filename := os.Args[2]
+	var prog *vm.Program
+	var err error
+	if vm.IsBytecode(filename) {
+		prog, err = vm.ReadFile(filename)
+	} else {
+		prog, err = vm.Compile(loadProgram(newCompiler(loadManifest(filename)), filename))
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := prog.Disassemble(os.Stdout); err != nil {
+		exitWithError(err)
+	}
+}
+
+func handleFmt() {
+	path := ""
+	write, check := false, false
+	for _, arg := range os.Args[2:] {
+		switch {
+		case arg == "-w":
+			write = true
+		case arg == "--check":
+			check = true
+		case !strings.HasPrefix(arg, "-") && path == "":
+			path = arg
+		default:
+			exitWithError(fmt.Errorf("unknown argument %q: use 'mob help fmt'", arg))
+		}
+	}
+	if write && check {
+		exitWithError(fmt.Errorf("-w and --check cannot be used together"))
+	}
+	if path == "" {
+		path = "."
+		if manifest := loadManifest(""); manifest != nil {
+			path = manifest.Dir
+		}
+	}
+
+	files, err := lint.Files(path)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	failed, unformatted, internal := false, false, false
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			exitWithError(err)
+		}
+		out, err := format.Source(file, src)
+		if err != nil {
+			// Report the file and go on with the others.
+			var diags compiler.Diagnostics
+			if !errors.As(err, &diags) {
+				exitWithError(err)
+			}
+			for _, d := range diags {
+				os.Stderr.WriteString(d.String() + "\n")
+				internal = internal || d.Internal
+			}
+			failed = true
+			continue
+		}
+		switch {
+		case check:
+			if string(out) != string(src) {
+				os.Stdout.WriteString(file + "\n")
+				unformatted = true
+			}
+		case write:
+			if string(out) != string(src) {
+				if err := lint.WriteFile(file, out); err != nil {
+					exitWithError(err)
+				}
+			}
+		default:
+			os.Stdout.Write(out)
+		}
+	}
+	if internal {
+		os.Stderr.WriteString("\nThis is a bug in the mob formatter, please report it at " + RepoURL + "/issues\n")
+	}
+	if failed || unformatted {
+		os.Exit(1)
+	}
+}
+
+// entryFiles returns the .mob files of the project, or of the directory
+// of path outside a project, that none of them imports, by absolute
+// path.
+func entryFiles(comp *compiler.Compiler, manifest *project.Manifest, path string) map[string]bool {
+	root := path
+	if manifest != nil {
+		root = manifest.Dir
+	} else if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		root = filepath.Dir(path)
+	}
+	files, err := lint.Files(root)
+	if err != nil {
+		return nil
+	}
+
+	imported := make(map[string]bool)
+	for _, file := range files {
+		res, err := comp.Load(file)
+		if err != nil {
+			continue
+		}
+		for _, mod := range res.Modules {
+			if abs, err := filepath.Abs(mod.File); err == nil {
+				imported[abs] = true
+			}
+		}
+	}
+	entries := make(map[string]bool)
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil && !imported[abs] {
+			entries[abs] = true
+		}
+	}
+	return entries
+}
+
+func handleLint() {
+	path := ""
+	fix, diff := false, false
+	format := "text"
+	var levels [][2]string
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		if _, value, ok := flagValue(args, &i, "--format"); ok {
+			format = value
+		} else if args[i] == "--fix" {
+			fix = true
+		} else if args[i] == "--diff" {
+			diff = true
+		} else if _, value, ok := flagValue(args, &i, "--rule"); ok {
+			name, level, found := strings.Cut(value, "=")
+			if !found {
+				exitWithError(fmt.Errorf("--rule takes name=level, such as naming=error"))
+			}
+			levels = append(levels, [2]string{name, level})
+		} else if !strings.HasPrefix(args[i], "-") && path == "" {
+			path = args[i]
+		}
+	}
+
+	manifest := loadManifest(path)
+	if path == "" && manifest != nil {
+		path = manifest.Dir
+	}
+	if path == "" {
+		os.Stderr.WriteString("Usage: mob lint [path] [--fix | --diff] [--rule name=level] [--format=text|json|sarif]\n")
 		os.Stderr.WriteString("Use 'mob help lint' for more information\n")
 		os.Exit(1)
 	}
-	os.Stderr.WriteString("Lint command not yet implemented\n")
-	os.Stderr.WriteString("Coming in v0.1.0\n")
+
+	switch format {
+	case "text", "json", "sarif":
+	default:
+		exitWithError(fmt.Errorf("unknown format %q: use text, json or sarif", format))
+	}
+	if format != "text" && diff {
+		exitWithError(fmt.Errorf("--diff prints a diff; it cannot be combined with --format"))
+	}
+	if format == "json" {
+		useJSON()
+	}
+
+	linter := &lint.Linter{}
+	if manifest != nil {
+		names := make([]string, 0, len(manifest.Lint))
+		for name := range manifest.Lint {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := linter.Configure(name, manifest.Lint[name]); err != nil {
+				exitWithError(fmt.Errorf("%s: %w", filepath.Join(manifest.Dir, project.ManifestName), err))
+			}
+		}
+	}
+	for _, level := range levels {
+		if err := linter.Configure(level[0], level[1]); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	files, err := lint.Files(path)
+	if err != nil {
+		exitWithError(err)
+	}
+	if fix && diff {
+		exitWithError(fmt.Errorf("--fix and --diff cannot be used together"))
+	}
+	comp := newCompiler(manifest)
+	linter.Entries = entryFiles(comp, manifest, path)
+
+	// Fixes are applied, or shown, before the checks run, so the
+	// problems reported are the ones left.
+	fixed := 0
+	changed := false
+	for _, file := range files {
+		if !fix && !diff {
+			break
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			exitWithError(err)
+		}
+		out, n, _ := linter.Fix(file, src)
+		if n == 0 {
+			continue
+		}
+		fixed += n
+		changed = true
+		if diff {
+			os.Stdout.WriteString(lint.Diff(filepath.ToSlash(file), src, out))
+		} else if err := lint.WriteFile(file, out); err != nil {
+			exitWithError(err)
+		}
+	}
+	if diff {
+		// Like gofmt -d, a non-empty diff fails, so CI can require it
+		// to be empty.
+		if changed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	seen := make(map[string]bool)
+	var problems []string
+	var all compiler.Diagnostics
+	errs, fixable := 0, 0
+	internal := false
+	for _, file := range files {
+		diags, err := comp.Check(file)
+		if err != nil {
+			exitWithError(err)
+		}
+		// Files that import each other report the same problems.
+		for _, d := range diags {
+			if !seen[d.String()] {
+				seen[d.String()] = true
+				problems = append(problems, d.String())
+				all = append(all, d)
+				internal = internal || d.Internal
+				if d.Severity == compiler.SeverityError {
+					errs++
+				}
+			}
+		}
+
+		findings, err := linter.File(file)
+		if err != nil {
+			exitWithError(err)
+		}
+		for _, f := range findings {
+			problems = append(problems, f.String())
+			all = append(all, f.Diagnostic)
+			if f.Severity == compiler.SeverityError {
+				errs++
+			}
+			if f.Fix != nil {
+				fixable++
+			}
+		}
+	}
+
+	switch format {
+	case "json":
+		writeDiagnosticsJSON(all)
+	case "sarif":
+		if err := lint.WriteSARIF(os.Stdout, Version, Website, all); err != nil {
+			exitWithError(err)
+		}
+	}
+	if format != "text" {
+		if errs > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if fixed > 0 {
+		os.Stdout.WriteString(fmt.Sprintf("Fixed %d problem(s)\n", fixed))
+	}
+	if len(problems) == 0 {
+		os.Stdout.WriteString(fmt.Sprintf("✓ %d file(s) checked, no problems found\n", len(files)))
+		return
+	}
+	for _, p := range problems {
+		os.Stderr.WriteString(p + "\n")
+	}
+	summary := fmt.Sprintf("\n%d file(s) checked, %d error(s), %d warning(s)", len(files), errs, len(problems)-errs)
+	if fixable > 0 {
+		summary += fmt.Sprintf(", %d fixable with --fix", fixable)
+	}
+	os.Stderr.WriteString(summary + "\n")
+	if internal {
+		os.Stderr.WriteString("\nThis is a bug in the mob compiler, please report it at " + RepoURL + "/issues\n")
+	}
+	if errs > 0 {
+		os.Exit(1)
+	}
+}
+
+func handleClean() {
+	if len(os.Args) < 3 || os.Args[2] != "--cache" {
+		os.Stderr.WriteString("Usage: mob clean --cache\n")
+		os.Stderr.WriteString("Use 'mob help clean' for more information\n")
+		os.Exit(1)
+	}
+
+	cache, err := compiler.OpenBuildCache()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	freed, err := cache.Clean()
+	if err != nil {
+		exitWithError(err)
+	}
+	os.Stdout.WriteString(fmt.Sprintf("Removed %s (%.1f MB freed)\n", cache.Dir(), float64(freed)/(1<<20)))
+}
+
+func handleInit() {
+	dir := "."
+	name := ""
+	if len(os.Args) >= 3 {
+		name = os.Args[2]
+		dir = name
+	} else {
+		cwd, err := os.Getwd()
+		if err != nil {
+			exitWithError(err)
+		}
+		name = filepath.Base(cwd)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		exitWithError(err)
+	}
+	if err := project.Init(dir, name); err != nil {
+		exitWithError(err)
+	}
+
+	os.Stdout.WriteString("Created project " + name + "\n\n")
+	if dir != "." {
+		os.Stdout.WriteString("  cd " + dir + "\n")
+	}
+	os.Stdout.WriteString("  mob run\n")
+}
+
+func handleAdd() {
+	name, constraint, path := "", "", ""
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--path" && i+1 < len(args):
+			path = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--path="):
+			path = strings.TrimPrefix(args[i], "--path=")
+		case !strings.HasPrefix(args[i], "-") && name == "":
+			name, constraint, _ = strings.Cut(args[i], "@")
+		}
+	}
+	if name == "" {
+		os.Stderr.WriteString("Usage: mob add <name>[@version] [--path <dir>]\n")
+		os.Stderr.WriteString("Use 'mob help add' for more information\n")
+		os.Exit(1)
+	}
+
+	manifest := requireManifest()
+	dep := project.Dependency{Name: name, Version: constraint}
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			exitWithError(err)
+		}
+		if dep.Path, err = filepath.Rel(manifest.Dir, abs); err != nil {
+			dep.Path = abs
+		}
+	} else if constraint == "" {
+		latest, err := project.LatestVersion(manifest, name)
+		if err != nil {
+			exitWithError(err)
+		}
+		dep.Version = "^" + latest.String()
+	}
+
+	manifestPath := filepath.Join(manifest.Dir, project.ManifestName)
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		exitWithError(err)
+	}
+	data, err = project.AddDependency(manifestPath, data, dep)
+	if err != nil {
+		exitWithError(err)
+	}
+	pkgs := updateDependencies(manifestPath, data)
+
+	for _, pkg := range pkgs {
+		if pkg.Name == name {
+			os.Stdout.WriteString(fmt.Sprintf("Added %s %s (%s)\n", pkg.Name, pkg.Version, pkg.Source))
+		}
+	}
+}
+
+func handleRemove() {
+	if len(os.Args) < 3 {
+		os.Stderr.WriteString("Usage: mob remove <name>\n")
+		os.Stderr.WriteString("Use 'mob help remove' for more information\n")
+		os.Exit(1)
+	}
+
+	manifestPath := filepath.Join(requireManifest().Dir, project.ManifestName)
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		exitWithError(err)
+	}
+	data, err = project.RemoveDependency(manifestPath, data, os.Args[2])
+	if err != nil {
+		exitWithError(err)
+	}
+	updateDependencies(manifestPath, data)
+	os.Stdout.WriteString("Removed " + os.Args[2] + "\n")
+}
+
+// updateDependencies resolves the dependencies of data, the edited
+// content of mob.toml, and only when they resolve writes it, mob.lock
+// and, when the project vendors, the vendor directory. On errors the
+// project is left as it was.
+func updateDependencies(manifestPath string, data []byte) []*project.Package {
+	manifest, err := project.Parse(manifestPath, data)
+	if err != nil {
+		exitWithError(err)
+	}
+	manifest.Dir = filepath.Dir(manifestPath)
+	lock, err := project.LoadLock(manifest.Dir)
+	if err != nil {
+		exitWithError(err)
+	}
+	// The vendored copies are what is being updated, so resolve from
+	// the sources, like mob vendor.
+	pkgs, err := project.ResolveSources(manifest, lock)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if err := lint.WriteFile(manifestPath, data); err != nil {
+		exitWithError(err)
+	}
+	if err := project.NewLock(pkgs).Write(manifest.Dir); err != nil {
+		exitWithError(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(manifest.Dir, project.VendorDir)); err == nil {
+		if err := project.Vendor(manifest, pkgs); err != nil {
+			exitWithError(err)
+		}
+	}
+	return pkgs
+}
+
+func handleVendor() {
+	manifest := requireManifest()
+	lock, err := project.LoadLock(manifest.Dir)
+	if err != nil {
+		exitWithError(err)
+	}
+	pkgs, err := project.ResolveSources(manifest, lock)
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := project.NewLock(pkgs).Write(manifest.Dir); err != nil {
+		exitWithError(err)
+	}
+	if err := project.Vendor(manifest, pkgs); err != nil {
+		exitWithError(err)
+	}
+	os.Stdout.WriteString(fmt.Sprintf("Vendored %d package(s) into %s/\n", len(pkgs), project.VendorDir))
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the CLI instead of the tests when mob re-executes the
+// test binary, so tests drive the real command line.
+func TestMain(m *testing.M) {
+	if os.Getenv("MOB_TEST_CLI") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// mob runs the CLI with args in dir and returns its combined output.
+func mob(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "MOB_TEST_CLI=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestBuildEmit(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.mob"), []byte("print(\"hi\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"build", "--backend=c", "--emit=c", "main.mob"}, "mob_runtime_init"},
+		{[]string{"build", "--emit=go", "main.mob"}, "package main"},
+		{[]string{"build", "--emit=go", "--target=linux/arm64", "main.mob"}, "package main"},
+	}
+	for _, tt := range tests {
+		out, err := mob(t, dir, tt.args...)
+		if err != nil || !strings.Contains(out, tt.want) {
+			t.Errorf("mob %s: expected %q in the output, got %v:\n%s", strings.Join(tt.args, " "), tt.want, err, out)
+		}
+	}
+
+	if out, err := mob(t, dir, "build", "main.mob", "--emit"); err == nil || !strings.Contains(out, "missing emit mode") {
+		t.Errorf("mob build --emit without a stage: expected an error, got %v:\n%s", err, out)
+	}
+}
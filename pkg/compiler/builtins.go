@@ -0,0 +1,98 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+)
+
+// Builtin is a function every module can call without importing it.
+// The resolver checks calls against this table, the interpreter runs
+// Call, and codegen emits Go code with the same behavior: print is
+// fmt.Println and args is os.Args[1:]. Functions of standard modules
+// are described the same way.
+type Builtin struct {
+	// Args is the number of arguments; Variadic functions accept more.
+	Args     int
+	Variadic bool
+	// Value reports whether the builtin returns a value, and List
+	// whether that value is a list.
+	Value bool
+	List  bool
+	// Lists reports whether the builtin accepts lists as arguments;
+	// others take only strings.
+	Lists bool
+	// Call runs the builtin. It is nil for functions that only exist in
+	// generated code. Strings are Go strings and lists are
+	// []string, so values print the way the generated code prints them.
+	Call func(rt *Runtime, args []any) (any, error)
+}
+
+// Runtime is what builtins see of the running program.
+type Runtime struct {
+	Stdout io.Writer
+	// Args are the program arguments, without the program name.
+	Args []string
+}
+
+// RuntimeError is a failure of a program run without compiling it to Go,
+// such as a stack overflow, at the call that failed.
+type RuntimeError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: runtime error: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// MaxDepth limits nested calls in the interpreter, the VM and C
+// programs, standing in for the stack limit that stops runaway
+// recursion in Go programs.
+const MaxDepth = 10000
+
+// Builtins are the builtin functions by name.
+var Builtins = map[string]Builtin{
+	"print": {
+		Variadic: true,
+		Lists:    true,
+		Call: func(rt *Runtime, args []any) (any, error) {
+			_, err := fmt.Fprintln(rt.Stdout, args...)
+			return nil, err
+		},
+	},
+	"args": {
+		Value: true,
+		List:  true,
+		Call: func(rt *Runtime, args []any) (any, error) {
+			return append([]string{}, rt.Args...), nil
+		},
+	},
+}
+
+// CheckArgs reports whether b can be called with n arguments.
+func (b Builtin) CheckArgs(n int) bool {
+	return n == b.Args || (b.Variadic && n > b.Args)
+}
+
+// arity describes the arguments b takes, for error messages.
+func (b Builtin) arity() string {
+	switch {
+	case b.Variadic && b.Args == 0:
+		return "any number of arguments"
+	case b.Variadic:
+		return fmt.Sprintf("at least %d argument%s", b.Args, plural(b.Args))
+	case b.Args == 0:
+		return "no arguments"
+	default:
+		return fmt.Sprintf("%d argument%s", b.Args, plural(b.Args))
+	}
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
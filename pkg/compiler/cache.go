@@ -0,0 +1,174 @@
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cacheFormat is bumped whenever the cache layout or key inputs change.
+const cacheFormat = "2"
+
+// BuildCache stores generated Go and the built binary of a program under
+// a key derived from its sources, the compiler and the build options, so
+// unchanged programs are not rebuilt.
+type BuildCache struct {
+	dir string
+}
+
+// OpenBuildCache returns the cache in $XDG_CACHE_HOME/mob, falling back
+// to the platform user cache directory.
+func OpenBuildCache() (*BuildCache, error) {
+	root := os.Getenv("XDG_CACHE_HOME")
+	if root == "" {
+		var err error
+		root, err = os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate cache dir: %w", err)
+		}
+	}
+	return NewBuildCache(filepath.Join(root, "mob")), nil
+}
+
+func NewBuildCache(dir string) *BuildCache {
+	return &BuildCache{dir: dir}
+}
+
+func (bc *BuildCache) Dir() string {
+	return bc.dir
+}
+
+// Key hashes everything that affects the built binary. sources maps each
+// absolute source path to its content; paths are part of the key because
+// they are embedded in //line directives.
+func (bc *BuildCache) Key(version string, opts Options, sources map[string][]byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "format %s\nversion %s\nexecutable %s\noptions %+v\n", cacheFormat, version, executableFingerprint(), opts)
+
+	paths := make([]string, 0, len(sources))
+	for path := range sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(h, "source %s %d\n", path, len(sources[path]))
+		h.Write(sources[path])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (bc *BuildCache) entryDir(key string) string {
+	return filepath.Join(bc.dir, key[:2], key)
+}
+
+// Lookup returns the cached binary for key, if present.
+func (bc *BuildCache) Lookup(key, binaryName string) (string, bool) {
+	path := filepath.Join(bc.entryDir(key), binaryName)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return path, true
+}
+
+// Store saves the generated Go module files and the binary produced by
+// build into the entry for key and returns the cached binary path. The
+// entry is prepared in a private directory and renamed into place, so
+// concurrent builds of the same program never observe a partial entry.
+func (bc *BuildCache) Store(key, binaryName string, files map[string]string, build func(moduleDir, binaryPath string) error) (string, error) {
+	if err := os.MkdirAll(bc.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp(bc.dir, "tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache entry: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	moduleDir := filepath.Join(tmpDir, "src")
+	if err := writeFiles(moduleDir, files); err != nil {
+		return "", err
+	}
+	if err := build(moduleDir, filepath.Join(tmpDir, binaryName)); err != nil {
+		return "", err
+	}
+
+	entry := bc.entryDir(key)
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache entry: %w", err)
+	}
+	if err := os.Rename(tmpDir, entry); err != nil {
+		// Another build of the same program won the race.
+		if path, ok := bc.Lookup(key, binaryName); ok {
+			return path, nil
+		}
+		return "", fmt.Errorf("failed to store cache entry: %w", err)
+	}
+
+	return filepath.Join(entry, binaryName), nil
+}
+
+// Clean removes every cached entry and returns the number of bytes freed.
+func (bc *BuildCache) Clean() (int64, error) {
+	var freed int64
+	err := filepath.Walk(bc.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			freed += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.RemoveAll(bc.dir); err != nil {
+		return 0, fmt.Errorf("failed to remove cache: %w", err)
+	}
+	return freed, nil
+}
+
+var (
+	fingerprintOnce sync.Once
+	fingerprint     string
+)
+
+// executableFingerprint identifies the running mob binary so a rebuilt
+// compiler does not reuse binaries produced by an older codegen.
+func executableFingerprint() string {
+	fingerprintOnce.Do(func() {
+		exe, err := os.Executable()
+		if err != nil {
+			return
+		}
+		f, err := os.Open(exe)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return
+		}
+		fingerprint = hex.EncodeToString(h.Sum(nil))
+	})
+	return fingerprint
+}
+
+func binaryNameFor(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), ".mob")
+}
@@ -0,0 +1,434 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The C runtime is copied next to the generated code and compiled with
+// it; its functions are the C side of Builtins.
+var (
+	//go:embed cruntime/mob.h
+	cRuntimeHeader string
+	//go:embed cruntime/mob.c
+	cRuntimeSource string
+)
+
+// CGenerator generates C99 from the AST, as an alternative to the Go
+// code of CodeGenerator. Every Mob module becomes one C file; values are
+// reference-counted objects of the runtime in cruntime.
+type CGenerator struct {
+	// SourceFile is the .mob path written into #line directives so cc
+	// errors and debuggers report Mob positions. Directives are omitted
+	// when it is empty.
+	SourceFile string
+	// Module is the import path of the module to generate, empty for the
+	// entry file. The entry file also gets main, which runs the modules
+	// in Inits before its own top-level statements.
+	Module string
+	Inits  []string
+	// CFile is the path of the generated file in the build dir, used to
+	// switch positions back to the C code after directives for Mob code.
+	CFile string
+	// Modules are the program's modules by path. Calls to standard
+	// modules compile to their values.
+	Modules map[string]*Module
+	// Diagnostics holds the calls Generate could not compile to C.
+	Diagnostics Diagnostics
+
+	program   Node
+	functions map[string]Node
+	externs   map[string]bool
+}
+
+func NewCGenerator(program Node) *CGenerator {
+	return &CGenerator{
+		CFile:     "main.c",
+		program:   program,
+		functions: functionsOf(program),
+		externs:   make(map[string]bool),
+	}
+}
+
+// cFunction is a generated function: its C name and one line of code
+// per statement, kept with the node it came from.
+type cFunction struct {
+	name string
+	body []cStatement
+}
+
+type cStatement struct {
+	node Node
+	code string
+}
+
+func (cg *CGenerator) Generate() string {
+	init := cFunction{name: cInitName(cg.Module)}
+	var funcs []cFunction
+	seen := make(map[string]bool)
+	for _, stmt := range cg.program.Children {
+		if stmt.Type == NodeFunction {
+			// Duplicates were reported by the resolver; the first wins.
+			if !seen[stmt.Value] {
+				seen[stmt.Value] = true
+				funcs = append(funcs, cFunction{name: cFunctionName(cg.Module, stmt.Value), body: cg.generateBody(stmt.Children)})
+			}
+			continue
+		}
+		if code := cg.generateStatement(stmt); code != "" {
+			init.body = append(init.body, cStatement{node: stmt, code: code})
+		}
+	}
+	funcs = append([]cFunction{init}, funcs...)
+
+	w := &cWriter{line: 1}
+	w.printf("#include \"mob.h\"\n\n")
+
+	own := make(map[string]bool)
+	for _, fn := range funcs {
+		own[fn.name] = true
+	}
+	var externs []string
+	for name := range cg.externs {
+		if !own[name] {
+			externs = append(externs, name)
+		}
+	}
+	if cg.Module == "" {
+		for _, path := range cg.Inits {
+			externs = append(externs, cInitName(path))
+		}
+	}
+	sort.Strings(externs)
+	for _, name := range externs {
+		w.printf("void %s(void);\n", name)
+	}
+	for _, fn := range funcs {
+		w.printf("void %s(void);\n", fn.name)
+	}
+
+	for _, fn := range funcs {
+		w.printf("\nvoid %s(void)\n{\n", fn.name)
+		inMob := false
+		for _, stmt := range fn.body {
+			if cg.SourceFile != "" && stmt.node.Line > 0 {
+				w.printf("#line %d %s\n", stmt.node.Line, cString(cg.SourceFile))
+				inMob = true
+			}
+			w.printf("    %s\n", stmt.code)
+		}
+		if inMob {
+			// Lines after Mob statements belong to the generated file
+			// again, so errors there are recognized as codegen bugs.
+			w.printf("#line %d %s\n", w.line+1, cString(cg.CFile))
+		}
+		w.printf("}\n")
+	}
+
+	if cg.Module == "" {
+		w.printf("\nint main(int argc, char **argv)\n{\n    mob_runtime_init(argc, argv, %d);\n", MaxDepth)
+		for _, path := range cg.Inits {
+			w.printf("    %s();\n", cInitName(path))
+		}
+		w.printf("    %s();\n    return 0;\n}\n", cInitName(""))
+	}
+	return w.String()
+}
+
+// cWriter builds a C file and counts its lines for #line directives.
+type cWriter struct {
+	strings.Builder
+	line int
+}
+
+func (w *cWriter) printf(format string, args ...any) {
+	s := fmt.Sprintf(format, args...)
+	w.line += strings.Count(s, "\n")
+	w.WriteString(s)
+}
+
+func (cg *CGenerator) generateBody(stmts []Node) []cStatement {
+	var body []cStatement
+	for _, stmt := range stmts {
+		if code := cg.generateStatement(stmt); code != "" {
+			body = append(body, cStatement{node: stmt, code: code})
+		}
+	}
+	return body
+}
+
+func (cg *CGenerator) generateStatement(node Node) string {
+	switch node.Type {
+	case NodeCall:
+		call, value := cg.generateCall(node)
+		if call == "" {
+			return ""
+		}
+		if value {
+			// Results nobody uses are released right away.
+			return "mob_release(" + call + ");"
+		}
+		return call + ";"
+	case NodeReturn:
+		return "return;"
+	}
+	return ""
+}
+
+// generateCall returns the C expression for a call and whether it
+// produces an object, which the caller owns.
+func (cg *CGenerator) generateCall(node Node) (string, bool) {
+	if i := strings.LastIndex(node.Value, "."); i >= 0 {
+		path, name := node.Value[:i], node.Value[i+1:]
+		if mod := cg.Modules[path]; mod != nil && mod.Functions != nil {
+			value, ok := mod.Values[name]
+			if !ok {
+				d := diagnosticAt(CodeInternal, cg.SourceFile, node, "%s is not available in the c backend", node.Value)
+				d.Internal = true
+				cg.Diagnostics = append(cg.Diagnostics, d)
+				return "", false
+			}
+			return cStringObject(value), true
+		}
+		fn := cFunctionName(path, name)
+		cg.externs[fn] = true
+		return cg.counted(node, fn), false
+	}
+	if _, ok := cg.functions[node.Value]; ok {
+		return cg.counted(node, cFunctionName(cg.Module, node.Value)), false
+	}
+
+	builtin, ok := Builtins[node.Value]
+	if !ok {
+		// Unresolved calls were already reported by the resolver.
+		return "", false
+	}
+	args := cg.generateArgs(node.Children)
+	if builtin.Variadic {
+		args = append([]string{strconv.Itoa(len(args))}, args...)
+	}
+	return "mob_builtin_" + node.Value + "(" + strings.Join(args, ", ") + ")", true
+}
+
+// counted returns a call of the Mob function fn that the runtime
+// counts, so runaway recursion fails like it does in the interpreter.
+// The count also keeps cc from turning tail calls into loops.
+func (cg *CGenerator) counted(node Node, fn string) string {
+	return fmt.Sprintf("(mob_enter(%s, %d, %d), %s(), mob_leave())", cString(cg.SourceFile), node.Line, node.Column, fn)
+}
+
+func (cg *CGenerator) generateArgs(nodes []Node) []string {
+	var args []string
+	for _, node := range nodes {
+		switch node.Type {
+		case NodeString:
+			args = append(args, cStringObject(node.Value))
+		case NodeCall:
+			if call, value := cg.generateCall(node); value {
+				args = append(args, call)
+			}
+		}
+	}
+	return args
+}
+
+// cStringObject returns an expression creating a runtime string.
+func cStringObject(s string) string {
+	return fmt.Sprintf("mob_string_new(%s, %d)", cString(s), len(s))
+}
+
+// cString quotes s as a C string literal. Bytes other than printable
+// ASCII are octal escapes, which end after three digits, and ? is
+// escaped so no trigraph can form.
+func cString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\' || c == '?':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// cFunctionName is the C name of function name of the module at path:
+// mob_, the length-prefixed path segments, _ and the length-prefixed
+// name, such as mob_3lib7strings_5shout. cInitName uses "init" instead,
+// which cannot clash because it has no length. Runtime names never
+// have a digit or a second _ after mob_.
+func cFunctionName(path, name string) string {
+	mangled := cMangle(name)
+	return cModulePrefix(path) + "_" + strconv.Itoa(len(mangled)) + mangled
+}
+
+// cInitName is the C name of the function running the top-level
+// statements of the module at path.
+func cInitName(path string) string {
+	return cModulePrefix(path) + "_init"
+}
+
+func cModulePrefix(path string) string {
+	var b strings.Builder
+	b.WriteString("mob_")
+	if path != "" {
+		for _, segment := range strings.Split(path, ".") {
+			mangled := cMangle(segment)
+			b.WriteString(strconv.Itoa(len(mangled)) + mangled)
+		}
+	}
+	return b.String()
+}
+
+// cMangle turns a Mob identifier, which may have any Unicode letter,
+// into C identifier characters: _ becomes _u and bytes other than ASCII
+// letters and digits become _ and two hex digits.
+func cMangle(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '_':
+			b.WriteString("_u")
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "_%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// cFileName is the generated C file of the module at path, below the
+// modules dir so no module can replace main.c or the runtime.
+func cFileName(path string) string {
+	return filepath.Join(append([]string{"modules"}, strings.Split(path, ".")...)...) + ".c"
+}
+
+// generateC generates the C code of res and its modules. The entry runs
+// the modules in InitOrder first, like the Go runtime does for the
+// generated packages.
+func generateC(res *Result) {
+	byPath := make(map[string]*Module)
+	for _, mod := range res.Modules {
+		byPath[mod.Path] = mod
+	}
+
+	cg := NewCGenerator(res.AST)
+	cg.SourceFile = res.File
+	cg.Modules = byPath
+	for _, mod := range InitOrder(res) {
+		if mod.Functions == nil {
+			cg.Inits = append(cg.Inits, mod.Path)
+		}
+	}
+	res.CCode = cg.Generate()
+	res.Diagnostics = append(res.Diagnostics, cg.Diagnostics...)
+
+	for _, mod := range res.Modules {
+		if mod.Functions != nil {
+			continue
+		}
+		cg := NewCGenerator(mod.AST)
+		cg.SourceFile = mod.File
+		cg.Module = mod.Path
+		cg.CFile = filepath.ToSlash(cFileName(mod.Path))
+		cg.Modules = byPath
+		mod.CCode = cg.Generate()
+		res.Diagnostics = append(res.Diagnostics, cg.Diagnostics...)
+	}
+}
+
+// Backend is the language the compiler generates before building a
+// binary.
+type Backend string
+
+const (
+	BackendGo Backend = ""
+	BackendC  Backend = "c"
+)
+
+// CFiles returns the generated C program: main.c, one file per imported
+// Mob module and the runtime, keyed by slash-separated path.
+func (r *Result) CFiles() map[string]string {
+	files := map[string]string{
+		"main.c": r.CCode,
+		"mob.h":  cRuntimeHeader,
+		"mob.c":  cRuntimeSource,
+	}
+	for _, mod := range r.Modules {
+		if mod.Functions == nil {
+			files[filepath.ToSlash(cFileName(mod.Path))] = mod.CCode
+		}
+	}
+	return files
+}
+
+// cBuildError carries the stderr of a failed cc invocation. The
+// generated C is always valid, so any error is a compiler bug.
+type cBuildError struct {
+	err    error
+	stderr string
+}
+
+func (e *cBuildError) Error() string {
+	return fmt.Sprintf("internal compiler error: cc failed: %v\n%s", e.err, e.stderr)
+}
+
+// compileCCode builds the C program in files with the C compiler in $CC,
+// or cc.
+func (c *Compiler) compileCCode(ctx context.Context, files map[string]string, outputName string) error {
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	path, err := exec.LookPath(cc)
+	if err != nil {
+		return fmt.Errorf("C compiler %q not found: install a C99 compiler such as gcc or clang, or set CC", cc)
+	}
+
+	dir, err := c.buildDir()
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := writeFiles(dir, files); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if strings.HasSuffix(name, ".c") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	outputPath, _ := filepath.Abs(outputName)
+	args := []string{"-std=c99", "-O2", "-I.", "-o", outputPath}
+	if c.Release {
+		args = append(args, "-s")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, append(args, names...)...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return &cBuildError{err: err, stderr: stderr.String()}
+	}
+	return nil
+}
@@ -1,79 +1,306 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	gopath "path"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 type CodeGenerator struct {
-	program Node
+	// SourceFile is the .mob path written into //line directives so Go
+	// errors, panics and runtime.Caller report Mob positions. Directives
+	// are omitted when it is empty.
+	SourceFile string
+	// Package is the Go package to generate. The default, "main", runs
+	// top-level statements from main; any other package is an imported
+	// module and runs them from init.
+	Package string
+	// GoFile is the base name of the generated file, used to switch
+	// positions back to the Go code after directives for Mob code.
+	GoFile string
+
+	program   Node
+	imports   map[string]string
+	functions map[string]Node
+	positions map[ast.Node]Node
+	sourceMap *SourceMap
 }
 
 func NewCodeGenerator(program Node) *CodeGenerator {
 	return &CodeGenerator{
-		program: program,
+		Package:   "main",
+		GoFile:    "main.go",
+		program:   program,
+		imports:   make(map[string]string),
+		functions: functionsOf(program),
+		positions: make(map[ast.Node]Node),
 	}
 }
 
 func (cg *CodeGenerator) Generate() string {
-	var builder strings.Builder
+	var body []ast.Stmt
+	var funcs []ast.Decl
+	for _, stmt := range cg.program.Children {
+		if stmt.Type == NodeFunction {
+			funcs = append(funcs, cg.generateFunction(stmt))
+			continue
+		}
+		if s := cg.generateStatement(stmt); s != nil {
+			body = append(body, s)
+		}
+	}
 
-	builder.WriteString("package main\n\n")
-	builder.WriteString("import (\n")
-	builder.WriteString("    \"fmt\"\n")
-	builder.WriteString(")\n\n")
-	builder.WriteString("func main() {\n")
+	entry := "main"
+	if cg.Package != "main" {
+		entry = "init"
+	}
 
-	for _, stmt := range cg.program.Children {
-		builder.WriteString(cg.generateStatement(stmt, 1))
+	file := &ast.File{Name: ast.NewIdent(cg.Package)}
+	if decl := cg.importDecl(); decl != nil {
+		file.Decls = append(file.Decls, decl)
+	}
+	if cg.Package == "main" || len(body) > 0 {
+		file.Decls = append(file.Decls, &ast.FuncDecl{
+			Name: ast.NewIdent(entry),
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{List: body},
+		})
 	}
+	file.Decls = append(file.Decls, funcs...)
 
-	builder.WriteString("}\n")
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), file); err != nil {
+		panic(fmt.Sprintf("codegen produced invalid Go AST: %v", err))
+	}
 
-	return builder.String()
+	if cg.SourceFile == "" || len(cg.positions) == 0 {
+		return buf.String()
+	}
+	return cg.addLineDirectives(file, buf.Bytes())
 }
 
-func (cg *CodeGenerator) generateStatement(node Node, indent int) string {
-	var builder strings.Builder
-	indentStr := strings.Repeat("    ", indent)
+// addLineDirectives inserts a //line comment before every generated
+// statement that came from a Mob node and records the source map. The
+// formatted source is parsed back so nodes can be matched to their
+// printed positions; both trees have the same shape, so nodes pair up in
+// traversal order.
+func (cg *CodeGenerator) addLineDirectives(file *ast.File, src []byte) string {
+	fset := token.NewFileSet()
+	printed, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		panic(fmt.Sprintf("codegen produced unparsable Go: %v", err))
+	}
+
+	generated := collectNodes(file)
+	reparsed := collectNodes(printed)
+	if len(generated) != len(reparsed) {
+		panic("codegen output does not match its Go AST")
+	}
+
+	cg.sourceMap = &SourceMap{File: cg.SourceFile}
+	stmtByLine := make(map[int]int)
+	for i, n := range generated {
+		node, ok := cg.positions[n]
+		if !ok {
+			continue
+		}
+		pos := fset.Position(reparsed[i].Pos())
 
+		if _, isStmt := n.(ast.Stmt); isStmt {
+			stmtByLine[pos.Line] = len(cg.sourceMap.stmts)
+			cg.sourceMap.stmts = append(cg.sourceMap.stmts, mappedStmt{line: node.Line, column: node.Column})
+			continue
+		}
+		if idx, ok := stmtByLine[pos.Line]; ok {
+			stmt := &cg.sourceMap.stmts[idx]
+			stmt.exprs = append(stmt.exprs, mappedExpr{offset: pos.Column - 1, line: node.Line, column: node.Column})
+		}
+	}
+
+	directives := make(map[int]string)
+	for line, idx := range stmtByLine {
+		stmt := cg.sourceMap.stmts[idx]
+		directives[line] = fmt.Sprintf("//line %s:%d:%d", cg.SourceFile, stmt.line, stmt.column)
+	}
+
+	// Lines between Mob statements belong to the generated file again,
+	// so errors there are recognized as codegen bugs.
+	var out strings.Builder
+	outLine := 1
+	inMob := false
+	for i, line := range strings.SplitAfter(string(src), "\n") {
+		if directive, ok := directives[i+1]; ok {
+			out.WriteString(directive + "\n")
+			outLine++
+			inMob = true
+		} else if inMob && line != "" {
+			out.WriteString(fmt.Sprintf("//line %s:%d\n", cg.GoFile, outLine+1))
+			outLine++
+			inMob = false
+		}
+		out.WriteString(line)
+		outLine++
+	}
+	return out.String()
+}
+
+// SourceMap returns the mapping built by the last call to Generate, or
+// nil when no //line directives were emitted.
+func (cg *CodeGenerator) SourceMap() *SourceMap {
+	return cg.sourceMap
+}
+
+func collectNodes(file *ast.File) []ast.Node {
+	var nodes []ast.Node
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+		return true
+	})
+	return nodes
+}
+
+func (cg *CodeGenerator) importDecl() *ast.GenDecl {
+	if len(cg.imports) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(cg.imports))
+	for path := range cg.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	decl := &ast.GenDecl{Tok: token.IMPORT}
+	for _, path := range paths {
+		spec := &ast.ImportSpec{
+			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)},
+		}
+		if name := cg.imports[path]; name != gopath.Base(path) {
+			spec.Name = ast.NewIdent(name)
+		}
+		decl.Specs = append(decl.Specs, spec)
+	}
+	return decl
+}
+
+// use records that the generated program depends on the standard
+// package pkg and returns a selector for pkg.name.
+func (cg *CodeGenerator) use(pkg, name string) *ast.SelectorExpr {
+	cg.imports[pkg] = gopath.Base(pkg)
+	return &ast.SelectorExpr{X: ast.NewIdent(gopath.Base(pkg)), Sel: ast.NewIdent(name)}
+}
+
+// useModule records that the generated program depends on the Mob
+// module at path and returns a selector for its function fn.
+func (cg *CodeGenerator) useModule(path, fn string) *ast.SelectorExpr {
+	pkg := goPackageName(path)
+	cg.imports[goImportPath(path)] = pkg
+	return &ast.SelectorExpr{X: ast.NewIdent(pkg), Sel: ast.NewIdent(exportName(fn))}
+}
+
+func (cg *CodeGenerator) generateFunction(node Node) ast.Decl {
+	var body []ast.Stmt
+	for _, stmt := range node.Children {
+		if s := cg.generateStatement(stmt); s != nil {
+			body = append(body, s)
+		}
+	}
+
+	return &ast.FuncDecl{
+		Name: ast.NewIdent(exportName(node.Value)),
+		Type: &ast.FuncType{Params: &ast.FieldList{}},
+		Body: &ast.BlockStmt{List: body},
+	}
+}
+
+func (cg *CodeGenerator) generateStatement(node Node) ast.Stmt {
 	switch node.Type {
 	case NodeCall:
-		builder.WriteString(indentStr)
-		builder.WriteString(cg.generateCall(node))
-		builder.WriteString("\n")
+		call := cg.generateCall(node)
+		if call == nil {
+			return nil
+		}
+		if _, ok := call.(*ast.CallExpr); !ok {
+			// Builtins such as args() are plain Go expressions, which
+			// cannot stand alone as statements.
+			return at(cg, node, &ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{call},
+			})
+		}
+		return at(cg, node, &ast.ExprStmt{X: call})
+	case NodeReturn:
+		return at(cg, node, &ast.ReturnStmt{})
 	}
 
-	return builder.String()
+	return nil
 }
 
-func (cg *CodeGenerator) generateCall(node Node) string {
-	var builder strings.Builder
+// at records node as the Mob origin of the generated Go node.
+func at[T ast.Node](cg *CodeGenerator, node Node, generated T) T {
+	if node.Line > 0 {
+		cg.positions[generated] = node
+	}
+	return generated
+}
+
+func (cg *CodeGenerator) generateCall(node Node) ast.Expr {
+	if i := strings.LastIndex(node.Value, "."); i >= 0 {
+		return at(cg, node, &ast.CallExpr{
+			Fun:  cg.useModule(node.Value[:i], node.Value[i+1:]),
+			Args: cg.generateArgs(node.Children),
+		})
+	}
+	if _, ok := cg.functions[node.Value]; ok {
+		return at(cg, node, &ast.CallExpr{Fun: ast.NewIdent(exportName(node.Value))})
+	}
 
 	switch node.Value {
 	case "print":
-		builder.WriteString("fmt.Println(")
-		for i, arg := range node.Children {
-			if i > 0 {
-				builder.WriteString(", ")
-			}
-			builder.WriteString(cg.generateExpression(arg))
-		}
-		builder.WriteString(")")
+		return at(cg, node, &ast.CallExpr{
+			Fun:  cg.use("fmt", "Println"),
+			Args: cg.generateArgs(node.Children),
+		})
+	case "args":
+		return at(cg, node, &ast.SliceExpr{
+			X:   cg.use("os", "Args"),
+			Low: &ast.BasicLit{Kind: token.INT, Value: "1"},
+		})
 	default:
-		builder.WriteString(fmt.Sprintf("// Unknown function: %s\n", node.Value))
+		// Unresolved calls were already reported by the resolver.
+		return nil
 	}
+}
 
-	return builder.String()
+func (cg *CodeGenerator) generateArgs(nodes []Node) []ast.Expr {
+	var args []ast.Expr
+	for _, node := range nodes {
+		if expr := cg.generateExpression(node); expr != nil {
+			args = append(args, expr)
+		}
+	}
+	return args
 }
 
-func (cg *CodeGenerator) generateExpression(node Node) string {
+func (cg *CodeGenerator) generateExpression(node Node) ast.Expr {
 	switch node.Type {
 	case NodeString:
-		return fmt.Sprintf("%q", node.Value)
+		return at(cg, node, &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(node.Value)})
 	case NodeIdentifier:
-		return node.Value
+		return at(cg, node, ast.NewIdent(node.Value))
+	case NodeCall:
+		return cg.generateCall(node)
 	default:
-		return ""
+		return nil
 	}
 }
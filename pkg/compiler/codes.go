@@ -0,0 +1,304 @@
+package compiler
+
+import "sort"
+
+// Codes identify each kind of diagnostic in machine-readable output and
+// in mob explain. They are stable: a code keeps its meaning and is
+// never reused. E01xx are syntax errors, E02xx are names, E03xx are
+// imports and E09xx are problems of the compiler itself.
+const (
+	CodeSyntax             = "E0101"
+	CodeIndentation        = "E0102"
+	CodeTrailingTokens     = "E0103"
+	CodeNestedFunction     = "E0104"
+	CodeParameters         = "E0105"
+	CodeReturnOutside      = "E0106"
+	CodeReturnValue        = "E0107"
+	CodeUndefined          = "E0201"
+	CodeRedefined          = "E0202"
+	CodeNoSuchFunction     = "E0203"
+	CodeImportedAndDefined = "E0204"
+	CodeImportedTwice      = "E0205"
+	CodeArguments          = "E0206"
+	CodeNoValue            = "E0207"
+	CodeListArgument       = "E0208"
+	CodeImportNotFound     = "E0301"
+	CodeImportsUnavailable = "E0302"
+	CodeImportCycle        = "E0303"
+	CodeImportNotTopLevel  = "E0304"
+	CodeInternal           = "E0901"
+	CodeToolchain          = "E0902"
+)
+
+// Explanation is the long-form description of a code that mob explain
+// prints.
+type Explanation struct {
+	Code  string
+	Title string
+	Text  string
+}
+
+// Explain returns the explanation of code.
+func Explain(code string) (Explanation, bool) {
+	e, ok := explanations[code]
+	return e, ok
+}
+
+// Explanations returns every explanation, sorted by code.
+func Explanations() []Explanation {
+	list := make([]Explanation, 0, len(explanations))
+	for _, e := range explanations {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+var explanations = map[string]Explanation{}
+
+func explain(code, title, text string) {
+	explanations[code] = Explanation{Code: code, Title: title, Text: text}
+}
+
+func init() {
+	explain(CodeSyntax, "syntax error", `The parser found a token where it cannot be.
+
+Erroneous code example:
+
+    print("hello"
+
+Every statement is a call, an import, a function definition or a
+return, and calls need balanced parentheses:
+
+    print("hello")
+`)
+	explain(CodeIndentation, "unexpected indentation", `A line is indented more than the block it belongs to.
+
+Erroneous code example:
+
+    print("a")
+        print("b")
+
+Indentation opens a block only after a line ending in ':', such as a
+function definition. Statements of the same block start at the same
+column:
+
+    print("a")
+    print("b")
+`)
+	explain(CodeTrailingTokens, "expected end of line", `A statement that must stand alone on its line is followed by more code.
+
+Erroneous code example:
+
+    import utils print("x")
+
+Imports, function headers and return take a line of their own:
+
+    import utils
+    print("x")
+`)
+	explain(CodeNestedFunction, "function defined inside a function", `Functions can only be defined at the top level of a file.
+
+Erroneous code example:
+
+    function outer():
+        function inner():
+            print("x")
+
+Define both functions at the top level and call one from the other:
+
+    function inner():
+        print("x")
+
+    function outer():
+        inner()
+`)
+	explain(CodeParameters, "function parameters are not supported", `Functions do not take parameters yet.
+
+Erroneous code example:
+
+    function greet(name):
+        print(name)
+
+Until parameters are supported, functions take no arguments:
+
+    function greet():
+        print("hello")
+`)
+	explain(CodeReturnOutside, "return outside function", `A return statement appears at the top level of a file.
+
+Erroneous code example:
+
+    print("done")
+    return
+
+return ends a function, so it is only valid inside a function body.
+Top-level statements run until the end of the file.
+`)
+	explain(CodeReturnValue, "return values are not supported", `A return statement has a value.
+
+Erroneous code example:
+
+    function answer():
+        return "42"
+
+Functions do not return values yet; return takes nothing and only ends
+the function early. Print the value instead:
+
+    function answer():
+        print("42")
+`)
+	explain(CodeUndefined, "undefined name", `A name is used but nothing defines or imports it.
+
+Erroneous code example:
+
+    greet()
+
+Define the function in the same file, import it with
+'from module import name', or import the module and call it qualified.
+A qualified call such as strings.upper() needs 'import strings', even
+when the module is already loaded by another import:
+
+    function greet():
+        print("hello")
+
+    greet()
+`)
+	explain(CodeRedefined, "function defined twice", `Two functions of the same file have the same name.
+
+Erroneous code example:
+
+    function greet():
+        print("hello")
+
+    function greet():
+        print("hi")
+
+Each function needs its own name; calls use the first definition and
+the second one is an error. Rename or remove one of them.
+`)
+	explain(CodeNoSuchFunction, "module has no such function", `A call or from-import names a function the module does not define.
+
+Erroneous code example:
+
+    from utils import helpr
+
+Check the spelling against the functions defined in the module's file:
+
+    from utils import helper
+`)
+	explain(CodeImportedAndDefined, "imported name is also defined", `A from-imported name is also the name of a function of the same file.
+
+Erroneous code example:
+
+    from utils import helper
+
+    function helper():
+        print("local")
+
+A call to helper() could mean either one. Rename the local function,
+or import the module and call the other one qualified, utils.helper().
+`)
+	explain(CodeImportedTwice, "name imported from two modules", `Two from-imports bring in the same name from different modules.
+
+Erroneous code example:
+
+    from a import run
+    from b import run
+
+Import one of them by name and call the other qualified:
+
+    import b
+    from a import run
+
+    run()
+    b.run()
+`)
+	explain(CodeArguments, "wrong number of arguments", `A function is called with a number of arguments it does not take.
+
+Erroneous code example:
+
+    function greet():
+        print("hello")
+
+    greet("world")
+
+Functions defined in Mob take no arguments. Builtins and the functions
+of standard modules take the number the message states; print takes
+any number.
+`)
+	explain(CodeNoValue, "function returns nothing", `The result of a call that returns nothing is used as a value.
+
+Erroneous code example:
+
+    print(print("x"))
+
+Only builtins such as args() and some functions of standard modules
+return values. Call functions that return nothing as statements:
+
+    print("x")
+`)
+	explain(CodeListArgument, "list passed where a string is needed", `A list, such as the result of args(), is passed to a function that
+takes strings.
+
+Erroneous code example:
+
+    import js
+
+    js.call("alert", args())
+
+Only print takes lists. The other builtins and the functions of
+standard modules take strings.
+`)
+	explain(CodeImportNotFound, "cannot import module", `An imported module could not be found or read.
+
+Erroneous code example:
+
+    import utilz
+
+import a.b looks for a/b.mob in the directory of the importing file
+and in the source directories of the project (sources in mob.toml).
+The first part may also name a dependency or a standard module. The
+message lists the paths that were tried.
+`)
+	explain(CodeImportsUnavailable, "imports are not available", `The file was compiled without a way to load other files, such as
+from standard input, so it cannot import modules other than the
+standard ones. Compile it from a file on disk instead.
+`)
+	explain(CodeImportCycle, "import cycle", `Modules import each other in a cycle.
+
+Erroneous code example:
+
+    # a.mob
+    import b
+
+    # b.mob
+    import a
+
+Modules initialize in dependency order, which a cycle makes
+impossible. The notes show each import of the cycle; move the shared
+functions to a third module that both import.
+`)
+	explain(CodeImportNotTopLevel, "import inside a function", `An import statement appears inside a function body.
+
+Erroneous code example:
+
+    function run():
+        import utils
+
+Imports apply to the whole file, so they go at its top level:
+
+    import utils
+
+    function run():
+        utils.helper()
+`)
+	explain(CodeInternal, "internal compiler error", `The compiler produced code that does not build, or failed while
+generating it. This is a bug in the compiler, not in the program.
+Please report it with the program that triggers it.
+`)
+	explain(CodeToolchain, "error reported by the Go toolchain", `go build rejected the generated code for a reason the compiler does
+not check yet. The position points at the Mob code that produced the
+rejected Go code.
+`)
+}
@@ -1,66 +1,614 @@
 package compiler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-type Compiler struct{}
+// Options configures a compilation.
+type Options struct {
+	// Emit stops the pipeline after the given stage. Compile writes the
+	// stage result to Stdout instead of building a binary.
+	Emit EmitMode
+	// Target is the platform Compile builds for; the zero Target is the
+	// host.
+	Target Target
+	// CGO enables cgo. Builds are static (CGO_ENABLED=0) by default so
+	// binaries run on any machine of their target.
+	CGO bool
+	// Build is what the mob.build module reports to the program.
+	Build BuildInfo
+	// Release strips symbols and file system paths from the binary.
+	Release bool
+	// Reproducible builds without file system paths, then builds again
+	// and fails unless both binaries are identical.
+	Reproducible bool
+	// Backend is the code the program is compiled through: Go, built
+	// with the Go toolchain, or C, built with the system cc.
+	Backend Backend
+}
+
+type Compiler struct {
+	Options
+	// Stdin, Stdout and Stderr are connected to programs started by
+	// CompileAndRun; Stdout also receives Emit output.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Importer resolves imports for CompileSource. Compile looks next to
+	// the entry file and then in SourceDirs, or ProjectRoot when there
+	// are none, instead.
+	Importer    Importer
+	ProjectRoot string
+	SourceDirs  []string
+	// Packages are the project's dependencies.
+	Packages []Package
+
+	// Version is the compiler version, part of the build cache key.
+	Version string
+	// Cache, when set, lets CompileAndRun reuse binaries of unchanged
+	// programs.
+	Cache *BuildCache
+
+	// GoRoot is the Go installation from the manifest. MOB_GOROOT takes
+	// precedence, and go is looked up on PATH when both are unset.
+	GoRoot      string
+	toolchainMu sync.Mutex
+	goToolchain *Toolchain
+
+	// LookupCommit, when set, computes Build.Commit the first time a
+	// program imports mob.build, so other programs never wait for the
+	// VCS. An explicit Build.Commit wins.
+	LookupCommit func() string
+	commitOnce   sync.Once
+	commit       string
+
+	// buildRoot is the private directory of a reproducible build, made
+	// by Compile and removed when it returns.
+	buildRoot string
+}
 
 func NewCompiler() *Compiler {
-	return &Compiler{}
+	return &Compiler{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
 }
 
-func (c *Compiler) CompileAndRun(filename string) error {
-	binaryName := "temp_" + filepath.Base(filename) + "_bin"
+// Result holds the output of every pipeline stage that ran. Stages after
+// opts.Emit, or after a stage that reported errors, are left empty.
+// File, Tokens, AST, GoCode, SourceMap and CCode describe the entry
+// file; only the code of the compiler's Backend is generated. LLVMCode
+// is the whole program, generated instead for EmitLLVM. Modules are the
+// files the entry imports, directly or not, in the order first imported.
+type Result struct {
+	File        string
+	Tokens      []Token
+	AST         Node
+	Diagnostics Diagnostics
+	GoCode      string
+	SourceMap   *SourceMap
+	CCode       string
+	LLVMCode    string
+	Modules     []*Module
+}
 
-	if err := c.Compile(filename, binaryName); err != nil {
-		return err
+// GoFiles returns the generated Go module: go.mod, main.go and one
+// package per imported Mob module, keyed by slash-separated path.
+func (r *Result) GoFiles() map[string]string {
+	files := map[string]string{
+		"go.mod":  "module " + goModulePath + "\n\ngo 1.21\n",
+		"main.go": r.GoCode,
+	}
+	for _, mod := range r.Modules {
+		files[filepath.ToSlash(goFileName(mod.Path))] = mod.GoCode
 	}
-	defer os.Remove(binaryName)
+	return files
+}
 
-	cmd := exec.Command("./" + binaryName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// sourceMaps returns the source map of every generated file, keyed by
+// the .mob file it came from.
+func (r *Result) sourceMaps() map[string]*SourceMap {
+	maps := make(map[string]*SourceMap)
+	if r.SourceMap != nil {
+		maps[r.SourceMap.File] = r.SourceMap
+	}
+	for _, mod := range r.Modules {
+		if mod.SourceMap != nil {
+			maps[mod.SourceMap.File] = mod.SourceMap
+		}
+	}
+	return maps
+}
+
+// CompileSource runs the compiler on in-memory source without touching
+// the filesystem. name is used for diagnostics and //line directives,
+// and imports are resolved through the compiler's Importer. Problems in
+// the program are reported in Result.Diagnostics; the error is only set
+// when ctx is done.
+func (c *Compiler) CompileSource(ctx context.Context, name, src string, opts Options) (*Result, error) {
+	return c.compileSource(ctx, name, src, opts, c.Importer)
+}
+
+func (c *Compiler) compileSource(ctx context.Context, name, src string, opts Options, importer Importer) (*Result, error) {
+	res := &Result{File: name}
+
+	res.Tokens = c.Tokenize(src)
+	if opts.Emit == EmitTokens {
+		return res, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	parser := NewParser(res.Tokens)
+	res.AST = parser.Parse()
+	for _, d := range parser.Errors() {
+		d.File = name
+		res.Diagnostics = append(res.Diagnostics, d)
+	}
+	if opts.Emit == EmitAST || opts.Emit == EmitASTJSON || res.Diagnostics.HasErrors() {
+		return res, nil
+	}
+
+	modules, diags := c.loadModules(ctx, name, res.AST, opts, importer)
+	res.Modules = modules
+	res.Diagnostics = append(res.Diagnostics, diags...)
+	res.Diagnostics = append(res.Diagnostics, importCycles(c.ProjectRoot, name, res.AST, modules)...)
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	byPath := make(map[string]*Module)
+	for _, mod := range modules {
+		byPath[mod.Path] = mod
+	}
+	res.Diagnostics = append(res.Diagnostics, resolveModule(name, &res.AST, byPath)...)
+	for _, mod := range modules {
+		res.Diagnostics = append(res.Diagnostics, resolveModule(mod.File, &mod.AST, byPath)...)
+	}
+	if res.Diagnostics.HasErrors() {
+		return res, nil
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				res.Diagnostics = append(res.Diagnostics, Diagnostic{
+					File:     name,
+					Code:     CodeInternal,
+					Severity: SeverityError,
+					Message:  fmt.Sprint(r),
+					Internal: true,
+				})
+			}
+		}()
+		if opts.Emit == EmitLLVM {
+			res.LLVMCode = generateLLVM(res)
+			return
+		}
+		if opts.Backend == BackendC || opts.Emit == EmitC {
+			generateC(res)
+			return
+		}
+		res.GoCode, res.SourceMap = c.GenerateGo(res.AST, name)
+		for _, mod := range modules {
+			if mod.Functions != nil {
+				continue
+			}
+			codegen := NewCodeGenerator(mod.AST)
+			codegen.SourceFile = mod.File
+			codegen.Package = goPackageName(mod.Path)
+			codegen.GoFile = filepath.Base(goFileName(mod.Path))
+			mod.GoCode = codegen.Generate()
+			mod.SourceMap = codegen.SourceMap()
+		}
+	}()
+
+	return res, nil
 }
 
 func (c *Compiler) Compile(filename string, outputName string) error {
+	if c.Backend == BackendC && c.Emit == EmitGo {
+		return errors.New("the c backend generates no Go code; use --emit=c")
+	}
+	// The C runtime only targets the host, and the modules of other
+	// targets have no C counterpart.
+	if c.Backend == BackendC && c.Target != (Target{}) {
+		return fmt.Errorf("the c backend builds for the host only, not %s", c.Target)
+	}
+
 	source, err := os.ReadFile(filename)
 	if err != nil {
 		return fmt.Errorf("failed to read source file: %w", err)
 	}
 
-	lexer := NewLexer(string(source))
-	tokens := lexer.Tokenize()
+	res, err := c.compileFile(filename, source)
+	if err != nil {
+		return err
+	}
+
+	switch c.Emit {
+	case EmitTokens:
+		err = writeTokens(c.Stdout, res.Tokens)
+	case EmitAST:
+		err = writeAST(c.Stdout, res.AST, 0)
+	case EmitASTJSON:
+		err = writeASTJSON(c.Stdout, res.AST)
+	}
+	if err != nil {
+		return err
+	}
+
+	if res.Diagnostics.HasErrors() {
+		return res.Diagnostics
+	}
 
-	parser := NewParser(tokens)
-	program := parser.Parse()
+	switch c.Emit {
+	case EmitNone:
+	case EmitGo:
+		return writeGoFiles(c.Stdout, res)
+	case EmitC:
+		return writeCFiles(c.Stdout, res)
+	case EmitLLVM:
+		_, err := io.WriteString(c.Stdout, res.LLVMCode)
+		return err
+	default:
+		return nil
+	}
+
+	if c.Reproducible {
+		// A directory of its own, not a predictable shared path other
+		// users could plant files in.
+		root, err := os.MkdirTemp("", "mob_build_*")
+		if err != nil {
+			return fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		c.buildRoot = root
+		defer func() {
+			os.RemoveAll(root)
+			c.buildRoot = ""
+		}()
+	}
+	if err := c.build(context.Background(), res, filename, outputName); err != nil {
+		return err
+	}
+	if c.Target.OS == "js" {
+		if err := c.writeWasmExec(context.Background(), filepath.Dir(outputName)); err != nil {
+			return err
+		}
+	}
+	if c.Reproducible {
+		return c.verifyReproducible(filename, source, outputName)
+	}
+	return nil
+}
 
+// verifyReproducible runs the whole pipeline again, from the source, and
+// checks that the second binary is identical to the first one.
+func (c *Compiler) verifyReproducible(filename string, source []byte, outputName string) error {
+	res, err := c.compileFile(filename, source)
+	if err != nil {
+		return err
+	}
+	if res.Diagnostics.HasErrors() {
+		return res.Diagnostics
+	}
+
+	check := outputName + ".check"
+	defer os.Remove(check)
+	if err := c.build(context.Background(), res, filename, check); err != nil {
+		return err
+	}
+
+	first, err := fileDigest(outputName)
+	if err != nil {
+		return err
+	}
+	second, err := fileDigest(check)
+	if err != nil {
+		return err
+	}
+	if first != second {
+		return fmt.Errorf("build is not reproducible: two builds of %s produced different binaries (sha256 %s and %s)", filename, first, second)
+	}
+	return nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// compileFile compiles a file read from disk, resolving imports next to
+// it and then in the project's source dirs. //line directives need absolute
+// paths; diagnostics show the name the user passed.
+func (c *Compiler) compileFile(filename string, source []byte) (*Result, error) {
+	sourceFile, _ := filepath.Abs(filename)
+
+	importer := c.Importer
+	if importer == nil {
+		roots := c.SourceDirs
+		if len(roots) == 0 {
+			roots = []string{c.ProjectRoot}
+		}
+		importer = DirImporter{Roots: append([]string{filepath.Dir(sourceFile)}, roots...), Packages: c.Packages}
+	}
+
+	res, err := c.compileSource(context.Background(), sourceFile, string(source), c.Options, importer)
+	if err != nil {
+		return nil, err
+	}
+
+	display := displayName(filename)
+	for i := range res.Diagnostics {
+		d := &res.Diagnostics[i]
+		d.File = display(d.File)
+		for j := range d.Notes {
+			d.Notes[j].File = display(d.Notes[j].File)
+		}
+	}
+	return res, nil
+}
+
+// Check runs the front end on a file without building it and returns
+// what it reports: syntax errors, unresolved names and import cycles.
+func (c *Compiler) Check(filename string) (Diagnostics, error) {
+	res, err := c.Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	return res.Diagnostics, nil
+}
+
+// Load reads a file and its imports and checks them like Compile does,
+// without building anything. When Result.Diagnostics has no errors the
+// result holds the resolved AST of every module.
+func (c *Compiler) Load(filename string) (*Result, error) {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file: %w", err)
+	}
+	return c.compileFile(filename, source)
+}
+
+// displayName returns a function that shortens .mob paths for messages:
+// the entry file keeps the name the user passed and other files are
+// shown relative to the working directory when they are below it.
+func displayName(entry string) func(string) string {
+	entryAbs, _ := filepath.Abs(entry)
+	cwd, _ := os.Getwd()
+
+	return func(file string) string {
+		if file == entryAbs {
+			return entry
+		}
+		if rel, err := filepath.Rel(cwd, file); err == nil && filepath.IsAbs(file) && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+		return file
+	}
+}
+
+func (c *Compiler) Tokenize(source string) []Token {
+	return NewLexer(source).Tokenize()
+}
+
+func (c *Compiler) Parse(tokens []Token) Node {
+	return NewParser(tokens).Parse()
+}
+
+// GenerateGo returns the Go program for the AST. When sourceFile is set
+// the code carries //line directives for it and the returned source map
+// resolves Go toolchain positions back to the AST.
+func (c *Compiler) GenerateGo(program Node, sourceFile string) (string, *SourceMap) {
 	codegen := NewCodeGenerator(program)
+	codegen.SourceFile = sourceFile
 	goCode := codegen.Generate()
+	return goCode, codegen.SourceMap()
+}
 
-	return c.compileGoCode(goCode, outputName)
+// build compiles the generated code of res into outputName with the
+// toolchain of the compiler's backend.
+func (c *Compiler) build(ctx context.Context, res *Result, filename, outputName string) error {
+	if c.Backend == BackendC {
+		return c.compileCCode(ctx, res.CFiles(), outputName)
+	}
+	if err := c.checkTarget(ctx); err != nil {
+		return err
+	}
+	if err := c.compileGoCode(ctx, res.GoFiles(), outputName); err != nil {
+		return translateBuildError(err, res, displayName(filename))
+	}
+	return nil
 }
 
-func (c *Compiler) compileGoCode(goCode string, outputName string) error {
-	tempDir, err := os.MkdirTemp("", "mob_compile_*")
+// goBuildError carries the stderr of a failed go build invocation, and
+// the dir it ran in, which the paths it reports can be relative to.
+type goBuildError struct {
+	err    error
+	stderr string
+	dir    string
+}
+
+func (e *goBuildError) Error() string {
+	return fmt.Sprintf("go build failed: %v\n%s", e.err, e.stderr)
+}
+
+func (c *Compiler) compileGoCode(ctx context.Context, files map[string]string, outputName string) error {
+	tempDir, err := c.buildDir()
 	if err != nil {
 		return fmt.Errorf("failed to create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
-	tempGoFile := filepath.Join(tempDir, "main.go")
-	if err := os.WriteFile(tempGoFile, []byte(goCode), 0644); err != nil {
-		return fmt.Errorf("failed to write temp Go file: %w", err)
+	if err := writeFiles(tempDir, files); err != nil {
+		return err
 	}
 
 	outputPath, _ := filepath.Abs(outputName)
-	cmd := exec.Command("go", "build", "-o", outputPath, tempGoFile)
+	return c.buildGoModule(ctx, tempDir, outputPath)
+}
+
+// buildDir creates the directory the generated code is built in.
+// Reproducible builds use the same path below buildRoot for both
+// builds, so paths recorded in the binary match.
+func (c *Compiler) buildDir() (string, error) {
+	if c.buildRoot == "" {
+		return os.MkdirTemp("", "mob_compile_*")
+	}
+	dir := filepath.Join(c.buildRoot, "src")
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	return dir, os.Mkdir(dir, 0700)
+}
+
+func writeFiles(dir string, files map[string]string) error {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create build dir: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write generated file: %w", err)
+		}
+	}
+	return nil
+}
+
+// buildGoModule builds the generated module in dir for the compiler's
+// target. Release builds drop symbol tables and file system paths.
+// Workspaces are disabled so a go.work around the user's project cannot
+// leak in.
+func (c *Compiler) buildGoModule(ctx context.Context, dir string, outputPath string) error {
+	tc, err := c.toolchain(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	args := []string{"build", "-o", outputPath}
+	switch {
+	case c.Release:
+		args = append(args, "-trimpath", "-ldflags=-s -w")
+	case c.Reproducible:
+		// The build dir is private to each run; keep it out of the
+		// binary so runs on any machine can be compared.
+		args = append(args, "-trimpath")
+	}
+	cmd := tc.command(ctx, append(args, ".")...)
+	cmd.Dir = dir
+	cmd.Env = append(cmd.Env, "GOWORK=off")
+	cmd.Env = append(cmd.Env, c.Target.env()...)
+	if !c.CGO {
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
+	}
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return &goBuildError{err: err, stderr: stderr.String(), dir: dir}
+	}
+	return nil
+}
+
+var goErrorPattern = regexp.MustCompile(`^(.+?):(\d+):(\d+): (.*)$`)
+
+// translateBuildError turns go build output into Mob diagnostics.
+// Positions inside the .mob source (reported through //line directives)
+// are resolved through the source map; anything reported against the
+// generated Go file, or that the Go parser rejected, means codegen
+// produced bad code and is flagged as an internal compiler error.
+func translateBuildError(err error, res *Result, display func(string) string) error {
+	buildErr, ok := err.(*goBuildError)
+	if !ok {
+		return err
+	}
+
+	sourceMaps := res.sourceMaps()
 
-	return cmd.Run()
+	var diags Diagnostics
+	for _, line := range strings.Split(buildErr.stderr, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "\t") && len(diags) > 0 {
+			last := &diags[len(diags)-1]
+			last.Message += "\n" + strings.TrimPrefix(line, "\t")
+			continue
+		}
+
+		m := goErrorPattern.FindStringSubmatch(line)
+		if m == nil {
+			diags = append(diags, Diagnostic{Code: CodeInternal, Severity: SeverityError, Message: line, Internal: true})
+			continue
+		}
+
+		lineNo, _ := strconv.Atoi(m[2])
+		column, _ := strconv.Atoi(m[3])
+		message := m[4]
+
+		// go build shortens paths below the parent of its dir, such as
+		// ../src/main.mob; source maps are keyed by absolute path.
+		file := m[1]
+		if !filepath.IsAbs(file) && buildErr.dir != "" {
+			file = filepath.Join(buildErr.dir, file)
+		}
+		if sourceMap, ok := sourceMaps[file]; ok {
+			lineNo, column = sourceMap.Resolve(lineNo, column)
+			d := Diagnostic{
+				File:     display(file),
+				Line:     lineNo,
+				Column:   column,
+				Code:     CodeToolchain,
+				Severity: SeverityError,
+				Message:  message,
+				Internal: strings.HasPrefix(message, "syntax error"),
+			}
+			if d.Internal {
+				d.Code = CodeInternal
+			}
+			diags = append(diags, d)
+			continue
+		}
+
+		diags = append(diags, Diagnostic{
+			File:     filepath.Clean(m[1]),
+			Line:     lineNo,
+			Column:   column,
+			Code:     CodeInternal,
+			Severity: SeverityError,
+			Message:  message,
+			Internal: true,
+		})
+	}
+
+	if len(diags) == 0 {
+		return err
+	}
+	return diags
 }
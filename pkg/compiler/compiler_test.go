@@ -1,8 +1,19 @@
 package compiler
 
 import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -87,6 +98,136 @@ func TestLexer(t *testing.T) {
 	}
 }
 
+func TestLexerComments(t *testing.T) {
+	source := "# header\nfunction f():\n    print(\"#\") # trailing\n# at column 1\n    print(\"b\")\n\r\nf()\n"
+	lexer := NewLexer(source)
+	program := NewParser(lexer.Tokenize()).Parse()
+
+	comments := lexer.Comments()
+	want := []Token{
+		{Type: TokenComment, Value: "# header", Line: 1, Column: 1},
+		{Type: TokenComment, Value: "# trailing", Line: 3, Column: 16},
+		{Type: TokenComment, Value: "# at column 1", Line: 4, Column: 1},
+	}
+	if len(comments) != len(want) {
+		t.Fatalf("Expected %d comments, got %v", len(want), comments)
+	}
+	for i := range want {
+		if comments[i] != want[i] {
+			t.Errorf("comment %d: expected %v, got %v", i, want[i], comments[i])
+		}
+	}
+
+	// A comment line does not end the function body.
+	if len(program.Children) != 2 || len(program.Children[0].Children) != 2 {
+		t.Errorf("Unexpected AST: %+v", program)
+	}
+	if arg := program.Children[0].Children[0].Children[0]; arg.Value != "#" {
+		t.Errorf("Expected the string \"#\", got %q", arg.Value)
+	}
+}
+
+func TestParseCallsAcrossLines(t *testing.T) {
+	source := "\n# header\nprint(\n    \"a\",\n    upper(\"b\"),\n)\nprint(\"c\nd\")\nprint(\"e\")\n"
+	parser := NewParser(NewLexer(source).Tokenize())
+	program := parser.Parse()
+	if errs := parser.Errors(); len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+
+	// Newlines inside parentheses do not end the statement.
+	if len(program.Children) != 3 || len(program.Children[0].Children) != 2 {
+		t.Fatalf("Unexpected AST: %+v", program)
+	}
+	// A string spanning lines moves the lines after it.
+	if last := program.Children[2]; last.Line != 9 {
+		t.Errorf("Expected the last call on line 9, got %d", last.Line)
+	}
+
+	for _, source := range []string{`print("abc`, `print("abc\")`} {
+		parser := NewParser(NewLexer(source).Tokenize())
+		parser.Parse()
+		if errs := parser.Errors(); len(errs) == 0 || errs[0].Message != "unterminated string" {
+			t.Errorf("%s: expected an unterminated string, got %v", source, errs)
+		}
+	}
+}
+
+func TestSyntaxRoundTrip(t *testing.T) {
+	sources := []string{
+		"",
+		"# only a comment",
+		"\n\n  # indented comment\n\nprint(\"a\")",
+		"import lib.strings  # trailing\r\nfrom utils import a ,b\r\n",
+		"function f( ) :\n\tprint( \"x\" )   \n\n    # tail\n\nf\n",
+		"print(  # why\n    \"a\",\n    upper( \"b\nc\" ),\n)  # done\n",
+		"x = 1 + 2\nprint(\"unterminated\n",
+		"function f():\n    function g():\n        return 1\n  print(\n",
+		"print(\"\\\"\") ) :",
+	}
+	examples, err := filepath.Glob(filepath.Join("..", "..", "examples", "*.mob"))
+	if err != nil || len(examples) == 0 {
+		t.Fatalf("no examples: %v", err)
+	}
+	for _, file := range examples {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, string(src))
+	}
+
+	for _, source := range sources {
+		lexer := NewLexer(source)
+		lexer.Trivia = true
+		tokens := lexer.Tokenize()
+		parser := NewParser(tokens)
+		syntax := parser.ParseSyntax()
+
+		if got := syntax.Text(); got != source {
+			t.Errorf("round trip of %q gave %q", source, got)
+		}
+		if got := syntax.Tokens(); !reflect.DeepEqual(got, tokens) {
+			t.Errorf("%q: the tree does not hold every token once, in order", source)
+		}
+		// The AST is the same with or without trivia.
+		if got, want := syntax.AST(), NewParser(NewLexer(source).Tokenize()).Parse(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: AST from the syntax tree is %+v, want %+v", source, got, want)
+		}
+	}
+}
+
+func TestTrivia(t *testing.T) {
+	lexer := NewLexer("  # c\nprint( \"a\" )  # t\r\n")
+	lexer.Trivia = true
+	tokens := lexer.Tokenize()
+	var got []string
+	for _, tok := range tokens {
+		got = append(got, fmt.Sprintf("%q %q %q", tok.Leading, tok.Value, tok.Trailing))
+	}
+	want := []string{
+		`"  # c" "\n" ""`,
+		`"" "print" ""`,
+		`"" "(" " "`,
+		`"" "\"a\"" " "`,
+		`"" ")" "  # t\r"`,
+		`"" "\n" ""`,
+		`"" "" ""`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected tokens\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	syntax := NewParser(tokens).ParseSyntax()
+	var kinds []string
+	for _, child := range syntax.Children {
+		kinds = append(kinds, child.Kind.String())
+	}
+	if want := "Token Call Token Token"; strings.Join(kinds, " ") != want {
+		t.Errorf("Expected file children %s, got %v", want, kinds)
+	}
+}
+
 func TestGenerateCode(t *testing.T) {
 	source := `print("Hello World!")`
 
@@ -107,3 +248,1319 @@ func TestGenerateCode(t *testing.T) {
 		t.Error("Generated code does not contain package main")
 	}
 }
+
+func TestGenerateCodeIsFormatted(t *testing.T) {
+	sources := []string{
+		`print("Hello World!")`,
+		"print(\"a\")\nprint(\"b\")\n",
+		`unknown("x")`,
+		``,
+	}
+
+	for _, source := range sources {
+		program := NewParser(NewLexer(source).Tokenize()).Parse()
+		goCode := NewCodeGenerator(program).Generate()
+
+		formatted, err := format.Source([]byte(goCode))
+		if err != nil {
+			t.Fatalf("Generated code for %q is not valid Go: %v\n%s", source, err, goCode)
+		}
+		if string(formatted) != goCode {
+			t.Errorf("Generated code for %q is not gofmt-clean:\n%s", source, goCode)
+		}
+	}
+}
+
+func TestGenerateCodeImportsOnlyUsedPackages(t *testing.T) {
+	program := NewParser(NewLexer(`unknown("x")`).Tokenize()).Parse()
+	goCode := NewCodeGenerator(program).Generate()
+
+	if strings.Contains(goCode, `"fmt"`) {
+		t.Errorf("Generated code imports fmt without using it:\n%s", goCode)
+	}
+}
+
+func TestGenerateCodeLineDirectives(t *testing.T) {
+	source := "print(\"a\")\n\nprint(\"b\") print(\"c\")\n"
+	program := NewParser(NewLexer(source).Tokenize()).Parse()
+
+	codegen := NewCodeGenerator(program)
+	codegen.SourceFile = "hello.mob"
+	goCode := codegen.Generate()
+
+	for _, directive := range []string{"//line hello.mob:1:1\n", "//line hello.mob:3:1\n", "//line hello.mob:3:12\n"} {
+		if !strings.Contains(goCode, directive) {
+			t.Errorf("Generated code does not contain %q:\n%s", directive, goCode)
+		}
+	}
+
+	formatted, err := format.Source([]byte(goCode))
+	if err != nil {
+		t.Fatalf("Generated code is not valid Go: %v\n%s", err, goCode)
+	}
+	if string(formatted) != goCode {
+		t.Errorf("Generated code with directives is not gofmt-clean:\n%s", goCode)
+	}
+}
+
+func TestGenerateC(t *testing.T) {
+	comp := NewCompiler()
+	comp.Importer = MapImporter{
+		"lib.strings": "print(\"init\")\n\nfunction shout_loud():\n    print(\"A\")\n",
+	}
+	src := "from lib.strings import shout_loud\n\nfunction greet():\n    print(\"hi?\\\"x\", args())\n\ngreet()\nshout_loud()\nargs()\n"
+	res, err := comp.CompileSource(context.Background(), "main.mob", src, Options{Backend: BackendC})
+	if err != nil || len(res.Diagnostics) != 0 {
+		t.Fatalf("CompileSource failed: %v %v", err, res.Diagnostics)
+	}
+	if res.GoCode != "" {
+		t.Error("C backend also generated Go")
+	}
+
+	files := res.CFiles()
+	for _, name := range []string{"main.c", "mob.h", "mob.c", "modules/lib/strings.c"} {
+		if files[name] == "" {
+			t.Errorf("missing %s", name)
+		}
+	}
+	for _, want := range []string{
+		"void mob_3lib7strings_11shout_uloud(void);\n",
+		"#line 4 \"main.mob\"\n    mob_release(mob_builtin_print(2, mob_string_new(\"hi\\?\\\\\\\"x\", 6), mob_builtin_args()));\n",
+		"#line 7 \"main.mob\"\n    (mob_enter(\"main.mob\", 7, 1), mob_3lib7strings_11shout_uloud(), mob_leave());\n",
+		"    mob_release(mob_builtin_args());\n#line 17 \"main.c\"\n}\n",
+		"    mob_runtime_init(argc, argv, 10000);\n    mob_3lib7strings_init();\n    mob__init();\n",
+	} {
+		if !strings.Contains(res.CCode, want) {
+			t.Errorf("main.c does not contain %q:\n%s", want, res.CCode)
+		}
+	}
+	if module := files["modules/lib/strings.c"]; !strings.Contains(module, "#line 11 \"modules/lib/strings.c\"") || strings.Contains(module, "int main") {
+		t.Errorf("unexpected module code:\n%s", module)
+	}
+}
+
+func TestCompileC(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not available")
+	}
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "main.mob")
+	if err := os.WriteFile(file, []byte("print(\"Hello\", args())\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	comp := NewCompiler()
+	comp.Backend = BackendC
+	binary := filepath.Join(tempDir, "main")
+	if err := comp.Compile(file, binary); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	out, err := exec.Command(binary, "a", "b c").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "Hello [a b c]\n" {
+		t.Errorf("got %q", out)
+	}
+
+	comp.Target = Target{OS: "linux", Arch: "arm64"}
+	if err := comp.Compile(file, binary); err == nil || !strings.Contains(err.Error(), "host only") {
+		t.Errorf("expected the c backend to reject cross targets, got %v", err)
+	}
+
+	// Modules of other targets must not get as far as the generator.
+	web := filepath.Join(tempDir, "web.mob")
+	if err := os.WriteFile(web, []byte("import js\njs.Set(\"a\", \"b\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	comp.Emit = EmitC
+	comp.Stdout = new(bytes.Buffer)
+	comp.Target = Target{OS: "js", Arch: "wasm"}
+	if err := comp.Compile(web, binary); err == nil || !strings.Contains(err.Error(), "host only") {
+		t.Errorf("expected the c backend to reject js/wasm, got %v", err)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestEmitLLVM compares the IR of each program in testdata/llvm with its
+// main.ll; go test -update rewrites them.
+func TestEmitLLVM(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "llvm", "*"))
+	if err != nil || len(dirs) == 0 {
+		t.Fatalf("no golden files: %v", err)
+	}
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			file := filepath.Join(dir, "main.mob")
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			comp := NewCompiler()
+			comp.Importer = DirImporter{Roots: []string{dir}}
+			opts := Options{Emit: EmitLLVM, Build: BuildInfo{Version: "1.2.3", Commit: "abc123"}}
+			res, err := comp.CompileSource(context.Background(), file, string(src), opts)
+			if err != nil || len(res.Diagnostics) != 0 {
+				t.Fatalf("CompileSource failed: %v %v", err, res.Diagnostics)
+			}
+			if res.GoCode != "" {
+				t.Error("EmitLLVM also generated Go")
+			}
+
+			golden := filepath.Join(dir, "main.ll")
+			if *update {
+				if err := os.WriteFile(golden, []byte(res.LLVMCode), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.LLVMCode != string(want) {
+				t.Errorf("IR differs from %s:\n%s", golden, res.LLVMCode)
+			}
+		})
+	}
+}
+
+func TestCompileReportsMobDiagnostics(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.mob")
+
+	err := os.WriteFile(testFile, []byte("print(\"ok\")\nprint(\"x\", missing)\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	err = NewCompiler().Compile(testFile, filepath.Join(tempDir, "test_binary"))
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("Expected Diagnostics, got %T: %v", err, err)
+	}
+
+	if len(diags) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %d: %v", len(diags), diags)
+	}
+
+	d := diags[0]
+	if d.File != testFile || d.Line != 2 || d.Column != 12 {
+		t.Errorf("Expected %s:2:12, got %s:%d:%d", testFile, d.File, d.Line, d.Column)
+	}
+	if d.Internal {
+		t.Errorf("Undefined name should not be an internal error: %v", d)
+	}
+	if !strings.Contains(d.Message, "undefined: missing") {
+		t.Errorf("Unexpected message: %s", d.Message)
+	}
+	if d.Code != CodeUndefined || d.EndLine != 2 || d.EndColumn != 19 {
+		t.Errorf("Expected %s ending at 2:19, got %s ending at %d:%d", CodeUndefined, d.Code, d.EndLine, d.EndColumn)
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.mob":  "from utils import helper\nhelper()\nutils.helper()\n",
+		"utils.mob": "function helper():\n    print(\"h\")\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diags, err := NewCompiler().Check(filepath.Join(dir, "main.mob"))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diags) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %v", diags)
+	}
+	diags[0].File = "main.mob"
+
+	data, err := json.Marshal(diags)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := `[{"file":"main.mob","code":"E0201","severity":"error",` +
+		`"message":"undefined: utils (add 'import utils' to use it qualified)",` +
+		`"range":{"start":{"line":3,"column":1},"end":{"line":3,"column":13}},` +
+		`"suggestions":[{"message":"add 'import utils'","edits":[{"range":{"start":{"line":1,"column":1},"end":{"line":1,"column":1}},"newText":"import utils\n"}]}]}]`
+	if string(data) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, data)
+	}
+
+	// Without an end, the range ends where it starts.
+	data, _ = json.Marshal(Diagnostic{File: "a.mob", Line: 2, Column: 3, Message: "x", Internal: true})
+	if want := `{"file":"a.mob","severity":"error","message":"x","range":{"start":{"line":2,"column":3},"end":{"line":2,"column":3}},"internal":true}`; string(data) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, data)
+	}
+}
+
+func TestTranslateBuildErrorFlagsGeneratedCode(t *testing.T) {
+	stderr := "# mobapp\n./main.go:3:2: \"os\" imported and not used\n"
+	res := &Result{SourceMap: &SourceMap{File: "/src/a.mob"}}
+	err := translateBuildError(&goBuildError{stderr: stderr}, res, displayName("a.mob"))
+
+	diags, ok := err.(Diagnostics)
+	if !ok || len(diags) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %v", err)
+	}
+	if !diags[0].Internal {
+		t.Errorf("Error in generated Go should be internal: %v", diags[0])
+	}
+	if diags[0].File != "main.go" || diags[0].Line != 3 {
+		t.Errorf("Unexpected position: %v", diags[0])
+	}
+}
+
+func TestTranslateBuildErrorResolvesRelativePaths(t *testing.T) {
+	// The source sits outside the build dir, under its parent, so go
+	// build reports it relative to the build dir.
+	root := t.TempDir()
+	dir := filepath.Join(root, "build")
+	file := filepath.Join(root, "src", "w.mob")
+	stderr := "# mobapp\n../src/w.mob:2:5: undefined: x\n"
+	res := &Result{SourceMap: &SourceMap{File: file}}
+	err := translateBuildError(&goBuildError{stderr: stderr, dir: dir}, res, displayName(file))
+
+	diags, ok := err.(Diagnostics)
+	if !ok || len(diags) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %v", err)
+	}
+	if d := diags[0]; d.Internal || d.Code != CodeToolchain || d.File != file || d.Line != 2 || d.Column != 5 {
+		t.Errorf("Expected a toolchain error at %s:2:5, got %v", file, d)
+	}
+}
+
+func TestEmitStopsPipeline(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.mob")
+	if err := os.WriteFile(testFile, []byte(`print("Hello")`), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	expected := map[EmitMode]string{
+		EmitTokens:  `Token{String, "\"Hello\"", Line: 1, Column: 7}`,
+		EmitAST:     `Call "print" @1:1`,
+		EmitASTJSON: `"type": "String"`,
+		EmitGo:      `fmt.Println("Hello")`,
+		EmitC:       `mob_builtin_print(1, mob_string_new("Hello", 5))`,
+		EmitLLVM:    `call void @mob_write(ptr @.str.0, i64 5)`,
+	}
+
+	for mode, want := range expected {
+		var out bytes.Buffer
+		comp := NewCompiler()
+		comp.Emit = mode
+		comp.Stdout = &out
+
+		binaryName := filepath.Join(tempDir, "test_binary")
+		if err := comp.Compile(testFile, binaryName); err != nil {
+			t.Fatalf("Emit %s failed: %v", mode, err)
+		}
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Emit %s output does not contain %q:\n%s", mode, want, out.String())
+		}
+		if _, err := os.Stat(binaryName); err == nil {
+			t.Errorf("Emit %s should not build a binary", mode)
+		}
+	}
+}
+
+func TestCompilerStages(t *testing.T) {
+	comp := NewCompiler()
+
+	tokens := comp.Tokenize(`print("Hi")`)
+	program := comp.Parse(tokens)
+	goCode, sourceMap := comp.GenerateGo(program, "hi.mob")
+
+	if len(program.Children) != 1 || program.Children[0].Value != "print" {
+		t.Fatalf("Unexpected AST: %+v", program)
+	}
+	if !strings.Contains(goCode, "//line hi.mob:1:1") {
+		t.Errorf("Generated code is missing line directive:\n%s", goCode)
+	}
+	if sourceMap == nil || sourceMap.File != "hi.mob" {
+		t.Errorf("Expected source map for hi.mob, got %+v", sourceMap)
+	}
+}
+
+func TestParseEmitMode(t *testing.T) {
+	if mode, err := ParseEmitMode("go"); err != nil || mode != EmitGo {
+		t.Errorf("Expected EmitGo, got %q (%v)", mode, err)
+	}
+	if _, err := ParseEmitMode("bytecode"); err == nil {
+		t.Error("Expected error for unknown emit mode")
+	}
+	if _, err := ParseEmitMode(""); err == nil || !strings.Contains(err.Error(), "missing emit mode") {
+		t.Errorf("Expected error for missing emit mode, got %v", err)
+	}
+}
+
+func TestCompileSource(t *testing.T) {
+	comp := NewCompiler()
+
+	res, err := comp.CompileSource(context.Background(), "hello.mob", `print("Hello")`, Options{})
+	if err != nil {
+		t.Fatalf("CompileSource failed: %v", err)
+	}
+	if len(res.Tokens) == 0 || len(res.AST.Children) != 1 {
+		t.Fatalf("Unexpected result: %+v", res)
+	}
+	if len(res.Diagnostics) != 0 {
+		t.Errorf("Unexpected diagnostics: %v", res.Diagnostics)
+	}
+	if !strings.Contains(res.GoCode, "//line hello.mob:1:1") {
+		t.Errorf("Generated code is missing line directive:\n%s", res.GoCode)
+	}
+}
+
+func TestCompileSourceSyntaxErrors(t *testing.T) {
+	res, err := NewCompiler().CompileSource(context.Background(), "bad.mob", "print(\"ok\")\nprint(\"a\"", Options{})
+	if err != nil {
+		t.Fatalf("CompileSource failed: %v", err)
+	}
+
+	if len(res.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %v", res.Diagnostics)
+	}
+	want := "bad.mob:2:10: error: expected ')' after arguments, found end of file"
+	if got := res.Diagnostics[0].String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if res.GoCode != "" {
+		t.Error("Go code should not be generated when there are syntax errors")
+	}
+}
+
+func TestCompileSourceStopsAtEmitStage(t *testing.T) {
+	res, err := NewCompiler().CompileSource(context.Background(), "a.mob", `print("a")`, Options{Emit: EmitTokens})
+	if err != nil {
+		t.Fatalf("CompileSource failed: %v", err)
+	}
+	if len(res.Tokens) == 0 || len(res.AST.Children) != 0 || res.GoCode != "" {
+		t.Errorf("Expected only tokens, got %+v", res)
+	}
+}
+
+func TestBuildCacheReusesBinary(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.mob")
+	if err := os.WriteFile(testFile, []byte(`print("cached")`), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	comp := NewCompiler()
+	comp.Cache = NewBuildCache(filepath.Join(tempDir, "cache"))
+
+	first, _, err := comp.buildForRun(context.Background(), testFile)
+	if err != nil {
+		t.Fatalf("First build failed: %v", err)
+	}
+	info, err := os.Stat(first)
+	if err != nil {
+		t.Fatalf("Cached binary missing: %v", err)
+	}
+
+	second, _, err := comp.buildForRun(context.Background(), testFile)
+	if err != nil {
+		t.Fatalf("Second build failed: %v", err)
+	}
+	if second != first {
+		t.Errorf("Expected cache hit at %s, got %s", first, second)
+	}
+	if again, _ := os.Stat(second); !again.ModTime().Equal(info.ModTime()) {
+		t.Error("Cached binary was rebuilt")
+	}
+
+	// A hit in a new process does not run go to learn its version.
+	fresh := NewCompiler()
+	fresh.Cache = comp.Cache
+	if path, _, err := fresh.buildForRun(context.Background(), testFile); err != nil || path != first {
+		t.Errorf("Expected cache hit at %s, got %s, %v", first, path, err)
+	}
+	if fresh.goToolchain != nil {
+		t.Error("A cache hit ran the go command")
+	}
+
+	if err := os.WriteFile(testFile, []byte(`print("changed")`), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	third, _, err := comp.buildForRun(context.Background(), testFile)
+	if err != nil {
+		t.Fatalf("Third build failed: %v", err)
+	}
+	if third == first {
+		t.Error("Changed source should not reuse the cached binary")
+	}
+
+	if _, err := comp.Cache.Clean(); err != nil {
+		t.Fatalf("Clean failed: %v", err)
+	}
+	if _, ok := comp.Cache.Lookup(filepath.Base(filepath.Dir(third)), "test"); ok {
+		t.Error("Clean should remove cached binaries")
+	}
+}
+
+func TestCompileAndRunUsesPrivateTempDir(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.mob")
+	if err := os.WriteFile(testFile, []byte(`print("private")`), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	workDir := t.TempDir()
+	t.Chdir(workDir)
+
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- NewCompiler().CompileAndRun(testFile)
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Concurrent run failed: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		t.Fatalf("Failed to read working dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Run left files in the working directory: %v", entries)
+	}
+}
+
+func TestCompileAndRunForwardsArgs(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.mob")
+	if err := os.WriteFile(testFile, []byte(`print("args:", args())`), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	var out bytes.Buffer
+	comp := NewCompiler()
+	comp.Stdout = &out
+
+	if err := comp.CompileAndRun(testFile, "a", "b c"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := out.String(); got != "args: [a b c]\n" {
+		t.Errorf("Unexpected output: %q", got)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	if code, ok := ExitCode(err); !ok || code != 3 {
+		t.Errorf("Expected exit code 3, got %d (%v)", code, ok)
+	}
+
+	err = exec.Command("sh", "-c", "kill -TERM $$").Run()
+	if code, ok := ExitCode(err); !ok || code != 128+15 {
+		t.Errorf("Expected exit code 143, got %d (%v)", code, ok)
+	}
+
+	if _, ok := ExitCode(&goBuildError{}); ok {
+		t.Error("Build errors are not program exit codes")
+	}
+}
+
+func TestArgsBuiltinAsStatement(t *testing.T) {
+	program := NewParser(NewLexer("args()\n").Tokenize()).Parse()
+	goCode := NewCodeGenerator(program).Generate()
+
+	if !strings.Contains(goCode, "_ = os.Args[1:]") {
+		t.Errorf("Expected args() statement to be discarded:\n%s", goCode)
+	}
+}
+
+func TestParseImportsAndFunctions(t *testing.T) {
+	src := "import lib.strings\nfrom utils import greet, wave\n\nfunction main_task():\n    print(\"hi\")\n    return\n"
+	program := NewParser(NewLexer(src).Tokenize()).Parse()
+
+	if len(program.Children) != 3 {
+		t.Fatalf("Expected 3 statements, got %d", len(program.Children))
+	}
+	imp, from, fn := program.Children[0], program.Children[1], program.Children[2]
+	if imp.Type != NodeImport || imp.Value != "lib.strings" {
+		t.Errorf("Unexpected import: %+v", imp)
+	}
+	if from.Type != NodeFromImport || from.Value != "utils" || len(from.Children) != 2 || from.Children[1].Value != "wave" {
+		t.Errorf("Unexpected from-import: %+v", from)
+	}
+	if fn.Type != NodeFunction || fn.Value != "main_task" || len(fn.Children) != 2 || fn.Children[1].Type != NodeReturn {
+		t.Errorf("Unexpected function: %+v", fn)
+	}
+}
+
+func TestCompileSourceResolvesImports(t *testing.T) {
+	comp := NewCompiler()
+	comp.Importer = MapImporter{
+		"utils":       "function greet():\n    print(\"hi\")\n",
+		"lib.strings": "from utils import greet\n\nfunction shout():\n    greet()\n",
+	}
+
+	src := "import utils\nfrom lib.strings import shout\n\nutils.greet()\nshout()\n"
+	res, err := comp.CompileSource(context.Background(), "main.mob", src, Options{})
+	if err != nil {
+		t.Fatalf("CompileSource failed: %v", err)
+	}
+	if len(res.Diagnostics) != 0 {
+		t.Fatalf("Unexpected diagnostics: %v", res.Diagnostics)
+	}
+	if len(res.Modules) != 2 {
+		t.Fatalf("Expected utils to be loaded once, got %d modules", len(res.Modules))
+	}
+
+	files := res.GoFiles()
+	for _, name := range []string{"go.mod", "main.go", "utils/utils.go", "lib/strings/strings.go"} {
+		code, ok := files[name]
+		if !ok {
+			t.Fatalf("Missing generated file %s", name)
+		}
+		if name == "go.mod" {
+			continue
+		}
+		if formatted, err := format.Source([]byte(code)); err != nil || string(formatted) != code {
+			t.Errorf("%s is not gofmt-clean (err: %v):\n%s", name, err, code)
+		}
+	}
+	if !strings.Contains(res.GoCode, "lib_strings.Shout()") || !strings.Contains(files["lib/strings/strings.go"], "utils.Greet()") {
+		t.Errorf("Imported calls were not qualified:\n%s\n%s", res.GoCode, files["lib/strings/strings.go"])
+	}
+}
+
+func TestCompileSourceImportErrors(t *testing.T) {
+	comp := NewCompiler()
+	comp.Importer = MapImporter{"utils": "function greet():\n    print(\"hi\")\n"}
+
+	src := "import missing\nfrom utils import wave\nutils.greet()\nnowhere()\n"
+	res, err := comp.CompileSource(context.Background(), "main.mob", src, Options{})
+	if err != nil {
+		t.Fatalf("CompileSource failed: %v", err)
+	}
+
+	want := []string{
+		"main.mob:1:1: error: cannot import missing: module missing not found",
+		"main.mob:2:19: error: module utils has no function wave",
+		"main.mob:3:1: error: undefined: utils (add 'import utils' to use it qualified)",
+		"main.mob:4:1: error: undefined function: nowhere",
+	}
+	if len(res.Diagnostics) != len(want) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(want), res.Diagnostics)
+	}
+	for i, d := range res.Diagnostics {
+		if d.String() != want[i] {
+			t.Errorf("Diagnostic %d: expected %q, got %q", i, want[i], d.String())
+		}
+	}
+}
+
+func TestCompileSourceCallsWithoutValue(t *testing.T) {
+	comp := NewCompiler()
+	comp.Importer = MapImporter{"utils": "function greet():\n    print(\"hi\")\n"}
+
+	src := "import utils\nfrom mob.build import version\n\nprint(utils.greet(), print(), args(), version())\n"
+	res, err := comp.CompileSource(context.Background(), "main.mob", src, Options{})
+	if err != nil {
+		t.Fatalf("CompileSource failed: %v", err)
+	}
+
+	want := []string{
+		"main.mob:4:7: error: utils.greet() is used as a value but returns nothing",
+		"main.mob:4:22: error: print() is used as a value but returns nothing",
+	}
+	if len(res.Diagnostics) != len(want) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(want), res.Diagnostics)
+	}
+	for i, d := range res.Diagnostics {
+		if d.String() != want[i] {
+			t.Errorf("Diagnostic %d: expected %q, got %q", i, want[i], d.String())
+		}
+	}
+}
+
+func TestCompileAndRunWithModules(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"main.mob":        "import utils\nfrom lib.strings import shout\n\nutils.greet()\nshout()\n",
+		"utils.mob":       "function greet():\n    print(\"hello\")\n",
+		"lib/strings.mob": "print(\"loading strings\")\n\nfunction shout():\n    print(\"HELLO\")\n",
+	}
+	for name, src := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	comp := NewCompiler()
+	comp.Stdout = &stdout
+	if err := comp.CompileAndRun(filepath.Join(tempDir, "main.mob")); err != nil {
+		t.Fatalf("CompileAndRun failed: %v", err)
+	}
+
+	if got, want := stdout.String(), "loading strings\nhello\nHELLO\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestCompileSourceReportsImportCycles(t *testing.T) {
+	comp := NewCompiler()
+	comp.Importer = MapImporter{
+		"a":    "import b\n",
+		"b":    "print(\"b\")\nfrom c import f\n",
+		"c":    "import a\n\nfunction f():\n    return\n",
+		"self": "import self\n",
+	}
+
+	for _, tt := range []struct{ src, want string }{
+		{"import b\n", "a.mob:1:1: error: import cycle not allowed: a.mob -> b.mob -> c.mob -> a.mob\n" +
+			"\ta.mob:1:1: note: imports b\n" +
+			"\tb.mob:2:1: note: imports c\n" +
+			"\tc.mob:1:1: note: imports a"},
+		{"import self\n", "self.mob:1:1: error: import cycle not allowed: self.mob -> self.mob\n" +
+			"\tself.mob:1:1: note: imports self"},
+	} {
+		res, err := comp.CompileSource(context.Background(), "main.mob", tt.src, Options{})
+		if err != nil {
+			t.Fatalf("CompileSource failed: %v", err)
+		}
+		if len(res.Diagnostics) != 1 {
+			t.Fatalf("Expected 1 diagnostic for %q, got %v", tt.src, res.Diagnostics)
+		}
+		if got := res.Diagnostics[0].String(); got != tt.want {
+			t.Errorf("Expected:\n%s\ngot:\n%s", tt.want, got)
+		}
+		if res.GoCode != "" {
+			t.Error("Go code should not be generated for an import cycle")
+		}
+	}
+}
+
+func TestCheckReportsCycleThroughEntry(t *testing.T) {
+	tempDir := t.TempDir()
+	for name, src := range map[string]string{
+		"a.mob": "import b\n",
+		"b.mob": "import a\n",
+	} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry := filepath.Join(tempDir, "b.mob")
+	diags, err := NewCompiler().Check(entry)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diags) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %v", diags)
+	}
+
+	d := diags[0]
+	if !strings.HasSuffix(d.Message, "a.mob -> b.mob -> a.mob") {
+		t.Errorf("Unexpected cycle path: %s", d.Message)
+	}
+	if len(d.Notes) != 2 || d.Notes[1].File != entry {
+		t.Errorf("Expected a note at the entry file's import, got %v", d.Notes)
+	}
+}
+
+func TestCheckNamesCycleFilesFromProjectRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range map[string]string{
+		"a.mob":     "import sub.b\n",
+		"sub/b.mob": "import a\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Whichever file is checked, the cycle reads the same, so mob lint
+	// reports it once.
+	want := "import cycle not allowed: a.mob -> " + filepath.Join("sub", "b.mob") + " -> a.mob"
+	for _, entry := range []string{"a.mob", "sub/b.mob"} {
+		comp := NewCompiler()
+		comp.ProjectRoot = root
+		diags, err := comp.Check(filepath.Join(root, entry))
+		if err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+		if len(diags) != 1 || diags[0].Message != want {
+			t.Errorf("%s: expected %q, got %v", entry, want, diags)
+		}
+	}
+}
+
+// TestExplanationExamples checks that the erroneous example of each
+// explanation reports its code.
+func TestExplanationExamples(t *testing.T) {
+	modules := map[string]string{
+		"utils.mob": "function helper():\n    print(\"h\")\n",
+		"a.mob":     "function run():\n    print(\"a\")\n",
+		"b.mob":     "function run():\n    print(\"b\")\n",
+	}
+	for _, e := range Explanations() {
+		_, example, ok := strings.Cut(e.Text, "Erroneous code example:\n\n")
+		// The cycle example shows two files in one block.
+		if !ok || e.Code == CodeImportCycle {
+			continue
+		}
+		var lines []string
+		for _, line := range strings.Split(example, "\n") {
+			if line != "" && !strings.HasPrefix(line, "    ") {
+				break
+			}
+			lines = append(lines, strings.TrimPrefix(line, "    "))
+		}
+
+		t.Run(e.Code, func(t *testing.T) {
+			dir := t.TempDir()
+			modules["main.mob"] = strings.Join(lines, "\n")
+			for name, src := range modules {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			comp := NewCompiler()
+			// js only loads for js/wasm.
+			if strings.Contains(modules["main.mob"], "import js") {
+				comp.Target = Target{OS: "js", Arch: "wasm"}
+			}
+			diags, err := comp.Check(filepath.Join(dir, "main.mob"))
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			for _, d := range diags {
+				if d.Code == e.Code {
+					return
+				}
+			}
+			t.Errorf("Expected %s from:\n%s\ngot %v", e.Code, modules["main.mob"], diags)
+		})
+	}
+}
+
+func TestCompileResolvesImportsFromSourceDirs(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"app/main.mob":      "from greetings import hello\nhello()\n",
+		"lib/greetings.mob": "function hello():\n    print(\"hi\")\n",
+	}
+	for name, src := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	comp := NewCompiler()
+	comp.ProjectRoot = root
+	comp.SourceDirs = []string{filepath.Join(root, "lib")}
+	diags, err := comp.Check(filepath.Join(root, "app", "main.mob"))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diags) != 0 {
+		t.Errorf("Unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestDirImporterPackages(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"strutil/main.mob":     "import strutil.text\n",
+		"strutil/src/text.mob": "function upper():\n    print(\"UP\")\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	importer := DirImporter{
+		Roots: []string{t.TempDir()},
+		Packages: []Package{{
+			Name:       "strutil",
+			Entry:      filepath.Join(dir, "strutil", "main.mob"),
+			SourceDirs: []string{filepath.Join(dir, "strutil", "src")},
+		}},
+	}
+
+	for path, want := range map[string]string{
+		"strutil":      filepath.Join(dir, "strutil", "main.mob"),
+		"strutil.text": filepath.Join(dir, "strutil", "src", "text.mob"),
+	} {
+		file, _, err := importer.Import(path)
+		if err != nil || file != want {
+			t.Errorf("Import(%q) = %s, %v; want %s", path, file, err, want)
+		}
+	}
+	if _, _, err := importer.Import("strutil.missing"); err == nil {
+		t.Error("Expected an error for a module the package does not have")
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("linux/arm64")
+	if err != nil || target != (Target{OS: "linux", Arch: "arm64"}) {
+		t.Fatalf("ParseTarget = %v, %v", target, err)
+	}
+	if got := target.OutputName("app"); got != "app-linux-arm64" {
+		t.Errorf("Unexpected output name %s", got)
+	}
+	if got := (Target{OS: "windows", Arch: "amd64"}).OutputName("app"); got != "app-windows-amd64.exe" {
+		t.Errorf("Unexpected Windows output name %s", got)
+	}
+
+	if target, err := ParseTarget("wasm"); err != nil || target != WASI {
+		t.Errorf("ParseTarget(wasm) = %v, %v", target, err)
+	}
+	if got := WASI.OutputName("app"); got != "app-wasip1-wasm.wasm" {
+		t.Errorf("Unexpected WebAssembly output name %s", got)
+	}
+
+	for _, bad := range []string{"linux", "linux/", "/arm64", "linux/arm64/v8"} {
+		if _, err := ParseTarget(bad); err == nil {
+			t.Errorf("ParseTarget(%q) should fail", bad)
+		}
+	}
+}
+
+func TestCompileCrossTarget(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.mob")
+	if err := os.WriteFile(testFile, []byte(`print("Hello")`), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	target := Target{OS: "linux", Arch: "arm64"}
+	machine := elf.EM_AARCH64
+	if runtime.GOARCH == "arm64" {
+		target.Arch, machine = "amd64", elf.EM_X86_64
+	}
+
+	comp := NewCompiler()
+	comp.Target = target
+	output := filepath.Join(tempDir, target.OutputName("test"))
+	if err := comp.Compile(testFile, output); err != nil {
+		t.Fatalf("Compile for %s failed: %v", target, err)
+	}
+
+	f, err := elf.Open(output)
+	if err != nil {
+		t.Fatalf("Output is not an ELF binary: %v", err)
+	}
+	defer f.Close()
+	if f.Machine != machine {
+		t.Errorf("Expected %v binary, got %v", machine, f.Machine)
+	}
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			t.Error("Binary should be static, but has a dynamic loader")
+		}
+	}
+
+	comp.Target = Target{OS: "plan10", Arch: "amd64"}
+	if err := comp.Compile(testFile, output); err == nil || !strings.Contains(err.Error(), "unsupported target plan10/amd64") {
+		t.Errorf("Expected unsupported target error, got %v", err)
+	}
+	if err := comp.CompileAndRun(testFile); err == nil {
+		t.Error("CompileAndRun should refuse a cross target")
+	}
+}
+
+// wasiRunner is a Node.js script that runs a wasip1 program with the
+// node:wasi module.
+const wasiRunner = `
+const { WASI } = require("node:wasi");
+const fs = require("fs");
+const wasi = new WASI({ version: "preview1", args: process.argv.slice(2), env: {}, returnOnExit: true });
+WebAssembly.instantiate(fs.readFileSync(process.argv[2]), wasi.getImportObject())
+	.then(({ instance }) => { process.exitCode = wasi.start(instance); });
+`
+
+// runWASI runs a wasip1 program with wasmtime, or with Node.js when
+// wasmtime is not installed, and returns its stdout.
+func runWASI(t *testing.T, wasm string, args ...string) string {
+	t.Helper()
+	var cmd *exec.Cmd
+	if wasmtime, err := exec.LookPath("wasmtime"); err == nil {
+		cmd = exec.Command(wasmtime, append([]string{"run", wasm}, args...)...)
+	} else if node, err := exec.LookPath("node"); err == nil {
+		script := filepath.Join(t.TempDir(), "wasi.js")
+		if err := os.WriteFile(script, []byte(wasiRunner), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cmd = exec.Command(node, append([]string{"--no-warnings", script, wasm}, args...)...)
+	} else {
+		t.Skip("no WASI runtime (wasmtime or node) available")
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s failed: %v", wasm, err)
+	}
+	return string(out)
+}
+
+func checkWasmHeader(t *testing.T, file string) {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("\x00asm\x01\x00\x00\x00")) {
+		t.Fatalf("%s is not a WebAssembly module", file)
+	}
+}
+
+func TestCompileWASI(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"main.mob":  "import utils\n\nprint(\"args\", args())\nutils.greet()\n",
+		"utils.mob": "print(\"loading utils\")\n\nfunction greet():\n    print(\"hello\")\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	comp := NewCompiler()
+	comp.Target = WASI
+	output := filepath.Join(tempDir, WASI.OutputName("main"))
+	if err := comp.Compile(filepath.Join(tempDir, "main.mob"), output); err != nil {
+		t.Fatalf("Compile for wasm failed: %v", err)
+	}
+	checkWasmHeader(t, output)
+
+	if got, want := runWASI(t, output, "a", "b"), "loading utils\nargs [a b]\nhello\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// jsRunner is a Node.js script that runs a js/wasm program with the
+// wasm_exec.js shim, providing the host functions the program uses.
+const jsRunner = `
+globalThis.platform = "node";
+globalThis.greet = (name) => "hi " + name;
+globalThis.count = 42;
+globalThis.twice = (n) => 2 * n;
+require(process.argv[2]);
+const go = new Go();
+WebAssembly.instantiate(require("fs").readFileSync(process.argv[3]), go.importObject)
+	.then(({ instance }) => go.run(instance))
+	.then(() => console.log("answer=" + globalThis.answer));
+`
+
+func TestCompileJSWasm(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "web.mob")
+	src := "import js\n\nprint(\"hello from\", js.get(\"platform\"))\njs.set(\"answer\", \"42\")\nprint(js.call(\"greet\", \"mob\"))\nprint(js.get(\"count\"), js.call(\"twice\", \"21\"), js.get(\"missing\"))\n"
+	if err := os.WriteFile(testFile, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	comp := NewCompiler()
+	if err := comp.Compile(testFile, filepath.Join(tempDir, "web")); err == nil || !strings.Contains(err.Error(), "module js is only available on js/wasm") {
+		t.Fatalf("Expected js to be refused for the host, got %v", err)
+	}
+
+	comp.Target = Target{OS: "js", Arch: "wasm"}
+	output := filepath.Join(tempDir, comp.Target.OutputName("web"))
+	if err := comp.Compile(testFile, output); err != nil {
+		t.Fatalf("Compile for js/wasm failed: %v", err)
+	}
+	checkWasmHeader(t, output)
+	shim := filepath.Join(tempDir, "wasm_exec.js")
+	if _, err := os.Stat(shim); err != nil {
+		t.Fatalf("wasm_exec.js was not written next to the program: %v", err)
+	}
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not available")
+	}
+	script := filepath.Join(tempDir, "run.js")
+	if err := os.WriteFile(script, []byte(jsRunner), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(node, script, shim, output).Output()
+	if err != nil {
+		t.Fatalf("node failed: %v", err)
+	}
+	if got, want := string(out), "hello from node\nhi mob\n42 42 undefined\nanswer=42\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestJSModuleArity(t *testing.T) {
+	comp := NewCompiler()
+	opts := Options{Target: Target{OS: "js", Arch: "wasm"}}
+	res, err := comp.CompileSource(context.Background(), "main.mob", "import js\njs.call()\njs.set(\"a\")\nprint(js.set(\"a\", \"b\"))\njs.call(\"f\", args())\nprint(args(), js.get(\"x\"))\n", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"main.mob:2:1: error: js.call takes at least 1 argument",
+		"main.mob:3:1: error: js.set takes 2 arguments",
+		"main.mob:4:7: error: js.set() is used as a value but returns nothing",
+		"main.mob:5:14: error: args() returns a list, but js.call takes strings",
+	}
+	if len(res.Diagnostics) != len(want) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(want), res.Diagnostics)
+	}
+	for i, d := range res.Diagnostics {
+		if d.String() != want[i] {
+			t.Errorf("Diagnostic %d: expected %q, got %q", i, want[i], d.String())
+		}
+	}
+}
+
+func TestBuildModule(t *testing.T) {
+	comp := NewCompiler()
+	opts := Options{Build: BuildInfo{Version: "1.2.3", Commit: "abc123"}}
+
+	src := "import mob.build\nfrom mob.build import commit\n\nprint(mob.build.version(), commit())\n"
+	res, err := comp.CompileSource(context.Background(), "main.mob", src, opts)
+	if err != nil {
+		t.Fatalf("CompileSource failed: %v", err)
+	}
+	if len(res.Diagnostics) != 0 {
+		t.Fatalf("Unexpected diagnostics: %v", res.Diagnostics)
+	}
+
+	code := res.GoFiles()["mob/build/build.go"]
+	if !strings.Contains(code, `return "1.2.3"`) || !strings.Contains(code, `return "abc123"`) {
+		t.Errorf("Build info missing from mob.build:\n%s", code)
+	}
+	if !strings.Contains(res.GoCode, "fmt.Println(mob_build.Version(), mob_build.Commit())") {
+		t.Errorf("Unexpected main.go:\n%s", res.GoCode)
+	}
+
+	res, err = comp.CompileSource(context.Background(), "main.mob", "import mob.build\nmob.build.date()\n", opts)
+	if err != nil {
+		t.Fatalf("CompileSource failed: %v", err)
+	}
+	if len(res.Diagnostics) != 1 || res.Diagnostics[0].Message != "module mob.build has no function date" {
+		t.Errorf("Expected unknown function error, got %v", res.Diagnostics)
+	}
+
+	// The commit is only looked up for programs that import mob.build,
+	// and once.
+	lookups := 0
+	comp = NewCompiler()
+	comp.LookupCommit = func() string { lookups++; return "def456" }
+	for _, src := range []string{"print(\"no build info\")\n", src, src} {
+		res, err = comp.CompileSource(context.Background(), "main.mob", src, Options{})
+		if err != nil {
+			t.Fatalf("CompileSource failed: %v", err)
+		}
+	}
+	if code := res.GoFiles()["mob/build/build.go"]; !strings.Contains(code, `return "def456"`) {
+		t.Errorf("Looked up commit missing from mob.build:\n%s", code)
+	}
+	if lookups != 1 {
+		t.Errorf("Expected one commit lookup, got %d", lookups)
+	}
+}
+
+func TestReleaseBuildIsReproducible(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.mob")
+	if err := os.WriteFile(testFile, []byte("import mob.build\nprint(mob.build.version())\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	comp := NewCompiler()
+	comp.Release = true
+	comp.Reproducible = true
+	comp.Build.Version = "0.9.0"
+	output := filepath.Join(tempDir, "test")
+	if err := comp.Compile(testFile, output); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	out, err := exec.Command(output).Output()
+	if err != nil {
+		t.Fatalf("Failed to run binary: %v", err)
+	}
+	if string(out) != "0.9.0\n" {
+		t.Errorf("Expected the embedded version, got %q", out)
+	}
+
+	binary, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(binary, []byte("mob_build_")) || bytes.Contains(binary, []byte(os.TempDir()+"/mob_compile")) {
+		t.Error("Release binary should not contain build directory paths")
+	}
+	if _, err := os.Stat(output + ".check"); err == nil {
+		t.Error("Verification binary was left behind")
+	}
+
+	// Without --release the private build dir stays out of the binary
+	// too, and is removed afterwards.
+	comp.Release = false
+	if err := comp.Compile(testFile, output); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if binary, err = os.ReadFile(output); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(binary, []byte("mob_build_")) {
+		t.Error("Reproducible binary should not contain build directory paths")
+	}
+	if comp.buildRoot != "" {
+		t.Errorf("Build dir %s was left behind", comp.buildRoot)
+	}
+}
+
+func TestGenerateCodeIsDeterministic(t *testing.T) {
+	comp := NewCompiler()
+	comp.Importer = MapImporter{
+		"b": "function f():\n    print(\"b\")\n",
+		"a": "from b import f\nfunction g():\n    f()\n",
+	}
+	src := "import a\nimport b\nimport mob.build\n\na.g()\nb.f()\nprint(args(), mob.build.version())\n"
+
+	var first map[string]string
+	for i := 0; i < 10; i++ {
+		res, err := comp.CompileSource(context.Background(), "main.mob", src, Options{})
+		if err != nil || len(res.Diagnostics) != 0 {
+			t.Fatalf("CompileSource failed: %v %v", err, res.Diagnostics)
+		}
+		files := res.GoFiles()
+		if first == nil {
+			first = files
+			continue
+		}
+		for name, code := range files {
+			if first[name] != code {
+				t.Fatalf("%s differs between runs:\n%s\n---\n%s", name, first[name], code)
+			}
+		}
+	}
+}
+
+func TestGoNamesAreDistinct(t *testing.T) {
+	packages := map[string]string{
+		"utils":     "utils",
+		"mob.build": "mob_build",
+		"a_b":       "m__3a_b",
+		"Foo":       "m__3Foo",
+		"type":      "m__4type",
+		"main":      "m__4main",
+		"a.b_c":     "m__1a3b_c",
+	}
+	for path, want := range packages {
+		if got := goPackageName(path); got != want {
+			t.Errorf("goPackageName(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	names := map[string]string{
+		"greet": "Greet",
+		"Greet": "X_Greet",
+		"x_y":   "X_x_y",
+		"X_y":   "X_X_y",
+		"xy":    "Xy",
+		"éclat": "X_éclat",
+	}
+	for name, want := range names {
+		if got := exportName(name); got != want {
+			t.Errorf("exportName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCompareGoVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.21", "1.21", 0},
+		{"1.21.0", "1.21", 0},
+		{"1.22.3", "1.21", 1},
+		{"1.20.14", "1.21", -1},
+		{"1.23rc1", "1.21", 1},
+		{"1.9", "1.21", -1},
+		{"devel go1.24-a1b2c3d Mon Jan 6 10:00:00 2025 +0000", "1.21", 1},
+		{"devel go1.20-a1b2c3d", "1.21", -1},
+		{"devel +a1b2c3d Tue Jun 6 10:00:00 2023 +0000", "1.21", 1},
+	}
+	for _, tt := range tests {
+		if got := compareGoVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareGoVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFindToolchain(t *testing.T) {
+	t.Setenv("MOB_GOROOT", "")
+	tc, err := FindToolchain(context.Background(), "")
+	if err != nil {
+		t.Fatalf("FindToolchain failed: %v", err)
+	}
+	if tc.Source != "PATH" || compareGoVersions(tc.Version, MinGoVersion) < 0 {
+		t.Errorf("Unexpected toolchain %+v", tc)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake toolchain is a shell script")
+	}
+
+	old := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(old, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho go1.19.2\n"
+	if err := os.WriteFile(filepath.Join(old, "bin", "go"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = FindToolchain(context.Background(), old)
+	if !errors.Is(err, ErrNoToolchain) || !strings.Contains(err.Error(), "Go 1.19.2") || !strings.Contains(err.Error(), "too old") {
+		t.Errorf("Expected a too old error, got %v", err)
+	}
+
+	t.Setenv("MOB_GOROOT", filepath.Join(old, "missing"))
+	_, err = FindToolchain(context.Background(), "")
+	if !errors.Is(err, ErrNoToolchain) || !strings.Contains(err.Error(), "MOB_GOROOT") {
+		t.Errorf("Expected a MOB_GOROOT error, got %v", err)
+	}
+
+	comp := NewCompiler()
+	testFile := filepath.Join(t.TempDir(), "test.mob")
+	if err := os.WriteFile(testFile, []byte(`print("Hello")`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := comp.Compile(testFile, filepath.Join(t.TempDir(), "test")); !errors.Is(err, ErrNoToolchain) {
+		t.Errorf("Compile should report the missing toolchain, got %v", err)
+	}
+}
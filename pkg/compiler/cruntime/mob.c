@@ -0,0 +1,167 @@
+/*
+ * mob.c - runtime for Mob programs compiled to C. Values print the way
+ * Go's fmt.Println prints the values of the Go backend.
+ */
+#include "mob.h"
+
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static int mob_argc;
+static char **mob_argv;
+static int mob_depth;
+static int mob_max_depth;
+
+static void *mob_alloc(size_t size)
+{
+    void *p = malloc(size);
+    if (p == NULL && size > 0) {
+        fputs("fatal error: out of memory\n", stderr);
+        exit(2);
+    }
+    return p;
+}
+
+mob_object *mob_retain(mob_object *o)
+{
+    if (o != NULL) {
+        o->refs++;
+    }
+    return o;
+}
+
+void mob_release(mob_object *o)
+{
+    size_t i;
+
+    if (o == NULL || --o->refs > 0) {
+        return;
+    }
+    switch (o->kind) {
+    case MOB_STRING:
+        free(((mob_string *)o)->data);
+        break;
+    case MOB_LIST:
+        for (i = 0; i < ((mob_list *)o)->len; i++) {
+            mob_release(((mob_list *)o)->items[i]);
+        }
+        free(((mob_list *)o)->items);
+        break;
+    }
+    free(o);
+}
+
+mob_object *mob_string_new(const char *data, size_t len)
+{
+    mob_string *s = mob_alloc(sizeof *s);
+    s->obj.kind = MOB_STRING;
+    s->obj.refs = 1;
+    s->len = len;
+    s->data = mob_alloc(len + 1);
+    memcpy(s->data, data, len);
+    s->data[len] = '\0';
+    return &s->obj;
+}
+
+mob_object *mob_list_new(void)
+{
+    mob_list *l = mob_alloc(sizeof *l);
+    l->obj.kind = MOB_LIST;
+    l->obj.refs = 1;
+    l->len = 0;
+    l->cap = 0;
+    l->items = NULL;
+    return &l->obj;
+}
+
+void mob_list_append(mob_object *list, mob_object *item)
+{
+    mob_list *l = (mob_list *)list;
+    if (l->len == l->cap) {
+        mob_object **items;
+        l->cap = l->cap == 0 ? 4 : 2 * l->cap;
+        items = mob_alloc(l->cap * sizeof *items);
+        if (l->len > 0) {
+            memcpy(items, l->items, l->len * sizeof *items);
+        }
+        free(l->items);
+        l->items = items;
+    }
+    l->items[l->len++] = item;
+}
+
+void mob_runtime_init(int argc, char **argv, int max_depth)
+{
+    mob_argc = argc;
+    mob_argv = argv;
+    mob_max_depth = max_depth;
+}
+
+void mob_enter(const char *file, int line, int column)
+{
+    if (mob_depth >= mob_max_depth) {
+        fflush(stdout);
+        fprintf(stderr, "%s:%d:%d: runtime error: stack overflow: more than %d nested calls\n",
+                file, line, column, mob_max_depth);
+        exit(2);
+    }
+    mob_depth++;
+}
+
+void mob_leave(void)
+{
+    mob_depth--;
+}
+
+static void mob_write(FILE *f, mob_object *o)
+{
+    size_t i;
+
+    switch (o->kind) {
+    case MOB_STRING:
+        fwrite(((mob_string *)o)->data, 1, ((mob_string *)o)->len, f);
+        break;
+    case MOB_LIST:
+        fputc('[', f);
+        for (i = 0; i < ((mob_list *)o)->len; i++) {
+            if (i > 0) {
+                fputc(' ', f);
+            }
+            mob_write(f, ((mob_list *)o)->items[i]);
+        }
+        fputc(']', f);
+        break;
+    }
+}
+
+mob_object *mob_builtin_print(int argc, ...)
+{
+    va_list ap;
+    int i;
+
+    va_start(ap, argc);
+    for (i = 0; i < argc; i++) {
+        mob_object *o = va_arg(ap, mob_object *);
+        if (i > 0) {
+            fputc(' ', stdout);
+        }
+        mob_write(stdout, o);
+        mob_release(o);
+    }
+    va_end(ap);
+    fputc('\n', stdout);
+    return NULL;
+}
+
+mob_object *mob_builtin_args(void)
+{
+    mob_object *list = mob_list_new();
+    int i;
+
+    for (i = 1; i < mob_argc; i++) {
+        mob_list_append(list, mob_string_new(mob_argv[i], strlen(mob_argv[i])));
+    }
+    return list;
+}
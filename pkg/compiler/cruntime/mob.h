@@ -0,0 +1,61 @@
+/*
+ * mob.h - runtime for Mob programs compiled to C.
+ *
+ * Every value is a reference-counted object. Functions that return an
+ * object return a new reference, which the caller must release, and
+ * functions that take objects take over the caller's references.
+ */
+#ifndef MOB_H
+#define MOB_H
+
+#include <stddef.h>
+
+typedef enum {
+    MOB_STRING,
+    MOB_LIST
+} mob_kind;
+
+typedef struct mob_object {
+    mob_kind kind;
+    long refs;
+} mob_object;
+
+typedef struct {
+    mob_object obj;
+    size_t len;
+    char *data;
+} mob_string;
+
+typedef struct {
+    mob_object obj;
+    size_t len;
+    size_t cap;
+    mob_object **items;
+} mob_list;
+
+mob_object *mob_retain(mob_object *o);
+/* mob_release frees o when its last reference goes; NULL is ignored. */
+void mob_release(mob_object *o);
+
+/* mob_string_new copies len bytes of data, which may contain NULs. */
+mob_object *mob_string_new(const char *data, size_t len);
+mob_object *mob_list_new(void);
+void mob_list_append(mob_object *list, mob_object *item);
+
+/* mob_runtime_init records the program arguments for args() and the
+ * limit of nested calls, compiler.MaxDepth, which stops runaway
+ * recursion instead of crashing or, once cc turns a tail call into a
+ * jump, running forever. */
+void mob_runtime_init(int argc, char **argv, int max_depth);
+
+/* mob_enter counts a call of a Mob function at file:line:column, exiting
+ * with status 2 past the limit; mob_leave counts its return. */
+void mob_enter(const char *file, int line, int column);
+void mob_leave(void);
+
+/* Builtins, named after compiler.Builtins. They return NULL when they
+ * return nothing. */
+mob_object *mob_builtin_print(int argc, ...);
+mob_object *mob_builtin_args(void);
+
+#endif
@@ -0,0 +1,183 @@
+package compiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Diagnostic struct {
+	File   string
+	Line   int
+	Column int
+	// EndLine and EndColumn end the range of the problem, exclusive.
+	// They are zero when only its start is known.
+	EndLine   int
+	EndColumn int
+	// Code identifies the kind of problem, such as E0201; see codes.go.
+	Code     string
+	Severity Severity
+	Message  string
+	// Internal marks errors caused by a bug in the compiler itself,
+	// such as generated Go that does not build.
+	Internal bool
+	// Notes point at related locations, such as each import of an
+	// import cycle.
+	Notes []Diagnostic
+	// Suggestions are fixes for the problem.
+	Suggestions []Suggestion
+}
+
+// Suggestion is a fix for a diagnostic: edits to apply together.
+type Suggestion struct {
+	Message string
+	Edits   []TextEdit
+}
+
+// TextEdit replaces the text from Line:Column up to EndLine:EndColumn,
+// exclusive, with NewText. An empty range inserts.
+type TextEdit struct {
+	Line, Column       int
+	EndLine, EndColumn int
+	NewText            string
+}
+
+func (d Diagnostic) String() string {
+	var b strings.Builder
+
+	if d.File != "" {
+		b.WriteString(d.File)
+		if d.Line > 0 {
+			fmt.Fprintf(&b, ":%d", d.Line)
+			if d.Column > 0 {
+				fmt.Fprintf(&b, ":%d", d.Column)
+			}
+		}
+		b.WriteString(": ")
+	}
+
+	if d.Internal {
+		b.WriteString("internal compiler error: ")
+	} else {
+		b.WriteString(d.Severity.String() + ": ")
+	}
+	b.WriteString(d.Message)
+
+	for _, note := range d.Notes {
+		b.WriteString("\n\t")
+		if note.File != "" {
+			fmt.Fprintf(&b, "%s:%d:%d: ", note.File, note.Line, note.Column)
+		}
+		b.WriteString("note: " + note.Message)
+	}
+
+	return b.String()
+}
+
+// MarshalJSON encodes d for tools, with its range as positions:
+//
+//	{"file": "main.mob", "code": "E0201", "severity": "error",
+//	 "message": "undefined: x",
+//	 "range": {"start": {"line": 1, "column": 7}, "end": {"line": 1, "column": 8}}}
+//
+// notes, suggestions and internal are added when set. A range without
+// an end ends where it starts.
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	type position struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	}
+	type textRange struct {
+		Start position `json:"start"`
+		End   position `json:"end"`
+	}
+	type edit struct {
+		Range   textRange `json:"range"`
+		NewText string    `json:"newText"`
+	}
+	type suggestion struct {
+		Message string `json:"message"`
+		Edits   []edit `json:"edits"`
+	}
+
+	out := struct {
+		File        string       `json:"file,omitempty"`
+		Code        string       `json:"code,omitempty"`
+		Severity    Severity     `json:"severity"`
+		Message     string       `json:"message"`
+		Range       *textRange   `json:"range,omitempty"`
+		Internal    bool         `json:"internal,omitempty"`
+		Notes       []Diagnostic `json:"notes,omitempty"`
+		Suggestions []suggestion `json:"suggestions,omitempty"`
+	}{
+		File:     d.File,
+		Code:     d.Code,
+		Severity: d.Severity,
+		Message:  d.Message,
+		Internal: d.Internal,
+		Notes:    d.Notes,
+	}
+	if d.Line > 0 {
+		endLine, endColumn := d.End()
+		out.Range = &textRange{position{d.Line, d.Column}, position{endLine, endColumn}}
+	}
+	for _, s := range d.Suggestions {
+		js := suggestion{Message: s.Message, Edits: []edit{}}
+		for _, e := range s.Edits {
+			js.Edits = append(js.Edits, edit{textRange{position{e.Line, e.Column}, position{e.EndLine, e.EndColumn}}, e.NewText})
+		}
+		out.Suggestions = append(out.Suggestions, js)
+	}
+	return json.Marshal(out)
+}
+
+// End returns the end of the range of d, which is its start when the
+// end is unknown.
+func (d Diagnostic) End() (line, column int) {
+	if d.EndLine == 0 {
+		return d.Line, d.Column
+	}
+	return d.EndLine, d.EndColumn
+}
+
+// Diagnostics is returned as an error when compilation fails.
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package compiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EmitMode stops the pipeline after a stage and prints its result
+// instead of building a binary. There is no typed AST stage: values are
+// all strings, so the resolver has no types to record, and the only
+// rewrite it makes, qualifying names imported with from, shows in the
+// generated code.
+type EmitMode string
+
+const (
+	EmitNone    EmitMode = ""
+	EmitTokens  EmitMode = "tokens"
+	EmitAST     EmitMode = "ast"
+	EmitASTJSON EmitMode = "ast-json"
+	EmitGo      EmitMode = "go"
+	EmitC       EmitMode = "c"
+	EmitLLVM    EmitMode = "llvm"
+)
+
+var emitModes = []EmitMode{EmitTokens, EmitAST, EmitASTJSON, EmitGo, EmitC, EmitLLVM}
+
+func ParseEmitMode(s string) (EmitMode, error) {
+	for _, mode := range emitModes {
+		if string(mode) == s {
+			return mode, nil
+		}
+	}
+
+	names := make([]string, len(emitModes))
+	for i, mode := range emitModes {
+		names[i] = string(mode)
+	}
+	if s == "" {
+		return EmitNone, fmt.Errorf("missing emit mode (expected one of: %s)", strings.Join(names, ", "))
+	}
+	return EmitNone, fmt.Errorf("unknown emit mode %q (expected one of: %s)", s, strings.Join(names, ", "))
+}
+
+func writeTokens(w io.Writer, tokens []Token) error {
+	for _, tok := range tokens {
+		if _, err := fmt.Fprintln(w, tok.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAST(w io.Writer, node Node, depth int) error {
+	line := strings.Repeat("  ", depth) + node.Type.String()
+	if node.Value != "" {
+		line += fmt.Sprintf(" %q", node.Value)
+	}
+	if node.Line > 0 {
+		line += fmt.Sprintf(" @%d:%d", node.Line, node.Column)
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+
+	for _, child := range node.Children {
+		if err := writeAST(w, child, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeASTJSON(w io.Writer, node Node) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(node)
+}
+
+// writeGoFiles prints the generated Go. Programs with imports print every
+// file of the generated module, each under a header with its path.
+func writeGoFiles(w io.Writer, res *Result) error {
+	if len(res.Modules) == 0 {
+		_, err := io.WriteString(w, res.GoCode)
+		return err
+	}
+	return writeFileSet(w, res.GoFiles())
+}
+
+// writeCFiles prints the generated C like writeGoFiles, without the
+// runtime, which is the same for every program.
+func writeCFiles(w io.Writer, res *Result) error {
+	files := res.CFiles()
+	delete(files, "mob.h")
+	delete(files, "mob.c")
+	if len(files) == 1 {
+		_, err := io.WriteString(w, res.CCode)
+		return err
+	}
+	return writeFileSet(w, files)
+}
+
+func writeFileSet(w io.Writer, files map[string]string) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "// File: %s\n%s", name, files[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
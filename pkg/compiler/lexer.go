@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 	"unicode"
 )
 
@@ -18,19 +19,44 @@ const (
 	TokenIndent
 	TokenDedent
 	TokenNewline
+	TokenDot
+	TokenComma
+	// TokenComment is a # comment. Comments are not part of the token
+	// stream; Lexer.Comments returns them.
+	TokenComment
 )
 
 type Token struct {
-	Type  TokenType
-	Value string
-	Line  int
+	Type   TokenType
+	Value  string
+	Line   int
+	Column int
+	// Leading and Trailing are the source text around the token that
+	// belongs to no token: whitespace, comments, newlines inside
+	// parentheses and characters the lexer skips. Trailing runs to the
+	// end of the token's line and Leading holds the rest since the
+	// previous token. They are only set in trivia mode.
+	Leading  string
+	Trailing string
 }
 
 type Lexer struct {
 	input       string
 	position    int
 	line        int
+	lineStart   int
 	indentStack []int
+	comments    []Token
+	// depth counts the open parentheses. Inside them newlines and
+	// indentation are not significant, so long calls can be split
+	// across lines.
+	depth int
+
+	// Trivia turns on trivia mode: Tokenize sets the Leading and
+	// Trailing trivia of the tokens, and newline tokens have "\n" as
+	// their Value, so that the tokens spell the source byte for byte.
+	// Indent and dedent tokens have no text and no trivia.
+	Trivia bool
 }
 
 func NewLexer(input string) *Lexer {
@@ -49,24 +75,41 @@ func (l *Lexer) Tokenize() []Token {
 		ch := l.input[l.position]
 
 		switch {
+		case ch == '\n' && l.depth > 0:
+			l.position++
+			l.line++
+			l.lineStart = l.position
 		case ch == '\n':
-			tokens = append(tokens, Token{Type: TokenNewline, Line: l.line})
+			tokens = append(tokens, Token{Type: TokenNewline, Line: l.line, Column: l.column()})
 			l.position++
 			l.line++
+			l.lineStart = l.position
 			l.handleIndent(&tokens)
 		case unicode.IsSpace(rune(ch)) && ch != '\n':
 			l.position++
 		case ch == '(':
-			tokens = append(tokens, Token{Type: TokenLeftParen, Value: "(", Line: l.line})
+			tokens = append(tokens, Token{Type: TokenLeftParen, Value: "(", Line: l.line, Column: l.column()})
 			l.position++
+			l.depth++
 		case ch == ')':
-			tokens = append(tokens, Token{Type: TokenRightParen, Value: ")", Line: l.line})
+			tokens = append(tokens, Token{Type: TokenRightParen, Value: ")", Line: l.line, Column: l.column()})
 			l.position++
+			if l.depth > 0 {
+				l.depth--
+			}
 		case ch == ':':
-			tokens = append(tokens, Token{Type: TokenColon, Value: ":", Line: l.line})
+			tokens = append(tokens, Token{Type: TokenColon, Value: ":", Line: l.line, Column: l.column()})
+			l.position++
+		case ch == '.':
+			tokens = append(tokens, Token{Type: TokenDot, Value: ".", Line: l.line, Column: l.column()})
+			l.position++
+		case ch == ',':
+			tokens = append(tokens, Token{Type: TokenComma, Value: ",", Line: l.line, Column: l.column()})
 			l.position++
 		case ch == '"':
 			tokens = append(tokens, l.readString())
+		case ch == '#':
+			l.readComment()
 		case unicode.IsLetter(rune(ch)):
 			tokens = append(tokens, l.readIdentifier())
 		default:
@@ -76,13 +119,54 @@ func (l *Lexer) Tokenize() []Token {
 
 	for len(l.indentStack) > 1 {
 		l.indentStack = l.indentStack[:len(l.indentStack)-1]
-		tokens = append(tokens, Token{Type: TokenDedent, Line: l.line})
+		tokens = append(tokens, Token{Type: TokenDedent, Line: l.line, Column: l.column()})
 	}
 
-	tokens = append(tokens, Token{Type: TokenEOF, Line: l.line})
+	tokens = append(tokens, Token{Type: TokenEOF, Line: l.line, Column: l.column()})
+	if l.Trivia {
+		l.attachTrivia(tokens)
+	}
 	return tokens
 }
 
+// attachTrivia gives each token the text between it and its neighbours:
+// up to the end of the line to the token before, the rest to the token
+// after. The EOF token leads with whatever ends the file.
+func (l *Lexer) attachTrivia(tokens []Token) {
+	lines := []int{0, 0}
+	for i := 0; i < len(l.input); i++ {
+		if l.input[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+
+	prev, end := -1, 0
+	for i := range tokens {
+		tok := &tokens[i]
+		if tok.Type == TokenIndent || tok.Type == TokenDedent {
+			continue
+		}
+		if tok.Type == TokenNewline {
+			tok.Value = "\n"
+		}
+		start := len(l.input)
+		if tok.Type != TokenEOF {
+			start = lines[tok.Line] + tok.Column - 1
+		}
+
+		gap := l.input[end:start]
+		if prev >= 0 && tokens[prev].Type != TokenNewline {
+			n := strings.IndexByte(gap, '\n')
+			if n < 0 {
+				n = len(gap)
+			}
+			tokens[prev].Trailing, gap = gap[:n], gap[n:]
+		}
+		tok.Leading = gap
+		prev, end = i, start+len(tok.Value)
+	}
+}
+
 func (l *Lexer) handleIndent(tokens *[]Token) {
 	indentLevel := 0
 	for l.position < len(l.input) && (l.input[l.position] == ' ' || l.input[l.position] == '\t') {
@@ -92,33 +176,75 @@ func (l *Lexer) handleIndent(tokens *[]Token) {
 		l.position++
 	}
 
+	// Blank lines and comment lines do not open or close blocks.
+	if l.position >= len(l.input) || l.input[l.position] == '\n' || l.input[l.position] == '\r' || l.input[l.position] == '#' {
+		return
+	}
+
 	currentIndent := l.indentStack[len(l.indentStack)-1]
 
 	if indentLevel > currentIndent {
 		l.indentStack = append(l.indentStack, indentLevel)
-		*tokens = append(*tokens, Token{Type: TokenIndent, Line: l.line})
+		*tokens = append(*tokens, Token{Type: TokenIndent, Line: l.line, Column: l.column()})
 	} else if indentLevel < currentIndent {
 		for len(l.indentStack) > 0 && l.indentStack[len(l.indentStack)-1] > indentLevel {
 			l.indentStack = l.indentStack[:len(l.indentStack)-1]
-			*tokens = append(*tokens, Token{Type: TokenDedent, Line: l.line})
+			*tokens = append(*tokens, Token{Type: TokenDedent, Line: l.line, Column: l.column()})
 		}
 	}
 }
 
 func (l *Lexer) readString() Token {
 	start := l.position
+	line, column := l.line, l.column()
 	l.position++
 
 	for l.position < len(l.input) && l.input[l.position] != '"' {
 		if l.input[l.position] == '\\' && l.position+1 < len(l.input) {
 			l.position++
 		}
+		if l.input[l.position] == '\n' {
+			l.line++
+			l.lineStart = l.position + 1
+		}
 		l.position++
 	}
 
-	l.position++
+	// An unterminated string runs to the end of the input; the parser
+	// reports it.
+	if l.position < len(l.input) {
+		l.position++
+	}
 	value := l.input[start:l.position]
-	return Token{Type: TokenString, Value: value, Line: l.line}
+	return Token{Type: TokenString, Value: value, Line: line, Column: column}
+}
+
+// terminated reports whether the string token value ends with its
+// closing quote.
+func terminated(value string) bool {
+	if len(value) < 2 || value[len(value)-1] != '"' {
+		return false
+	}
+	escapes := 0
+	for i := len(value) - 2; i > 0 && value[i] == '\\'; i-- {
+		escapes++
+	}
+	return escapes%2 == 0
+}
+
+// readComment records a comment running to the end of the line.
+func (l *Lexer) readComment() {
+	start := l.position
+	for l.position < len(l.input) && l.input[l.position] != '\n' {
+		l.position++
+	}
+	value := strings.TrimRight(l.input[start:l.position], "\r")
+	l.comments = append(l.comments, Token{Type: TokenComment, Value: value, Line: l.line, Column: start - l.lineStart + 1})
+}
+
+// Comments returns the comments found by Tokenize, in order.
+func (l *Lexer) Comments() []Token {
+	return l.comments
 }
 
 func (l *Lexer) readIdentifier() Token {
@@ -129,11 +255,31 @@ func (l *Lexer) readIdentifier() Token {
 	}
 
 	value := l.input[start:l.position]
-	return Token{Type: TokenIdentifier, Value: value, Line: l.line}
+	return Token{Type: TokenIdentifier, Value: value, Line: l.line, Column: start - l.lineStart + 1}
+}
+
+func (l *Lexer) column() int {
+	return l.position - l.lineStart + 1
 }
 
 func (t Token) String() string {
-	return fmt.Sprintf("Token{%s, %q, Line: %d}", t.typeName(), t.Value, t.Line)
+	return fmt.Sprintf("Token{%s, %q, Line: %d, Column: %d}", t.typeName(), t.Value, t.Line, t.Column)
+}
+
+// describe names the token for error messages.
+func (t Token) describe() string {
+	switch t.Type {
+	case TokenEOF:
+		return "end of file"
+	case TokenIndent:
+		return "indentation"
+	case TokenDedent:
+		return "dedent"
+	case TokenNewline:
+		return "newline"
+	default:
+		return "'" + t.Value + "'"
+	}
 }
 
 func (t Token) typeName() string {
@@ -158,6 +304,12 @@ func (t Token) typeName() string {
 		return "Dedent"
 	case TokenNewline:
 		return "Newline"
+	case TokenDot:
+		return "Dot"
+	case TokenComma:
+		return "Comma"
+	case TokenComment:
+		return "Comment"
 	default:
 		return "Unknown"
 	}
@@ -0,0 +1,363 @@
+package compiler
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// generateLLVM returns the whole program, with every module, as one
+// textual LLVM IR module (a .ll file) that only needs the C library:
+// clang main.ll -o main. Pointers are opaque (ptr), the default since
+// LLVM 15.
+//
+// Calls of Mob functions are counted like in the C runtime: past
+// MaxDepth the program prints the interpreter's stack overflow error
+// and exits with status 2.
+//
+// Values are only ever passed to print, so they are not materialized:
+// each argument compiles to the code that writes it, and calls whose
+// value is unused compile to nothing.
+func generateLLVM(res *Result) string {
+	g := &llvmGenerator{
+		dir:     filepath.Dir(res.File),
+		modules: make(map[string]*Module),
+		defined: map[string]map[string]Node{"": functionsOf(res.AST)},
+		strings: make(map[string]int),
+	}
+	for _, mod := range res.Modules {
+		g.modules[mod.Path] = mod
+		g.defined[mod.Path] = functionsOf(mod.AST)
+	}
+
+	g.module("", res.File, res.AST)
+	var inits []string
+	for _, mod := range InitOrder(res) {
+		if mod.Functions == nil {
+			inits = append(inits, llvmInitName(mod.Path))
+		}
+	}
+	for _, mod := range res.Modules {
+		if mod.Functions == nil {
+			g.module(mod.Path, mod.File, mod.AST)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "source_filename = %s\n", llvmString(g.display(res.File)))
+	if len(g.stringList) > 0 || g.usesArgs || g.usesDepth {
+		b.WriteString("\n")
+	}
+	for i, s := range g.stringList {
+		fmt.Fprintf(&b, "@.str.%d = private unnamed_addr constant [%d x i8] c%s\n", i, len(s), llvmString(s))
+	}
+	if g.usesArgs {
+		b.WriteString("@mob_argc = internal global i32 0\n@mob_argv = internal global ptr null\n")
+	}
+	if g.usesDepth {
+		fmt.Fprintf(&b, "@mob_depth = internal global i32 0\n@mob_max_depth = internal constant i32 %d\n", MaxDepth)
+	}
+
+	b.WriteString("\ndeclare i32 @putchar(i32)\n")
+	if g.usesArgs {
+		b.WriteString("declare i64 @strlen(ptr)\n")
+	}
+	if g.usesDepth {
+		b.WriteString("declare i32 @fflush(ptr)\ndeclare i64 @write(i32, ptr, i64)\ndeclare void @exit(i32)\n")
+	}
+
+	b.WriteString("\ndefine i32 @main(i32 %argc, ptr %argv) {\nentry:\n")
+	if g.usesArgs {
+		b.WriteString("  store i32 %argc, ptr @mob_argc\n  store ptr %argv, ptr @mob_argv\n")
+	}
+	for _, name := range append(inits, llvmInitName("")) {
+		fmt.Fprintf(&b, "  call void %s()\n", name)
+	}
+	b.WriteString("  ret i32 0\n}\n")
+
+	b.WriteString(g.functions.String())
+	if g.usesWrite {
+		b.WriteString(llvmWrite)
+	}
+	if g.usesArgs {
+		b.WriteString(llvmWriteArgs)
+	}
+	if g.usesDepth {
+		b.WriteString(llvmDepth)
+	}
+	return b.String()
+}
+
+type llvmGenerator struct {
+	// dir is the entry file's dir; positions are shown relative to it.
+	dir     string
+	modules map[string]*Module
+	// defined holds the functions of each module, by path.
+	defined    map[string]map[string]Node
+	strings    map[string]int
+	stringList []string
+	functions  strings.Builder
+	usesWrite  bool
+	usesArgs   bool
+	usesDepth  bool
+}
+
+func (g *llvmGenerator) display(file string) string {
+	if rel, err := filepath.Rel(g.dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		file = rel
+	}
+	return filepath.ToSlash(file)
+}
+
+// module generates the init function and the functions of the module
+// at path. Duplicates were reported by the resolver; the first wins.
+func (g *llvmGenerator) module(path, file string, program Node) {
+	g.function(llvmInitName(path), path, file, program.Children)
+	seen := make(map[string]bool)
+	for _, stmt := range program.Children {
+		if stmt.Type == NodeFunction && !seen[stmt.Value] {
+			seen[stmt.Value] = true
+			g.function(llvmFunctionName(path, stmt.Value), path, file, stmt.Children)
+		}
+	}
+}
+
+// function generates a function of stmts. Each statement is preceded
+// by a comment with its position. Statements after a return are
+// unreachable and generate nothing.
+func (g *llvmGenerator) function(name, path, file string, stmts []Node) {
+	b := &g.functions
+	fmt.Fprintf(b, "\ndefine internal void %s() {\nentry:\n", name)
+	for _, stmt := range stmts {
+		if stmt.Type != NodeCall && stmt.Type != NodeReturn {
+			continue
+		}
+		if stmt.Line > 0 {
+			fmt.Fprintf(b, "  ; %s:%d:%d\n", g.display(file), stmt.Line, stmt.Column)
+		}
+		if stmt.Type == NodeReturn {
+			break
+		}
+		g.call(path, file, stmt)
+	}
+	b.WriteString("  ret void\n}\n")
+}
+
+// call generates a call statement in file.
+func (g *llvmGenerator) call(path, file string, node Node) {
+	b := &g.functions
+	if i := strings.LastIndex(node.Value, "."); i >= 0 {
+		if mod := g.modules[node.Value[:i]]; mod != nil && mod.Functions != nil {
+			return
+		}
+		g.counted(file, node, llvmFunctionName(node.Value[:i], node.Value[i+1:]))
+		return
+	}
+	if _, ok := g.defined[path][node.Value]; ok {
+		g.counted(file, node, llvmFunctionName(path, node.Value))
+		return
+	}
+
+	switch node.Value {
+	case "print":
+		first := true
+		for _, arg := range node.Children {
+			if arg.Type != NodeString && arg.Type != NodeCall {
+				continue
+			}
+			if !first {
+				b.WriteString("  call i32 @putchar(i32 32)\n")
+			}
+			first = false
+			g.write(arg)
+		}
+		b.WriteString("  call i32 @putchar(i32 10)\n")
+	case "args":
+	default:
+		panic(fmt.Sprintf("%s is not supported by the LLVM backend", node.Value))
+	}
+}
+
+// counted generates a call of the Mob function fn at node between
+// mob_enter and mob_leave, which count the depth of calls. The error
+// mob_enter prints is a constant, the same as the interpreter's.
+func (g *llvmGenerator) counted(file string, node Node, fn string) {
+	g.usesDepth = true
+	err := &RuntimeError{File: file, Line: node.Line, Column: node.Column, Message: fmt.Sprintf("stack overflow: more than %d nested calls", MaxDepth)}
+	message := err.Error() + "\n"
+	fmt.Fprintf(&g.functions, "  call void @mob_enter(ptr @.str.%d, i64 %d)\n", g.constant(message), len(message))
+	fmt.Fprintf(&g.functions, "  call void %s()\n  call void @mob_leave()\n", fn)
+}
+
+// write generates the code that prints the value of node.
+func (g *llvmGenerator) write(node Node) {
+	b := &g.functions
+	switch {
+	case node.Type == NodeString:
+		g.writeString(node.Value)
+	case node.Value == "args":
+		g.usesArgs = true
+		g.usesWrite = true
+		b.WriteString("  call void @mob_write_args()\n")
+	default:
+		// Values of standard modules are known at compile time.
+		var value string
+		ok := false
+		if i := strings.LastIndex(node.Value, "."); i >= 0 {
+			if mod := g.modules[node.Value[:i]]; mod != nil {
+				value, ok = mod.Values[node.Value[i+1:]]
+			}
+		}
+		if !ok {
+			panic(fmt.Sprintf("%s is not supported by the LLVM backend", node.Value))
+		}
+		g.writeString(value)
+	}
+}
+
+func (g *llvmGenerator) writeString(s string) {
+	g.usesWrite = true
+	fmt.Fprintf(&g.functions, "  call void @mob_write(ptr @.str.%d, i64 %d)\n", g.constant(s), len(s))
+}
+
+// constant returns the index of the string constant holding s.
+func (g *llvmGenerator) constant(s string) int {
+	i, ok := g.strings[s]
+	if !ok {
+		i = len(g.stringList)
+		g.strings[s] = i
+		g.stringList = append(g.stringList, s)
+	}
+	return i
+}
+
+// llvmFunctionName is the IR name of function name of the module at
+// path: mob. and its qualified name. Functions of the entry file have
+// no dot of their own, so they cannot clash with module functions, and
+// helpers and C library names have no dots at all.
+func llvmFunctionName(path, name string) string {
+	if path == "" {
+		return `@"mob.` + name + `"`
+	}
+	return `@"mob.` + path + "." + name + `"`
+}
+
+// llvmInitName is the IR name of the function running the top-level
+// statements of the module at path, like the VM's <main> and <path>.
+func llvmInitName(path string) string {
+	if path == "" {
+		return `@"<main>"`
+	}
+	return `@"<` + path + `>"`
+}
+
+// llvmString quotes s for IR: printable ASCII as is, other bytes and
+// the quote and backslash as \XX hex escapes.
+func llvmString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			fmt.Fprintf(&b, "\\%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// llvmWrite prints n bytes at s, which may contain NULs.
+const llvmWrite = `
+define internal void @mob_write(ptr %s, i64 %n) {
+entry:
+  br label %loop
+
+loop:
+  %i = phi i64 [ 0, %entry ], [ %next, %body ]
+  %done = icmp eq i64 %i, %n
+  br i1 %done, label %exit, label %body
+
+body:
+  %p = getelementptr inbounds i8, ptr %s, i64 %i
+  %c = load i8, ptr %p
+  %ci = zext i8 %c to i32
+  call i32 @putchar(i32 %ci)
+  %next = add i64 %i, 1
+  br label %loop
+
+exit:
+  ret void
+}
+`
+
+// llvmWriteArgs prints the program arguments like fmt.Println prints a
+// []string: [a b].
+const llvmWriteArgs = `
+define internal void @mob_write_args() {
+entry:
+  %argc = load i32, ptr @mob_argc
+  %argv = load ptr, ptr @mob_argv
+  call i32 @putchar(i32 91)
+  br label %loop
+
+loop:
+  %i = phi i32 [ 1, %entry ], [ %next, %write ]
+  %done = icmp sge i32 %i, %argc
+  br i1 %done, label %exit, label %body
+
+body:
+  %first = icmp eq i32 %i, 1
+  br i1 %first, label %write, label %space
+
+space:
+  call i32 @putchar(i32 32)
+  br label %write
+
+write:
+  %index = sext i32 %i to i64
+  %p = getelementptr inbounds ptr, ptr %argv, i64 %index
+  %arg = load ptr, ptr %p
+  %len = call i64 @strlen(ptr %arg)
+  call void @mob_write(ptr %arg, i64 %len)
+  %next = add i32 %i, 1
+  br label %loop
+
+exit:
+  call i32 @putchar(i32 93)
+  ret void
+}
+`
+
+// llvmDepth counts the depth of calls. Past @mob_max_depth, mob_enter
+// flushes stdout, writes the n bytes of message to stderr and exits
+// with status 2, like mob_enter in the C runtime.
+const llvmDepth = `
+define internal void @mob_enter(ptr %message, i64 %n) {
+entry:
+  %depth = load i32, ptr @mob_depth
+  %max = load i32, ptr @mob_max_depth
+  %over = icmp sge i32 %depth, %max
+  br i1 %over, label %overflow, label %enter
+
+overflow:
+  call i32 @fflush(ptr null)
+  call i64 @write(i32 2, ptr %message, i64 %n)
+  call void @exit(i32 2)
+  unreachable
+
+enter:
+  %next = add i32 %depth, 1
+  store i32 %next, ptr @mob_depth
+  ret void
+}
+
+define internal void @mob_leave() {
+entry:
+  %depth = load i32, ptr @mob_depth
+  %next = sub i32 %depth, 1
+  store i32 %next, ptr @mob_depth
+  ret void
+}
+`
@@ -0,0 +1,417 @@
+package compiler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// goModulePath is the module path of the generated Go module. Each Mob
+// module becomes a package below it.
+const goModulePath = "mobapp"
+
+// Module is one imported .mob file.
+type Module struct {
+	// Path is the dotted import path, such as "utils" or "lib.strings".
+	Path      string
+	File      string
+	Source    string
+	Tokens    []Token
+	AST       Node
+	GoCode    string
+	SourceMap *SourceMap
+	CCode     string
+
+	// Functions describes the functions of a module provided by the
+	// compiler, such as mob.build; it is nil for .mob files. Values holds
+	// their results when they are known at compile time.
+	Functions map[string]Builtin
+	Values    map[string]string
+}
+
+// Importer locates the source of an imported module by its import path.
+type Importer interface {
+	Import(path string) (file string, src []byte, err error)
+}
+
+// Package is a dependency. Importing its name loads its entry file, and
+// "name.rest" loads rest from its source dirs.
+type Package struct {
+	Name       string
+	Entry      string
+	SourceDirs []string
+}
+
+// DirImporter resolves imports to .mob files under each root in turn,
+// so "lib.strings" is found at <root>/lib/strings.mob. Imports starting
+// with the name of one of Packages are looked up in that package only.
+type DirImporter struct {
+	Roots    []string
+	Packages []Package
+}
+
+func (d DirImporter) Import(path string) (string, []byte, error) {
+	first, rest, qualified := strings.Cut(path, ".")
+	for _, pkg := range d.Packages {
+		if pkg.Name != first {
+			continue
+		}
+		if !qualified {
+			src, err := os.ReadFile(pkg.Entry)
+			return pkg.Entry, src, err
+		}
+		return DirImporter{Roots: pkg.SourceDirs}.Import(rest)
+	}
+
+	rel := filepath.Join(strings.Split(path, ".")...) + ".mob"
+
+	var searched []string
+	for _, root := range d.Roots {
+		if root == "" {
+			continue
+		}
+		file, _ := filepath.Abs(filepath.Join(root, rel))
+		src, err := os.ReadFile(file)
+		if err == nil {
+			return file, src, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", nil, err
+		}
+		searched = append(searched, file)
+	}
+
+	return "", nil, fmt.Errorf("module %s not found (looked for %s)", path, strings.Join(searched, ", "))
+}
+
+// MapImporter serves module sources from memory, keyed by import path.
+type MapImporter map[string]string
+
+func (m MapImporter) Import(path string) (string, []byte, error) {
+	src, ok := m[path]
+	if !ok {
+		return "", nil, fmt.Errorf("module %s not found", path)
+	}
+	return filepath.Join(strings.Split(path, ".")...) + ".mob", []byte(src), nil
+}
+
+// importsOf returns the import statements at the top level of program.
+func importsOf(program Node) []Node {
+	var imports []Node
+	for _, stmt := range program.Children {
+		if stmt.Type == NodeImport || stmt.Type == NodeFromImport {
+			imports = append(imports, stmt)
+		}
+	}
+	return imports
+}
+
+// loadModules parses every module reachable from entry through imports,
+// in the order they are first imported. Each import path is loaded once.
+func (c *Compiler) loadModules(ctx context.Context, entryFile string, entry Node, opts Options, importer Importer) ([]*Module, Diagnostics) {
+	var modules []*Module
+	var diags Diagnostics
+	loaded := make(map[string]bool)
+
+	var visit func(file string, program Node)
+	visit = func(file string, program Node) {
+		for _, imp := range importsOf(program) {
+			if loaded[imp.Value] || ctx.Err() != nil {
+				continue
+			}
+			loaded[imp.Value] = true
+
+			if imp.Value == "mob.build" && opts.Build.Commit == "" && c.LookupCommit != nil {
+				c.commitOnce.Do(func() { c.commit = c.LookupCommit() })
+				opts.Build.Commit = c.commit
+			}
+			if mod, ok, err := loadStdModule(imp.Value, opts); ok {
+				if err != nil {
+					diags = append(diags, diagnosticAt(CodeImportNotFound, file, imp, "cannot import %s: %v", imp.Value, err))
+					continue
+				}
+				modules = append(modules, mod)
+				continue
+			}
+			if importer == nil {
+				diags = append(diags, diagnosticAt(CodeImportsUnavailable, file, imp, "cannot import %s: imports are not available here", imp.Value))
+				continue
+			}
+			modFile, src, err := importer.Import(imp.Value)
+			if err != nil {
+				diags = append(diags, diagnosticAt(CodeImportNotFound, file, imp, "cannot import %s: %v", imp.Value, err))
+				continue
+			}
+
+			mod := &Module{Path: imp.Value, File: modFile, Source: string(src)}
+			mod.Tokens = c.Tokenize(mod.Source)
+			parser := NewParser(mod.Tokens)
+			mod.AST = parser.Parse()
+			for _, d := range parser.Errors() {
+				d.File = modFile
+				diags = append(diags, d)
+			}
+
+			modules = append(modules, mod)
+			visit(modFile, mod.AST)
+		}
+	}
+	visit(entryFile, entry)
+
+	return modules, diags
+}
+
+// importEdge is one import statement in the module graph.
+type importEdge struct {
+	from, to string
+	imp      Node
+}
+
+// importCycles reports every import cycle among the entry file and the
+// modules it loaded. Files are the graph nodes, so an imported module
+// that is the entry file itself closes a cycle through the entry.
+//
+// A cycle is reported once, at the import in its alphabetically first
+// file, with a note at each import along the path. Files are named
+// relative to root, the project root, or to the entry's dir outside a
+// project. Reporting it the same way whichever file is compiled lets
+// mob lint merge duplicates.
+func importCycles(root, entryFile string, entry Node, modules []*Module) Diagnostics {
+	files := map[string]Node{entryFile: entry}
+	byPath := make(map[string]string)
+	for _, mod := range modules {
+		byPath[mod.Path] = mod.File
+		if _, ok := files[mod.File]; !ok {
+			files[mod.File] = mod.AST
+		}
+	}
+
+	if root == "" {
+		root = filepath.Dir(entryFile)
+	}
+	root, _ = filepath.Abs(root)
+	name := func(file string) string {
+		if rel, err := filepath.Rel(root, file); err == nil && filepath.IsAbs(file) {
+			return rel
+		}
+		return file
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var stack []importEdge
+	var diags Diagnostics
+
+	var visit func(file string)
+	visit = func(file string) {
+		state[file] = visiting
+		for _, imp := range importsOf(files[file]) {
+			to, ok := byPath[imp.Value]
+			if !ok {
+				continue
+			}
+			edge := importEdge{from: file, to: to, imp: imp}
+
+			switch state[to] {
+			case unvisited:
+				stack = append(stack, edge)
+				visit(to)
+				stack = stack[:len(stack)-1]
+			case visiting:
+				start := len(stack)
+				for start > 0 && stack[start-1].to != to {
+					start--
+				}
+				cycle := append(append([]importEdge(nil), stack[start:]...), edge)
+				diags = append(diags, cycleDiagnostic(cycle, name))
+			}
+		}
+		state[file] = done
+	}
+	visit(entryFile)
+
+	return diags
+}
+
+func cycleDiagnostic(cycle []importEdge, name func(string) string) Diagnostic {
+	first := 0
+	for i, edge := range cycle {
+		if name(edge.from) < name(cycle[first].from) {
+			first = i
+		}
+	}
+	cycle = append(append([]importEdge(nil), cycle[first:]...), cycle[:first]...)
+
+	path := []string{name(cycle[0].from)}
+	var notes []Diagnostic
+	for _, edge := range cycle {
+		path = append(path, name(edge.to))
+		notes = append(notes, diagnosticAt("", edge.from, edge.imp, "imports %s", edge.imp.Value))
+	}
+
+	d := diagnosticAt(CodeImportCycle, cycle[0].from, cycle[0].imp, "import cycle not allowed: %s", strings.Join(path, " -> "))
+	d.Notes = notes
+	return d
+}
+
+// InitOrder returns the modules whose top-level statements run before
+// the entry's, in the order they run. A module runs when the program
+// calls one of its functions, directly or through other modules, and
+// modules run in Go's package initialization order: repeatedly, the one
+// with the smallest Go import path among those whose dependencies have
+// all run. Generated code gets this order from the Go runtime; the
+// interpreter follows it explicitly.
+func InitOrder(res *Result) []*Module {
+	byPath := make(map[string]*Module)
+	for _, mod := range res.Modules {
+		byPath[mod.Path] = mod
+	}
+
+	deps := make(map[string][]string)
+	var pending []string
+	var visit func(path string, program Node)
+	visit = func(path string, program Node) {
+		for _, dep := range calledModules(program) {
+			mod, ok := byPath[dep]
+			if !ok {
+				continue
+			}
+			deps[path] = append(deps[path], dep)
+			if _, seen := deps[dep]; !seen {
+				deps[dep] = nil
+				pending = append(pending, dep)
+				visit(dep, mod.AST)
+			}
+		}
+	}
+	visit("", res.AST)
+	sort.Slice(pending, func(i, j int) bool {
+		return goImportPath(pending[i]) < goImportPath(pending[j])
+	})
+
+	done := make(map[string]bool)
+	var order []*Module
+	for progress := true; progress; {
+		progress = false
+		for i, path := range pending {
+			if !allDone(deps[path], done) {
+				continue
+			}
+			done[path] = true
+			order = append(order, byPath[path])
+			pending = append(pending[:i], pending[i+1:]...)
+			progress = true
+			break
+		}
+	}
+	return order
+}
+
+func allDone(paths []string, done map[string]bool) bool {
+	for _, path := range paths {
+		if !done[path] {
+			return false
+		}
+	}
+	return true
+}
+
+// calledModules returns the modules whose functions program calls, in
+// the order of the first call.
+func calledModules(program Node) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	var walk func(node Node)
+	walk = func(node Node) {
+		if node.Type == NodeCall {
+			if i := strings.LastIndex(node.Value, "."); i >= 0 && !seen[node.Value[:i]] {
+				seen[node.Value[:i]] = true
+				paths = append(paths, node.Value[:i])
+			}
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(program)
+	return paths
+}
+
+// diagnosticAt returns an error with code, spanning the token node is
+// positioned at. Notes have no code.
+func diagnosticAt(code, file string, node Node, format string, args ...any) Diagnostic {
+	d := Diagnostic{
+		File:     file,
+		Line:     node.Line,
+		Column:   node.Column,
+		Code:     code,
+		Severity: SeverityError,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	d.EndLine, d.EndColumn = node.End()
+	return d
+}
+
+// goPackageName is the Go package a Mob module compiles to; distinct
+// paths get distinct names. Paths whose segments have no underscore
+// become their segments joined by underscores, when that starts with a
+// lowercase letter, clear of the exported function names, and is not a
+// Go keyword, main, init or a package the generated code imports. Any
+// other path is m__ and its length-prefixed segments, like
+// cModulePrefix: a.b is a_b, but a_b is m__3a_b.
+func goPackageName(path string) string {
+	segments := strings.Split(path, ".")
+	name := strings.Join(segments, "_")
+	r, _ := utf8.DecodeRuneInString(name)
+	if strings.Count(name, "_") == len(segments)-1 && unicode.IsLower(r) && !token.IsKeyword(name) {
+		switch name {
+		case "main", "init", "fmt", "os":
+		default:
+			return name
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("m__")
+	for _, segment := range segments {
+		b.WriteString(strconv.Itoa(len(segment)) + segment)
+	}
+	return b.String()
+}
+
+// goImportPath is the import path of the Go package for a Mob module.
+func goImportPath(path string) string {
+	return goModulePath + "/" + strings.ReplaceAll(path, ".", "/")
+}
+
+// goFileName is where the Go code of a Mob module is written inside the
+// generated module.
+func goFileName(path string) string {
+	parts := strings.Split(path, ".")
+	return filepath.Join(append(parts, parts[len(parts)-1]+".go")...)
+}
+
+// exportName is the Go name of a Mob function. Capitalizing exports it
+// from its package and keeps it clear of Go keywords and of main/init.
+// Names that do not start with a lowercase ASCII letter, and those that
+// would then start with X_, get an X_ prefix instead, so distinct Mob
+// names stay distinct: foo is Foo, Foo is X_Foo and x_y is X_x_y.
+func exportName(name string) string {
+	if name == "" || name[0] < 'a' || name[0] > 'z' || strings.HasPrefix(name, "x_") {
+		return "X_" + name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
@@ -1,7 +1,6 @@
 package compiler
 
 import (
-	"fmt"
 	"strings"
 )
 
@@ -12,17 +11,77 @@ const (
 	NodeCall
 	NodeString
 	NodeIdentifier
+	NodeImport
+	NodeFromImport
+	NodeFunction
+	NodeReturn
 )
 
 type Node struct {
-	Type     NodeType
-	Value    string
-	Children []Node
+	Type     NodeType `json:"type"`
+	Value    string   `json:"value,omitempty"`
+	Children []Node   `json:"children,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+}
+
+func (t NodeType) String() string {
+	switch t {
+	case NodeProgram:
+		return "Program"
+	case NodeCall:
+		return "Call"
+	case NodeString:
+		return "String"
+	case NodeIdentifier:
+		return "Identifier"
+	case NodeImport:
+		return "Import"
+	case NodeFromImport:
+		return "FromImport"
+	case NodeFunction:
+		return "Function"
+	case NodeReturn:
+		return "Return"
+	default:
+		return "Unknown"
+	}
+}
+
+func (t NodeType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// End returns the position just past the token node is positioned at:
+// the name of a call or identifier, a quoted string, or the keyword
+// starting a statement.
+func (n Node) End() (line, column int) {
+	switch n.Type {
+	case NodeCall, NodeIdentifier:
+		return n.Line, n.Column + len(n.Value)
+	case NodeString:
+		return n.Line, n.Column + len(n.Value) + 2
+	case NodeImport:
+		return n.Line, n.Column + len("import")
+	case NodeFromImport:
+		return n.Line, n.Column + len("from")
+	case NodeFunction:
+		return n.Line, n.Column + len("function")
+	case NodeReturn:
+		return n.Line, n.Column + len("return")
+	default:
+		return n.Line, n.Column
+	}
 }
 
 type Parser struct {
-	tokens  []Token
-	current int
+	tokens     []Token
+	current    int
+	errors     Diagnostics
+	inFunction bool
+	// open is the stack of syntax nodes being built. Consumed tokens
+	// go to the innermost one.
+	open []*Syntax
 }
 
 func NewParser(tokens []Token) *Parser {
@@ -32,75 +91,315 @@ func NewParser(tokens []Token) *Parser {
 	}
 }
 
+// Parse parses the tokens into the AST of a file, derived from its
+// concrete syntax tree.
 func (p *Parser) Parse() Node {
-	return Node{
-		Type:     NodeProgram,
-		Children: p.parseStatements(),
+	return p.ParseSyntax().AST()
+}
+
+// ParseSyntax parses the tokens into the concrete syntax tree of a
+// file, which holds every token, the final EOF included.
+func (p *Parser) ParseSyntax() *Syntax {
+	file := p.start(SyntaxFile)
+	p.parseStatements()
+	p.finish()
+	file.Children = append(file.Children, &Syntax{Kind: SyntaxToken, Token: p.peek()})
+	return file
+}
+
+// start opens a syntax node of kind as a child of the innermost open
+// node, or as the root.
+func (p *Parser) start(kind SyntaxKind) *Syntax {
+	node := &Syntax{Kind: kind}
+	if len(p.open) > 0 {
+		parent := p.open[len(p.open)-1]
+		parent.Children = append(parent.Children, node)
 	}
+	p.open = append(p.open, node)
+	return node
 }
 
-func (p *Parser) parseStatements() []Node {
-	var statements []Node
+// precede opens a syntax node of kind around the last child of the
+// innermost open node, such as a call around the name before its '('.
+func (p *Parser) precede(kind SyntaxKind) {
+	parent := p.open[len(p.open)-1]
+	last := len(parent.Children) - 1
+	node := &Syntax{Kind: kind, Children: []*Syntax{parent.Children[last]}}
+	parent.Children[last] = node
+	p.open = append(p.open, node)
+}
 
+// finish closes the innermost open node.
+func (p *Parser) finish() {
+	p.open = p.open[:len(p.open)-1]
+}
+
+// abandon closes the innermost open node as an invalid statement,
+// which the AST leaves out.
+func (p *Parser) abandon() {
+	p.open[len(p.open)-1].Kind = SyntaxError
+	p.finish()
+}
+
+func (p *Parser) parseStatements() {
+	// Blank and comment lines before the first statement leave newlines.
+	p.skipNewlines()
 	for !p.isAtEnd() {
-		stmt := p.parseStatement()
-		if stmt.Type != NodeProgram || len(stmt.Children) > 0 {
-			statements = append(statements, stmt)
-		}
+		p.parseStatement()
 		p.skipNewlines()
 	}
+}
 
-	return statements
+// Errors returns the syntax errors found by Parse. Positions are set
+// but File is left for the caller to fill in.
+func (p *Parser) Errors() Diagnostics {
+	return p.errors
 }
 
-func (p *Parser) parseStatement() Node {
-	if p.match(TokenIdentifier) {
-		ident := p.previous()
-		return p.parseCall(ident)
+func (p *Parser) parseStatement() {
+	if p.check(TokenIdentifier) {
+		switch p.peek().Value {
+		case "import":
+			p.parseImport()
+			return
+		case "from":
+			p.parseFromImport()
+			return
+		case "function":
+			p.parseFunction()
+			return
+		case "return":
+			p.parseReturn()
+			return
+		}
+
+		p.start(SyntaxCall)
+		p.parseName("expected name")
+		p.parseArguments()
+		p.finish()
+		return
 	}
 
-	return Node{Type: NodeProgram}
+	p.start(SyntaxError)
+	if p.check(TokenIndent) {
+		p.error(CodeIndentation, p.peek(), "unexpected indentation")
+		p.skipBlock()
+	} else {
+		p.error(CodeSyntax, p.peek(), "unexpected "+p.peek().describe())
+		p.synchronize()
+	}
+	p.finish()
 }
 
-func (p *Parser) parseCall(ident Token) Node {
-	call := Node{
-		Type:  NodeCall,
-		Value: ident.Value,
+// parseImport parses `import utils` or `import lib.strings`.
+func (p *Parser) parseImport() {
+	p.start(SyntaxImport)
+	p.advance()
+
+	if !p.parseName("expected module name after 'import'") {
+		p.synchronize()
+		p.abandon()
+		return
 	}
 
-	if p.match(TokenLeftParen) {
-		if !p.check(TokenRightParen) {
-			for {
-				arg := p.parseExpression()
-				call.Children = append(call.Children, arg)
+	p.endStatement()
+	p.finish()
+}
 
-				if p.check(TokenRightParen) {
-					break
-				}
-			}
+// parseFromImport parses `from utils import helper, other`.
+func (p *Parser) parseFromImport() {
+	p.start(SyntaxFromImport)
+	p.advance()
+
+	if !p.parseName("expected module name after 'from'") {
+		p.synchronize()
+		p.abandon()
+		return
+	}
+
+	if !p.check(TokenIdentifier) || p.peek().Value != "import" {
+		p.error(CodeSyntax, p.peek(), "expected 'import' after module name, found "+p.peek().describe())
+		p.synchronize()
+		p.abandon()
+		return
+	}
+	p.advance()
+
+	for {
+		if !p.consume(TokenIdentifier, "expected name to import") {
+			p.synchronize()
+			p.abandon()
+			return
+		}
+		if !p.match(TokenComma) {
+			break
 		}
-		p.consume(TokenRightParen, "Expect ')' after arguments")
 	}
 
-	return call
+	p.endStatement()
+	p.finish()
 }
 
-func (p *Parser) parseExpression() Node {
-	if p.match(TokenString) {
-		return Node{
-			Type:  NodeString,
-			Value: strings.Trim(p.previous().Value, `"`),
+// parseFunction parses a top-level `function name():` definition and its
+// indented body.
+func (p *Parser) parseFunction() {
+	p.start(SyntaxFunction)
+	keyword := p.advance()
+
+	if p.inFunction {
+		p.error(CodeNestedFunction, keyword, "functions can only be defined at the top level")
+	}
+
+	if !p.consume(TokenIdentifier, "expected function name after 'function'") {
+		p.synchronize()
+		p.abandon()
+		return
+	}
+
+	if !p.consume(TokenLeftParen, "expected '(' after function name") {
+		p.synchronize()
+		p.abandon()
+		return
+	}
+	if !p.check(TokenRightParen) {
+		p.error(CodeParameters, p.peek(), "function parameters are not supported yet")
+		for !p.isAtEnd() && !p.check(TokenRightParen) && !p.check(TokenNewline) {
+			p.advance()
 		}
 	}
+	if !p.consume(TokenRightParen, "expected ')' after parameters") || !p.consume(TokenColon, "expected ':' after function signature") {
+		p.synchronize()
+		p.abandon()
+		return
+	}
 
-	if p.match(TokenIdentifier) {
-		return Node{
-			Type:  NodeIdentifier,
-			Value: p.previous().Value,
+	outer := p.inFunction
+	p.inFunction = true
+	p.parseBlock()
+	p.inFunction = outer
+
+	p.finish()
+}
+
+func (p *Parser) parseReturn() {
+	p.start(SyntaxReturn)
+	keyword := p.advance()
+	if !p.inFunction {
+		p.error(CodeReturnOutside, keyword, "'return' outside function")
+	}
+
+	if !p.isAtEnd() && !p.check(TokenNewline) && !p.check(TokenDedent) {
+		p.error(CodeReturnValue, p.peek(), "return values are not supported yet")
+		p.synchronize()
+	}
+
+	p.finish()
+}
+
+// parseBlock parses the indented statements following a ':'.
+func (p *Parser) parseBlock() {
+	p.start(SyntaxBlock)
+	defer p.finish()
+
+	if !p.consume(TokenNewline, "expected newline after ':'") {
+		p.synchronize()
+		return
+	}
+	p.skipNewlines()
+	if !p.consume(TokenIndent, "expected an indented block") {
+		return
+	}
+
+	for !p.isAtEnd() && !p.check(TokenDedent) {
+		p.parseStatement()
+		p.skipNewlines()
+	}
+	p.match(TokenDedent)
+}
+
+// parseName parses a name with any `.name` parts that follow, reporting
+// message when there is no name.
+func (p *Parser) parseName(message string) bool {
+	if !p.check(TokenIdentifier) {
+		p.error(CodeSyntax, p.peek(), message+", found "+p.peek().describe())
+		return false
+	}
+
+	p.start(SyntaxName)
+	p.advance()
+	for p.check(TokenDot) {
+		p.advance()
+		if !p.consume(TokenIdentifier, "expected name after '.'") {
+			break
 		}
 	}
+	p.finish()
+	return true
+}
 
-	return Node{Type: NodeProgram}
+// endStatement reports anything left on the line after a statement that
+// must stand alone.
+func (p *Parser) endStatement() {
+	if p.isAtEnd() || p.check(TokenNewline) || p.check(TokenDedent) {
+		return
+	}
+	p.error(CodeTrailingTokens, p.peek(), "expected end of line, found "+p.peek().describe())
+	p.synchronize()
+}
+
+// parseArguments parses the parenthesized arguments of the open call,
+// if any: a statement may call a function by its name alone.
+func (p *Parser) parseArguments() {
+	if !p.match(TokenLeftParen) {
+		return
+	}
+	open := p.previous()
+
+	for !p.check(TokenRightParen) {
+		if !p.parseExpression() {
+			p.error(CodeSyntax, p.peek(), "expected expression, found "+p.peek().describe())
+			p.synchronize()
+			return
+		}
+		if !p.match(TokenComma) {
+			break
+		}
+	}
+
+	// Lines inside parentheses join, so a missing ')' shows up on a
+	// later line; point at the '(' instead.
+	if !p.check(TokenRightParen) && p.peek().Line > p.previous().Line {
+		p.error(CodeSyntax, open, "'(' is never closed")
+		p.synchronize()
+	} else if !p.consume(TokenRightParen, "expected ')' after arguments") {
+		p.synchronize()
+	}
+}
+
+// parseExpression parses a string, a name or a call, reporting whether
+// there was one.
+func (p *Parser) parseExpression() bool {
+	if p.check(TokenString) {
+		p.start(SyntaxString)
+		tok := p.advance()
+		if !terminated(tok.Value) {
+			p.error(CodeSyntax, tok, "unterminated string")
+		}
+		p.finish()
+		return true
+	}
+
+	if p.check(TokenIdentifier) {
+		p.parseName("expected expression")
+		if p.check(TokenLeftParen) {
+			p.precede(SyntaxCall)
+			p.parseArguments()
+			p.finish()
+		}
+		return true
+	}
+
+	return false
 }
 
 func (p *Parser) match(tokenType TokenType) bool {
@@ -121,6 +420,10 @@ func (p *Parser) check(tokenType TokenType) bool {
 func (p *Parser) advance() Token {
 	if !p.isAtEnd() {
 		p.current++
+		if len(p.open) > 0 {
+			parent := p.open[len(p.open)-1]
+			parent.Children = append(parent.Children, &Syntax{Kind: SyntaxToken, Token: p.previous()})
+		}
 	}
 	return p.previous()
 }
@@ -137,11 +440,51 @@ func (p *Parser) previous() Token {
 	return p.tokens[p.current-1]
 }
 
-func (p *Parser) consume(tokenType TokenType, message string) Token {
+func (p *Parser) consume(tokenType TokenType, message string) bool {
 	if p.check(tokenType) {
-		return p.advance()
+		p.advance()
+		return true
+	}
+	p.error(CodeSyntax, p.peek(), message+", found "+p.peek().describe())
+	return false
+}
+
+func (p *Parser) error(code string, tok Token, message string) {
+	d := Diagnostic{
+		Line:     tok.Line,
+		Column:   tok.Column,
+		Code:     code,
+		Severity: SeverityError,
+		Message:  message,
+	}
+	if tok.Value != "" && !strings.Contains(tok.Value, "\n") {
+		d.EndLine, d.EndColumn = tok.Line, tok.Column+len(tok.Value)
+	}
+	p.errors = append(p.errors, d)
+}
+
+// synchronize skips the rest of the current line after a syntax error.
+func (p *Parser) synchronize() {
+	for !p.isAtEnd() && !p.check(TokenNewline) {
+		p.advance()
+	}
+}
+
+// skipBlock skips an indented block the parser does not understand,
+// including any blocks nested inside it.
+func (p *Parser) skipBlock() {
+	depth := 0
+	for !p.isAtEnd() {
+		switch p.advance().Type {
+		case TokenIndent:
+			depth++
+		case TokenDedent:
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
 	}
-	panic(fmt.Sprintf("%s at line %d", message, p.peek().Line))
 }
 
 func (p *Parser) skipNewlines() {
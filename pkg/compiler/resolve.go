@@ -0,0 +1,246 @@
+package compiler
+
+import (
+	"strings"
+)
+
+// functionsOf returns the functions defined at the top level of program.
+func functionsOf(program Node) map[string]Node {
+	functions := make(map[string]Node)
+	for _, stmt := range program.Children {
+		if stmt.Type == NodeFunction {
+			if _, ok := functions[stmt.Value]; !ok {
+				functions[stmt.Value] = stmt
+			}
+		}
+	}
+	return functions
+}
+
+// resolver checks the names a module uses. Calls to from-imported
+// functions are rewritten to their qualified module.name form, so later
+// stages only see builtin, local or qualified calls.
+type resolver struct {
+	file      string
+	modules   map[string]*Module
+	functions map[string]Node
+	imported  map[string]bool
+	names     map[string]string
+	// unloaded are the names brought in by imports that failed, which
+	// were already reported.
+	unloaded map[string]bool
+	diags    Diagnostics
+}
+
+// resolveModule resolves program, the module defined in file, against
+// the other loaded modules, keyed by import path. Modules that failed to
+// load are absent; imports of them were already reported.
+func resolveModule(file string, program *Node, modules map[string]*Module) Diagnostics {
+	r := &resolver{
+		file:      file,
+		modules:   modules,
+		functions: make(map[string]Node),
+		imported:  make(map[string]bool),
+		names:     make(map[string]string),
+		unloaded:  make(map[string]bool),
+	}
+
+	for _, stmt := range program.Children {
+		if stmt.Type != NodeFunction {
+			continue
+		}
+		if prev, ok := r.functions[stmt.Value]; ok {
+			r.errorf(CodeRedefined, stmt, "function %s is already defined at line %d", stmt.Value, prev.Line)
+			continue
+		}
+		r.functions[stmt.Value] = stmt
+	}
+
+	for _, imp := range importsOf(*program) {
+		r.declareImport(imp)
+	}
+
+	for i := range program.Children {
+		stmt := &program.Children[i]
+		switch stmt.Type {
+		case NodeImport, NodeFromImport:
+		case NodeFunction:
+			r.resolveBlock(stmt.Children)
+		default:
+			r.resolveNode(stmt)
+		}
+	}
+
+	return r.diags
+}
+
+func (r *resolver) declareImport(imp Node) {
+	mod, ok := r.modules[imp.Value]
+	if !ok {
+		r.unloaded[imp.Value] = true
+		for _, name := range imp.Children {
+			r.unloaded[name.Value] = true
+		}
+		return
+	}
+
+	if imp.Type == NodeImport {
+		r.imported[imp.Value] = true
+		return
+	}
+
+	exported := functionsOf(mod.AST)
+	for _, name := range imp.Children {
+		if _, ok := exported[name.Value]; !ok {
+			r.errorf(CodeNoSuchFunction, name, "module %s has no function %s", imp.Value, name.Value)
+			continue
+		}
+		if fn, ok := r.functions[name.Value]; ok {
+			r.errorf(CodeImportedAndDefined, name, "%s is imported from %s but also defined at line %d", name.Value, imp.Value, fn.Line)
+			continue
+		}
+		if other, ok := r.names[name.Value]; ok && other != imp.Value {
+			r.errorf(CodeImportedTwice, name, "%s is already imported from %s", name.Value, other)
+			continue
+		}
+		r.names[name.Value] = imp.Value
+	}
+}
+
+func (r *resolver) resolveBlock(stmts []Node) {
+	for i := range stmts {
+		r.resolveNode(&stmts[i])
+	}
+}
+
+func (r *resolver) resolveNode(node *Node) {
+	switch node.Type {
+	case NodeImport, NodeFromImport:
+		r.errorf(CodeImportNotTopLevel, *node, "imports must be at the top level")
+	case NodeCall:
+		r.resolveCall(node)
+		r.resolveBlock(node.Children)
+		callee, isBuiltin := r.builtin(node.Value)
+		for _, arg := range node.Children {
+			if arg.Type != NodeCall {
+				continue
+			}
+			if !r.returnsValue(arg.Value) {
+				r.errorf(CodeNoValue, arg, "%s() is used as a value but returns nothing", arg.Value)
+			} else if b, ok := r.builtin(arg.Value); ok && b.List && isBuiltin && !callee.Lists {
+				r.errorf(CodeListArgument, arg, "%s() returns a list, but %s takes strings", arg.Value, node.Value)
+			}
+		}
+	case NodeIdentifier:
+		r.errorf(CodeUndefined, *node, "undefined: %s", node.Value)
+	}
+}
+
+func (r *resolver) resolveCall(call *Node) {
+	name := call.Value
+
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		prefix, fn := name[:i], name[i+1:]
+		if r.unloaded[prefix] {
+			return
+		}
+		if !r.imported[prefix] {
+			if _, loaded := r.modules[prefix]; loaded {
+				r.errorf(CodeUndefined, *call, "undefined: %s (add 'import %s' to use it qualified)", prefix, prefix)
+				r.diags[len(r.diags)-1].Suggestions = []Suggestion{{
+					Message: "add 'import " + prefix + "'",
+					Edits:   []TextEdit{{Line: 1, Column: 1, EndLine: 1, EndColumn: 1, NewText: "import " + prefix + "\n"}},
+				}}
+			} else {
+				r.errorf(CodeUndefined, *call, "undefined: %s", prefix)
+			}
+			return
+		}
+		mod := r.modules[prefix]
+		if _, ok := functionsOf(mod.AST)[fn]; !ok {
+			r.errorf(CodeNoSuchFunction, *call, "module %s has no function %s", prefix, fn)
+			return
+		}
+		if builtin, ok := mod.Functions[fn]; ok {
+			r.checkArgs(*call, builtin)
+			return
+		}
+		r.checkNoArgs(*call)
+		return
+	}
+
+	if _, ok := r.functions[name]; ok {
+		r.checkNoArgs(*call)
+		return
+	}
+	if path, ok := r.names[name]; ok {
+		call.Value = path + "." + name
+		if builtin, ok := r.modules[path].Functions[name]; ok {
+			r.checkArgs(*call, builtin)
+			return
+		}
+		r.checkNoArgs(*call)
+		return
+	}
+	if builtin, ok := Builtins[name]; ok {
+		r.checkArgs(*call, builtin)
+		return
+	}
+	if r.unloaded[name] {
+		return
+	}
+
+	r.errorf(CodeUndefined, *call, "undefined function: %s", name)
+}
+
+// returnsValue reports whether the resolved function name returns a
+// value. Mob functions return nothing; builtins and the functions of
+// standard modules may. Unresolved names were already reported.
+func (r *resolver) returnsValue(name string) bool {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		mod, ok := r.modules[name[:i]]
+		if !ok || mod.Functions == nil {
+			return !ok
+		}
+		return mod.Functions[name[i+1:]].Value
+	}
+	if builtin, ok := Builtins[name]; ok {
+		return builtin.Value
+	}
+	_, defined := r.functions[name]
+	return !defined
+}
+
+// builtin returns the description of the resolved function name when it
+// is a builtin or a function of a standard module.
+func (r *resolver) builtin(name string) (Builtin, bool) {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		mod, ok := r.modules[name[:i]]
+		if !ok {
+			return Builtin{}, false
+		}
+		builtin, ok := mod.Functions[name[i+1:]]
+		return builtin, ok
+	}
+	if _, defined := r.functions[name]; defined {
+		return Builtin{}, false
+	}
+	builtin, ok := Builtins[name]
+	return builtin, ok
+}
+
+func (r *resolver) checkArgs(call Node, builtin Builtin) {
+	if !builtin.CheckArgs(len(call.Children)) {
+		r.errorf(CodeArguments, call, "%s takes %s", call.Value, builtin.arity())
+	}
+}
+
+func (r *resolver) checkNoArgs(call Node) {
+	if len(call.Children) > 0 {
+		r.errorf(CodeArguments, call, "%s takes no arguments", call.Value)
+	}
+}
+
+func (r *resolver) errorf(code string, node Node, format string, args ...any) {
+	r.diags = append(r.diags, diagnosticAt(code, r.file, node, format, args...))
+}
@@ -0,0 +1,161 @@
+package compiler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// CompileAndRun builds filename and runs it with args, connected to the
+// compiler's Stdin, Stdout and Stderr. When the program exits with a
+// non-zero status the returned error is an *exec.ExitError; ExitCode
+// turns it into the status mob should exit with.
+func (c *Compiler) CompileAndRun(filename string, args ...string) error {
+	if c.Target != (Target{}) {
+		return fmt.Errorf("cannot run a program built for %s; programs run on the host", c.Target)
+	}
+	if c.Backend != BackendGo {
+		return fmt.Errorf("cannot run with the %s backend; programs run through Go", c.Backend)
+	}
+
+	// Interrupts cancel the build or are forwarded to the program, and
+	// mob itself stays alive long enough to remove its temp files.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var child *os.Process
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				mu.Lock()
+				if child != nil {
+					child.Signal(sig)
+				} else {
+					cancel()
+				}
+				mu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	binaryPath, cleanup, err := c.buildForRun(ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	cmd := exec.Command(binaryPath, args...)
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+
+	mu.Lock()
+	err = cmd.Start()
+	child = cmd.Process
+	mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to start program: %w", err)
+	}
+	return cmd.Wait()
+}
+
+// ExitCode reports the status a program run by CompileAndRun exited
+// with. Programs killed by a signal report 128+signal, like shells do.
+func ExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), true
+	}
+	return exitErr.ExitCode(), true
+}
+
+// buildForRun returns a binary for filename, reusing the build cache
+// when one is configured. Without a cache the binary is built in a
+// private temp dir, so concurrent runs never share files and nothing is
+// written to the working directory.
+func (c *Compiler) buildForRun(ctx context.Context, filename string) (string, func(), error) {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read source file: %w", err)
+	}
+
+	res, err := c.compileFile(filename, source)
+	if err != nil {
+		return "", nil, err
+	}
+	if res.Diagnostics.HasErrors() {
+		return "", nil, res.Diagnostics
+	}
+
+	binaryName := binaryNameFor(filename)
+	build := func(moduleDir, binaryPath string) error {
+		return c.buildGoModule(ctx, moduleDir, binaryPath)
+	}
+
+	if c.Cache != nil {
+		sourceFile, _ := filepath.Abs(filename)
+		sources := map[string][]byte{sourceFile: source}
+		for _, mod := range res.Modules {
+			sources[mod.File] = []byte(mod.Source)
+			if mod.Functions != nil {
+				// Standard modules have no source; their code holds
+				// values such as the commit mob.build reports.
+				sources[mod.File] = []byte(mod.GoCode)
+			}
+		}
+
+		// Binaries from another Go release are not reused. The go
+		// command only runs, to check its version, on a miss.
+		goKey, err := c.toolchainKey()
+		if err != nil {
+			return "", nil, err
+		}
+		key := c.Cache.Key(c.Version+" go "+goKey, c.Options, sources)
+		if path, ok := c.Cache.Lookup(key, binaryName); ok {
+			return path, func() {}, nil
+		}
+
+		path, err := c.Cache.Store(key, binaryName, res.GoFiles(), build)
+		if err != nil {
+			return "", nil, translateBuildError(err, res, displayName(filename))
+		}
+		return path, func() {}, nil
+	}
+
+	tempDir, err := os.MkdirTemp("", "mob_run_*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(tempDir) }
+
+	moduleDir := filepath.Join(tempDir, "src")
+	if err := writeFiles(moduleDir, res.GoFiles()); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	binaryPath := filepath.Join(tempDir, binaryName)
+	if err := build(moduleDir, binaryPath); err != nil {
+		cleanup()
+		return "", nil, translateBuildError(err, res, displayName(filename))
+	}
+	return binaryPath, cleanup, nil
+}
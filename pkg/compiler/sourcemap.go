@@ -0,0 +1,51 @@
+package compiler
+
+// SourceMap relates positions the Go toolchain reports for generated
+// code back to the Mob nodes that produced it. Directives are written
+// per statement, so a reported column is the statement column plus the
+// byte offset within the generated Go line; expression offsets recover
+// the precise Mob column from there.
+type SourceMap struct {
+	File  string
+	stmts []mappedStmt
+}
+
+type mappedStmt struct {
+	line   int
+	column int
+	exprs  []mappedExpr
+}
+
+type mappedExpr struct {
+	offset int
+	line   int
+	column int
+}
+
+func (m *SourceMap) Resolve(line, column int) (int, int) {
+	if m == nil {
+		return line, column
+	}
+
+	var stmt *mappedStmt
+	for i := range m.stmts {
+		s := &m.stmts[i]
+		if s.line == line && s.column <= column && (stmt == nil || s.column > stmt.column) {
+			stmt = s
+		}
+	}
+	if stmt == nil {
+		return line, column
+	}
+
+	offset := column - stmt.column
+	resolvedLine, resolvedColumn := stmt.line, stmt.column
+	best := -1
+	for _, e := range stmt.exprs {
+		if e.offset <= offset && e.offset > best {
+			best = e.offset
+			resolvedLine, resolvedColumn = e.line, e.column
+		}
+	}
+	return resolvedLine, resolvedColumn
+}
@@ -0,0 +1,135 @@
+package compiler
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+)
+
+// BuildInfo describes the program being built. Mob code reads it
+// through the mob.build module.
+type BuildInfo struct {
+	// Version is the program version, usually from mob.toml.
+	Version string
+	// Commit is the VCS revision the program was built from.
+	Commit string
+}
+
+// stdModule is a module provided by the compiler instead of a .mob file.
+// Its functions either have results known at compile time, or are
+// written in Go by source.
+type stdModule struct {
+	doc string
+	// values maps function names to their results.
+	values func(opts Options) map[string]string
+	// functions describes the functions implemented by source, the Go
+	// package body after its package clause.
+	functions map[string]Builtin
+	source    string
+	// target, when set, is the only target the module is available on.
+	target Target
+}
+
+var stdModules = map[string]stdModule{
+	"mob.build": {
+		doc: "describes how the program was built.",
+		values: func(opts Options) map[string]string {
+			version, commit := opts.Build.Version, opts.Build.Commit
+			if version == "" {
+				version = "dev"
+			}
+			if commit == "" {
+				commit = "unknown"
+			}
+			return map[string]string{
+				"version": version,
+				"commit":  commit,
+			}
+		},
+	},
+	"js": {
+		doc: "calls into the JavaScript host of a js/wasm program.",
+		functions: map[string]Builtin{
+			"call": {Args: 1, Variadic: true, Value: true},
+			"get":  {Args: 1, Value: true},
+			"set":  {Args: 2},
+		},
+		source: `
+import "syscall/js"
+
+// Call calls the global JavaScript function name with args and returns
+// its result as a string.
+func Call(name string, args ...string) string {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	return toString(js.Global().Call(name, values...))
+}
+
+// Get returns the global JavaScript value name as a string.
+func Get(name string) string {
+	return toString(js.Global().Get(name))
+}
+
+// toString converts v as JavaScript's String does, so 42 is "42";
+// Value.String gives other types than strings Go's debug form, such as
+// <number: 42>.
+func toString(v js.Value) string {
+	return js.Global().Get("String").Invoke(v).String()
+}
+
+// Set sets the global JavaScript value name.
+func Set(name, value string) {
+	js.Global().Set(name, value)
+}
+`,
+		target: Target{OS: "js", Arch: "wasm"},
+	},
+}
+
+// loadStdModule returns the standard module at path, if there is one,
+// or an error when it is not available for opts.Target. Its AST declares
+// the functions so the resolver can check calls, and its Go code is
+// generated directly.
+func loadStdModule(path string, opts Options) (*Module, bool, error) {
+	std, ok := stdModules[path]
+	if !ok {
+		return nil, false, nil
+	}
+	if std.target != (Target{}) && opts.Target != std.target {
+		return nil, true, fmt.Errorf("module %s is only available on %s (build with --target=%s)", path, std.target, std.target)
+	}
+
+	mod := &Module{Path: path, File: "<" + path + ">", AST: Node{Type: NodeProgram}, Functions: std.functions}
+	if std.values != nil {
+		mod.Values = std.values(opts)
+		mod.Functions = make(map[string]Builtin)
+		for name := range mod.Values {
+			mod.Functions[name] = Builtin{Value: true}
+		}
+	}
+	names := make([]string, 0, len(mod.Functions))
+	for name := range mod.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Package %s %s\npackage %s\n", goPackageName(path), std.doc, goPackageName(path))
+	b.WriteString(std.source)
+	for _, name := range names {
+		mod.AST.Children = append(mod.AST.Children, Node{Type: NodeFunction, Value: name})
+		if value, ok := mod.Values[name]; ok {
+			fmt.Fprintf(&b, "\nfunc %s() string {\n\treturn %q\n}\n", exportName(name), value)
+		}
+	}
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		panic(fmt.Sprintf("invalid standard module %s: %v", path, err))
+	}
+	mod.GoCode = string(src)
+	return mod, true, nil
+}
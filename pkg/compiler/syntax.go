@@ -0,0 +1,203 @@
+package compiler
+
+import (
+	"strings"
+)
+
+type SyntaxKind int
+
+const (
+	// SyntaxToken is a leaf holding one token.
+	SyntaxToken SyntaxKind = iota
+	SyntaxFile
+	SyntaxImport
+	SyntaxFromImport
+	SyntaxFunction
+	// SyntaxBlock is the body of a function, from the newline after
+	// its ':' to the dedent that ends it.
+	SyntaxBlock
+	SyntaxReturn
+	SyntaxCall
+	SyntaxString
+	// SyntaxName is a name, dotted or not: the callee of a call, an
+	// identifier argument or a module path.
+	SyntaxName
+	// SyntaxError holds the tokens of a statement the parser could not
+	// make sense of. It has no counterpart in the AST.
+	SyntaxError
+)
+
+func (k SyntaxKind) String() string {
+	switch k {
+	case SyntaxToken:
+		return "Token"
+	case SyntaxFile:
+		return "File"
+	case SyntaxImport:
+		return "Import"
+	case SyntaxFromImport:
+		return "FromImport"
+	case SyntaxFunction:
+		return "Function"
+	case SyntaxBlock:
+		return "Block"
+	case SyntaxReturn:
+		return "Return"
+	case SyntaxCall:
+		return "Call"
+	case SyntaxString:
+		return "String"
+	case SyntaxName:
+		return "Name"
+	case SyntaxError:
+		return "Error"
+	default:
+		return "Unknown"
+	}
+}
+
+// Syntax is a node of the concrete syntax tree Parser.ParseSyntax
+// builds. Unlike the AST it keeps every token, punctuation, newlines,
+// indents and the tokens of invalid statements included, in source
+// order. When the tokens come from a lexer in trivia mode, Text gives
+// back the source byte for byte.
+type Syntax struct {
+	Kind SyntaxKind
+	// Token is the token of a SyntaxToken leaf.
+	Token    Token
+	Children []*Syntax
+}
+
+// Tokens returns the tokens below s, in order.
+func (s *Syntax) Tokens() []Token {
+	var tokens []Token
+	s.walk(func(tok Token) { tokens = append(tokens, tok) })
+	return tokens
+}
+
+// Text returns the source text of s: its tokens with their trivia.
+func (s *Syntax) Text() string {
+	var b strings.Builder
+	s.walk(func(tok Token) {
+		b.WriteString(tok.Leading)
+		b.WriteString(tok.Value)
+		b.WriteString(tok.Trailing)
+	})
+	return b.String()
+}
+
+func (s *Syntax) walk(visit func(Token)) {
+	if s.Kind == SyntaxToken {
+		visit(s.Token)
+		return
+	}
+	for _, child := range s.Children {
+		child.walk(visit)
+	}
+}
+
+// nodes returns the children of s that are nodes, not tokens.
+func (s *Syntax) nodes() []*Syntax {
+	var nodes []*Syntax
+	for _, child := range s.Children {
+		if child.Kind != SyntaxToken {
+			nodes = append(nodes, child)
+		}
+	}
+	return nodes
+}
+
+// first returns the first token below s.
+func (s *Syntax) first() Token {
+	for s.Kind != SyntaxToken {
+		s = s.Children[0]
+	}
+	return s.Token
+}
+
+// AST derives the abstract syntax tree of a file from its concrete
+// syntax tree. Invalid statements are left out.
+func (s *Syntax) AST() Node {
+	return Node{Type: NodeProgram, Children: statements(s)}
+}
+
+// statements returns the statements of a file or block.
+func statements(s *Syntax) []Node {
+	var stmts []Node
+	for _, child := range s.nodes() {
+		if child.Kind != SyntaxError {
+			stmts = append(stmts, child.node())
+		}
+	}
+	return stmts
+}
+
+// node returns the AST node of a statement or expression. Nodes are
+// positioned at their first token: the keyword of a statement or the
+// name of a call.
+func (s *Syntax) node() Node {
+	first := s.first()
+	node := Node{Line: first.Line, Column: first.Column}
+
+	switch s.Kind {
+	case SyntaxImport:
+		node.Type = NodeImport
+		node.Value = s.nodes()[0].name()
+	case SyntaxFromImport:
+		node.Type = NodeFromImport
+		node.Value = s.nodes()[0].name()
+		// The names follow the import keyword, the first identifier
+		// token after the module name.
+		keyword := false
+		for _, child := range s.Children[2:] {
+			if child.Kind != SyntaxToken || child.Token.Type != TokenIdentifier {
+				continue
+			}
+			if !keyword {
+				keyword = true
+				continue
+			}
+			node.Children = append(node.Children, Node{
+				Type:   NodeIdentifier,
+				Value:  child.Token.Value,
+				Line:   child.Token.Line,
+				Column: child.Token.Column,
+			})
+		}
+	case SyntaxFunction:
+		node.Type = NodeFunction
+		node.Value = s.Children[1].Token.Value
+		for _, child := range s.nodes() {
+			if child.Kind == SyntaxBlock {
+				node.Children = statements(child)
+			}
+		}
+	case SyntaxReturn:
+		node.Type = NodeReturn
+	case SyntaxCall:
+		node.Type = NodeCall
+		nodes := s.nodes()
+		node.Value = nodes[0].name()
+		for _, arg := range nodes[1:] {
+			node.Children = append(node.Children, arg.node())
+		}
+	case SyntaxString:
+		node.Type = NodeString
+		node.Value = strings.Trim(first.Value, `"`)
+	case SyntaxName:
+		node.Type = NodeIdentifier
+		node.Value = s.name()
+	}
+	return node
+}
+
+// name returns the dotted name a SyntaxName spells.
+func (s *Syntax) name() string {
+	var parts []string
+	for _, child := range s.Children {
+		if child.Token.Type == TokenIdentifier {
+			parts = append(parts, child.Token.Value)
+		}
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,121 @@
+package compiler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Target is the platform a binary is built for, as a GOOS/GOARCH pair.
+// The zero Target is the host.
+type Target struct {
+	OS   string
+	Arch string
+}
+
+// WASI is the target of "--target=wasm": WebAssembly for WASI runtimes
+// such as wasmtime. Browsers and Node.js use js/wasm instead.
+var WASI = Target{OS: "wasip1", Arch: "wasm"}
+
+// ParseTarget parses "os/arch", such as "linux/arm64", or "wasm" for
+// WASI.
+func ParseTarget(s string) (Target, error) {
+	if s == "wasm" {
+		return WASI, nil
+	}
+	goos, goarch, ok := strings.Cut(s, "/")
+	if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+		return Target{}, fmt.Errorf("invalid target %q: expected os/arch, such as linux/arm64", s)
+	}
+	return Target{OS: goos, Arch: goarch}, nil
+}
+
+func (t Target) String() string {
+	if t == (Target{}) {
+		return "host"
+	}
+	return t.OS + "/" + t.Arch
+}
+
+// OutputName is the binary name for the target: base-os-arch, with .exe
+// for Windows and .wasm for WebAssembly.
+func (t Target) OutputName(base string) string {
+	name := base + "-" + t.OS + "-" + t.Arch
+	switch {
+	case t.OS == "windows":
+		name += ".exe"
+	case t.Arch == "wasm":
+		name += ".wasm"
+	}
+	return name
+}
+
+// env returns the environment for go build. Only the parts of the
+// target that are set override the host.
+func (t Target) env() []string {
+	var env []string
+	if t.OS != "" {
+		env = append(env, "GOOS="+t.OS)
+	}
+	if t.Arch != "" {
+		env = append(env, "GOARCH="+t.Arch)
+	}
+	return env
+}
+
+// wasmExecName is the JavaScript shim that loads js/wasm programs. Its
+// version must match the Go release that built the program.
+const wasmExecName = "wasm_exec.js"
+
+// writeWasmExec copies the toolchain's wasm_exec.js into dir, next to
+// a js/wasm program.
+func (c *Compiler) writeWasmExec(ctx context.Context, dir string) error {
+	tc, err := c.toolchain(ctx)
+	if err != nil {
+		return err
+	}
+	goroot := tc.GOROOT
+	if goroot == "" {
+		out, err := tc.command(ctx, "env", "GOROOT").Output()
+		if err != nil {
+			return fmt.Errorf("failed to locate GOROOT for %s: %w", wasmExecName, err)
+		}
+		goroot = strings.TrimSpace(string(out))
+	}
+
+	// Go 1.24 moved the shim from misc/wasm to lib/wasm.
+	for _, sub := range []string{"lib", "misc"} {
+		data, err := os.ReadFile(filepath.Join(goroot, sub, "wasm", wasmExecName))
+		if err == nil {
+			return os.WriteFile(filepath.Join(dir, wasmExecName), data, 0644)
+		}
+	}
+	return fmt.Errorf("%s not found in %s", wasmExecName, goroot)
+}
+
+// checkTarget rejects targets the Go toolchain cannot build for, so the
+// user gets a clear message instead of a go build failure. It trusts
+// the target when the list of supported platforms is unavailable.
+func (c *Compiler) checkTarget(ctx context.Context) error {
+	t := c.Target
+	if t.OS == "" || t.Arch == "" {
+		return nil
+	}
+
+	tc, err := c.toolchain(ctx)
+	if err != nil {
+		return err
+	}
+	out, err := tc.command(ctx, "tool", "dist", "list").Output()
+	if err != nil {
+		return nil
+	}
+	for _, platform := range strings.Fields(string(out)) {
+		if platform == t.String() {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported target %s (see 'go tool dist list')", t)
+}
@@ -0,0 +1,88 @@
+source_filename = "main.mob"
+
+@.str.0 = private unnamed_addr constant [3 x i8] c"got"
+@.str.1 = private unnamed_addr constant [4 x i8] c"args"
+@mob_argc = internal global i32 0
+@mob_argv = internal global ptr null
+
+declare i32 @putchar(i32)
+declare i64 @strlen(ptr)
+
+define i32 @main(i32 %argc, ptr %argv) {
+entry:
+  store i32 %argc, ptr @mob_argc
+  store ptr %argv, ptr @mob_argv
+  call void @"<main>"()
+  ret i32 0
+}
+
+define internal void @"<main>"() {
+entry:
+  ; main.mob:1:1
+  call void @mob_write_args()
+  call i32 @putchar(i32 10)
+  ; main.mob:2:1
+  call void @mob_write(ptr @.str.0, i64 3)
+  call i32 @putchar(i32 32)
+  call void @mob_write_args()
+  call i32 @putchar(i32 32)
+  call void @mob_write(ptr @.str.1, i64 4)
+  call i32 @putchar(i32 10)
+  ; main.mob:3:1
+  ret void
+}
+
+define internal void @mob_write(ptr %s, i64 %n) {
+entry:
+  br label %loop
+
+loop:
+  %i = phi i64 [ 0, %entry ], [ %next, %body ]
+  %done = icmp eq i64 %i, %n
+  br i1 %done, label %exit, label %body
+
+body:
+  %p = getelementptr inbounds i8, ptr %s, i64 %i
+  %c = load i8, ptr %p
+  %ci = zext i8 %c to i32
+  call i32 @putchar(i32 %ci)
+  %next = add i64 %i, 1
+  br label %loop
+
+exit:
+  ret void
+}
+
+define internal void @mob_write_args() {
+entry:
+  %argc = load i32, ptr @mob_argc
+  %argv = load ptr, ptr @mob_argv
+  call i32 @putchar(i32 91)
+  br label %loop
+
+loop:
+  %i = phi i32 [ 1, %entry ], [ %next, %write ]
+  %done = icmp sge i32 %i, %argc
+  br i1 %done, label %exit, label %body
+
+body:
+  %first = icmp eq i32 %i, 1
+  br i1 %first, label %write, label %space
+
+space:
+  call i32 @putchar(i32 32)
+  br label %write
+
+write:
+  %index = sext i32 %i to i64
+  %p = getelementptr inbounds ptr, ptr %argv, i64 %index
+  %arg = load ptr, ptr %p
+  %len = call i64 @strlen(ptr %arg)
+  call void @mob_write(ptr %arg, i64 %len)
+  %next = add i32 %i, 1
+  br label %loop
+
+exit:
+  call i32 @putchar(i32 93)
+  ret void
+}
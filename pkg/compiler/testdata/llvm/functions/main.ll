@@ -0,0 +1,97 @@
+source_filename = "main.mob"
+
+@.str.0 = private unnamed_addr constant [98 x i8] c"testdata/llvm/functions/main.mob:9:1: runtime error: stack overflow: more than 10000 nested calls\0A"
+@.str.1 = private unnamed_addr constant [99 x i8] c"testdata/llvm/functions/main.mob:10:1: runtime error: stack overflow: more than 10000 nested calls\0A"
+@.str.2 = private unnamed_addr constant [2 x i8] c"hi"
+@.str.3 = private unnamed_addr constant [12 x i8] c"never called"
+@mob_depth = internal global i32 0
+@mob_max_depth = internal constant i32 10000
+
+declare i32 @putchar(i32)
+declare i32 @fflush(ptr)
+declare i64 @write(i32, ptr, i64)
+declare void @exit(i32)
+
+define i32 @main(i32 %argc, ptr %argv) {
+entry:
+  call void @"<main>"()
+  ret i32 0
+}
+
+define internal void @"<main>"() {
+entry:
+  ; main.mob:9:1
+  call void @mob_enter(ptr @.str.0, i64 98)
+  call void @"mob.greet"()
+  call void @mob_leave()
+  ; main.mob:10:1
+  call void @mob_enter(ptr @.str.1, i64 99)
+  call void @"mob.greet"()
+  call void @mob_leave()
+  ret void
+}
+
+define internal void @"mob.greet"() {
+entry:
+  ; main.mob:2:5
+  call void @mob_write(ptr @.str.2, i64 2)
+  call i32 @putchar(i32 10)
+  ; main.mob:3:5
+  ret void
+}
+
+define internal void @"mob.unused"() {
+entry:
+  ; main.mob:7:5
+  call void @mob_write(ptr @.str.3, i64 12)
+  call i32 @putchar(i32 10)
+  ret void
+}
+
+define internal void @mob_write(ptr %s, i64 %n) {
+entry:
+  br label %loop
+
+loop:
+  %i = phi i64 [ 0, %entry ], [ %next, %body ]
+  %done = icmp eq i64 %i, %n
+  br i1 %done, label %exit, label %body
+
+body:
+  %p = getelementptr inbounds i8, ptr %s, i64 %i
+  %c = load i8, ptr %p
+  %ci = zext i8 %c to i32
+  call i32 @putchar(i32 %ci)
+  %next = add i64 %i, 1
+  br label %loop
+
+exit:
+  ret void
+}
+
+define internal void @mob_enter(ptr %message, i64 %n) {
+entry:
+  %depth = load i32, ptr @mob_depth
+  %max = load i32, ptr @mob_max_depth
+  %over = icmp sge i32 %depth, %max
+  br i1 %over, label %overflow, label %enter
+
+overflow:
+  call i32 @fflush(ptr null)
+  call i64 @write(i32 2, ptr %message, i64 %n)
+  call void @exit(i32 2)
+  unreachable
+
+enter:
+  %next = add i32 %depth, 1
+  store i32 %next, ptr @mob_depth
+  ret void
+}
+
+define internal void @mob_leave() {
+entry:
+  %depth = load i32, ptr @mob_depth
+  %next = sub i32 %depth, 1
+  store i32 %next, ptr @mob_depth
+  ret void
+}
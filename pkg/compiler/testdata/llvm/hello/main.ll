@@ -0,0 +1,46 @@
+source_filename = "main.mob"
+
+@.str.0 = private unnamed_addr constant [13 x i8] c"Hello, World!"
+@.str.1 = private unnamed_addr constant [18 x i8] c"quote \5C\22 and caf\C3\A9"
+
+declare i32 @putchar(i32)
+
+define i32 @main(i32 %argc, ptr %argv) {
+entry:
+  call void @"<main>"()
+  ret i32 0
+}
+
+define internal void @"<main>"() {
+entry:
+  ; main.mob:1:1
+  call void @mob_write(ptr @.str.0, i64 13)
+  call i32 @putchar(i32 10)
+  ; main.mob:2:1
+  call void @mob_write(ptr @.str.1, i64 18)
+  call i32 @putchar(i32 10)
+  ; main.mob:3:1
+  call i32 @putchar(i32 10)
+  ret void
+}
+
+define internal void @mob_write(ptr %s, i64 %n) {
+entry:
+  br label %loop
+
+loop:
+  %i = phi i64 [ 0, %entry ], [ %next, %body ]
+  %done = icmp eq i64 %i, %n
+  br i1 %done, label %exit, label %body
+
+body:
+  %p = getelementptr inbounds i8, ptr %s, i64 %i
+  %c = load i8, ptr %p
+  %ci = zext i8 %c to i32
+  call i32 @putchar(i32 %ci)
+  %next = add i64 %i, 1
+  br label %loop
+
+exit:
+  ret void
+}
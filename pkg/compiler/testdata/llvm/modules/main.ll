@@ -0,0 +1,126 @@
+source_filename = "main.mob"
+
+@.str.0 = private unnamed_addr constant [4 x i8] c"main"
+@.str.1 = private unnamed_addr constant [5 x i8] c"1.2.3"
+@.str.2 = private unnamed_addr constant [6 x i8] c"abc123"
+@.str.3 = private unnamed_addr constant [96 x i8] c"testdata/llvm/modules/main.mob:6:1: runtime error: stack overflow: more than 10000 nested calls\0A"
+@.str.4 = private unnamed_addr constant [96 x i8] c"testdata/llvm/modules/main.mob:7:1: runtime error: stack overflow: more than 10000 nested calls\0A"
+@.str.5 = private unnamed_addr constant [9 x i8] c"zeta init"
+@.str.6 = private unnamed_addr constant [4 x i8] c"zeta"
+@.str.7 = private unnamed_addr constant [10 x i8] c"alpha init"
+@.str.8 = private unnamed_addr constant [5 x i8] c"alpha"
+@mob_depth = internal global i32 0
+@mob_max_depth = internal constant i32 10000
+
+declare i32 @putchar(i32)
+declare i32 @fflush(ptr)
+declare i64 @write(i32, ptr, i64)
+declare void @exit(i32)
+
+define i32 @main(i32 %argc, ptr %argv) {
+entry:
+  call void @"<lib.alpha>"()
+  call void @"<zeta>"()
+  call void @"<main>"()
+  ret i32 0
+}
+
+define internal void @"<main>"() {
+entry:
+  ; main.mob:5:1
+  call void @mob_write(ptr @.str.0, i64 4)
+  call i32 @putchar(i32 32)
+  call void @mob_write(ptr @.str.1, i64 5)
+  call i32 @putchar(i32 32)
+  call void @mob_write(ptr @.str.2, i64 6)
+  call i32 @putchar(i32 10)
+  ; main.mob:6:1
+  call void @mob_enter(ptr @.str.3, i64 96)
+  call void @"mob.zeta.z"()
+  call void @mob_leave()
+  ; main.mob:7:1
+  call void @mob_enter(ptr @.str.4, i64 96)
+  call void @"mob.lib.alpha.a"()
+  call void @mob_leave()
+  ret void
+}
+
+define internal void @"<zeta>"() {
+entry:
+  ; /root/module/pkg/compiler/testdata/llvm/modules/zeta.mob:1:1
+  call void @mob_write(ptr @.str.5, i64 9)
+  call i32 @putchar(i32 10)
+  ret void
+}
+
+define internal void @"mob.zeta.z"() {
+entry:
+  ; /root/module/pkg/compiler/testdata/llvm/modules/zeta.mob:4:5
+  call void @mob_write(ptr @.str.6, i64 4)
+  call i32 @putchar(i32 10)
+  ret void
+}
+
+define internal void @"<lib.alpha>"() {
+entry:
+  ; /root/module/pkg/compiler/testdata/llvm/modules/lib/alpha.mob:1:1
+  call void @mob_write(ptr @.str.7, i64 10)
+  call i32 @putchar(i32 10)
+  ret void
+}
+
+define internal void @"mob.lib.alpha.a"() {
+entry:
+  ; /root/module/pkg/compiler/testdata/llvm/modules/lib/alpha.mob:4:5
+  call void @mob_write(ptr @.str.8, i64 5)
+  call i32 @putchar(i32 10)
+  ret void
+}
+
+define internal void @mob_write(ptr %s, i64 %n) {
+entry:
+  br label %loop
+
+loop:
+  %i = phi i64 [ 0, %entry ], [ %next, %body ]
+  %done = icmp eq i64 %i, %n
+  br i1 %done, label %exit, label %body
+
+body:
+  %p = getelementptr inbounds i8, ptr %s, i64 %i
+  %c = load i8, ptr %p
+  %ci = zext i8 %c to i32
+  call i32 @putchar(i32 %ci)
+  %next = add i64 %i, 1
+  br label %loop
+
+exit:
+  ret void
+}
+
+define internal void @mob_enter(ptr %message, i64 %n) {
+entry:
+  %depth = load i32, ptr @mob_depth
+  %max = load i32, ptr @mob_max_depth
+  %over = icmp sge i32 %depth, %max
+  br i1 %over, label %overflow, label %enter
+
+overflow:
+  call i32 @fflush(ptr null)
+  call i64 @write(i32 2, ptr %message, i64 %n)
+  call void @exit(i32 2)
+  unreachable
+
+enter:
+  %next = add i32 %depth, 1
+  store i32 %next, ptr @mob_depth
+  ret void
+}
+
+define internal void @mob_leave() {
+entry:
+  %depth = load i32, ptr @mob_depth
+  %next = sub i32 %depth, 1
+  store i32 %next, ptr @mob_depth
+  ret void
+}
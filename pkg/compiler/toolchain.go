@@ -0,0 +1,179 @@
+package compiler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinGoVersion is the oldest Go toolchain that builds the generated code.
+// It matches the go directive of the generated go.mod.
+const MinGoVersion = "1.21"
+
+// ErrNoToolchain is wrapped by the errors returned when no usable Go
+// toolchain is found.
+var ErrNoToolchain = errors.New("no usable Go toolchain")
+
+// Toolchain is the Go installation used to build programs.
+type Toolchain struct {
+	// Go is the path of the go command.
+	Go string
+	// GOROOT is set when the toolchain was chosen by MOB_GOROOT or the
+	// manifest, and is passed to the go command.
+	GOROOT string
+	// Version is the Go version, such as "1.22.3".
+	Version string
+	// Source says how the toolchain was found: "MOB_GOROOT", "mob.toml"
+	// or "PATH".
+	Source string
+}
+
+// FindToolchain locates the go command: in $MOB_GOROOT, then in goroot
+// (the manifest's setting), then on PATH. It fails with an error
+// wrapping ErrNoToolchain when go is missing or older than MinGoVersion.
+func FindToolchain(ctx context.Context, goroot string) (*Toolchain, error) {
+	tc, err := locateToolchain(goroot)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, tc.Go, "env", "GOVERSION")
+	cmd.Env = tc.env()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s does not work (%v); reinstall Go or set MOB_GOROOT to another installation", ErrNoToolchain, tc.Go, err)
+	}
+	tc.Version = strings.TrimPrefix(strings.TrimSpace(string(out)), "go")
+
+	if compareGoVersions(tc.Version, MinGoVersion) < 0 {
+		return nil, fmt.Errorf("%w: Go %s at %s is too old; mob needs Go %s or newer. Upgrade from https://go.dev/dl/ or set MOB_GOROOT to a newer installation", ErrNoToolchain, tc.Version, tc.Go, MinGoVersion)
+	}
+	return tc, nil
+}
+
+// locateToolchain is FindToolchain without running go: Version is left
+// empty.
+func locateToolchain(goroot string) (*Toolchain, error) {
+	tc := &Toolchain{}
+	switch {
+	case os.Getenv("MOB_GOROOT") != "":
+		tc.GOROOT, tc.Source = os.Getenv("MOB_GOROOT"), "MOB_GOROOT"
+	case goroot != "":
+		tc.GOROOT, tc.Source = goroot, "mob.toml"
+	default:
+		tc.Source = "PATH"
+	}
+
+	if tc.GOROOT != "" {
+		tc.Go = filepath.Join(tc.GOROOT, "bin", "go")
+		if runtime.GOOS == "windows" {
+			tc.Go += ".exe"
+		}
+		if _, err := os.Stat(tc.Go); err != nil {
+			return nil, fmt.Errorf("%w: %s is set to %s, but it has no bin/go; point it at a Go installation", ErrNoToolchain, tc.Source, tc.GOROOT)
+		}
+	} else {
+		path, err := exec.LookPath("go")
+		if err != nil {
+			return nil, fmt.Errorf("%w: the go command was not found on PATH. mob needs Go %s or newer to build programs: install it from https://go.dev/dl/ or set MOB_GOROOT to a Go installation", ErrNoToolchain, MinGoVersion)
+		}
+		tc.Go = path
+	}
+	return tc, nil
+}
+
+// env is the environment for go commands. GOTOOLCHAIN=local keeps go
+// from downloading another toolchain.
+func (tc *Toolchain) env() []string {
+	env := append(os.Environ(), "GOTOOLCHAIN=local")
+	if tc.GOROOT != "" {
+		env = append(env, "GOROOT="+tc.GOROOT)
+	}
+	return env
+}
+
+// command returns a go command run with the toolchain's environment.
+func (tc *Toolchain) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, tc.Go, args...)
+	cmd.Env = tc.env()
+	return cmd
+}
+
+// compareGoVersions compares Go versions such as "1.21", "1.22.3" and
+// "1.23rc1" by their numeric parts. Prerelease suffixes are ignored.
+// Development toolchains, "devel go1.24-abcdef ...", compare as the
+// release they name, and as newer than any when they name none.
+func compareGoVersions(a, b string) int {
+	pa, pb := goVersionParts(a), goVersionParts(b)
+	for i := 0; i < 3; i++ {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func goVersionParts(v string) [3]int {
+	var parts [3]int
+	if rest, ok := strings.CutPrefix(v, "devel"); ok {
+		i := strings.Index(rest, "go")
+		if i < 0 {
+			return [3]int{math.MaxInt, 0, 0}
+		}
+		v = rest[i+len("go"):]
+	}
+	// Trailing text such as "rc1" or " X:nocoverageredesign" is dropped.
+	if i := strings.IndexFunc(v, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); i >= 0 {
+		v = v[:i]
+	}
+	for i, s := range strings.SplitN(v, ".", 3) {
+		parts[i], _ = strconv.Atoi(s)
+	}
+	return parts
+}
+
+// toolchainKey identifies the compiler's Go toolchain without running
+// it, by the resolved path and modification time of the go command, so
+// cached programs start without waiting for go. Installing another Go
+// release replaces the command and changes the key.
+func (c *Compiler) toolchainKey() (string, error) {
+	tc, err := locateToolchain(c.GoRoot)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(tc.Go)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return path + " " + info.ModTime().UTC().Format(time.RFC3339Nano), nil
+}
+
+// toolchain returns the compiler's Go toolchain, locating it on first use.
+func (c *Compiler) toolchain(ctx context.Context) (*Toolchain, error) {
+	c.toolchainMu.Lock()
+	defer c.toolchainMu.Unlock()
+
+	if c.goToolchain == nil {
+		tc, err := FindToolchain(ctx, c.GoRoot)
+		if err != nil {
+			return nil, err
+		}
+		c.goToolchain = tc
+	}
+	return c.goToolchain, nil
+}
@@ -0,0 +1,424 @@
+// Package format prints .mob files in their canonical layout, the one
+// mob fmt writes. Formatting keeps comments and never changes what a
+// file means: the output parses to the same tree as the input.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+const (
+	// Width is the line width long calls are wrapped to.
+	Width = 80
+	// indent is one level of indentation.
+	indent = "    "
+)
+
+// Source returns src, the content of the file called name, formatted.
+// Files that do not parse are not formatted; the error is their
+// compiler.Diagnostics.
+func Source(name string, src []byte) ([]byte, error) {
+	f, err := parse(name, src)
+	if err != nil {
+		return nil, err
+	}
+	p := &printer{file: f}
+	p.program()
+	out := p.out.Bytes()
+
+	// A bug in the printer must not cost anyone their code: check the
+	// output means the same as the input before handing it out.
+	g, err := parse(name, out)
+	if err != nil || !sameTree(f.ast, g.ast) || !sameComments(f.comments, g.comments) {
+		return nil, compiler.Diagnostics{{
+			File:     name,
+			Code:     compiler.CodeInternal,
+			Severity: compiler.SeverityError,
+			Message:  "formatting would change the meaning of the file",
+			Internal: true,
+		}}
+	}
+	return out, nil
+}
+
+// file is a parsed source file.
+type file struct {
+	src      []byte
+	tokens   []compiler.Token
+	comments []compiler.Token
+	ast      compiler.Node
+	// at indexes the tokens that have text by position.
+	at map[position]int
+	// lines holds the offset where each line starts, from 1.
+	lines []int
+}
+
+type position struct{ line, column int }
+
+func parse(name string, src []byte) (*file, error) {
+	lexer := compiler.NewLexer(string(src))
+	f := &file{src: src, tokens: lexer.Tokenize(), at: make(map[position]int)}
+	f.comments = lexer.Comments()
+	f.lines = []int{0, 0}
+	for i, c := range src {
+		if c == '\n' {
+			f.lines = append(f.lines, i+1)
+		}
+	}
+
+	// The lexer skips characters it does not know, which printing
+	// from the tokens would delete. Refuse files that have any.
+	covered := make([]bool, len(src))
+	mark := func(tok compiler.Token) {
+		start := f.lines[tok.Line] + tok.Column - 1
+		for i := start; i < start+len(tok.Value) && i < len(src); i++ {
+			covered[i] = true
+		}
+	}
+	for i, tok := range f.tokens {
+		switch tok.Type {
+		case compiler.TokenNewline, compiler.TokenIndent, compiler.TokenDedent, compiler.TokenEOF:
+			continue
+		}
+		f.at[position{tok.Line, tok.Column}] = i
+		mark(tok)
+	}
+	for _, c := range f.comments {
+		mark(c)
+	}
+	var diags compiler.Diagnostics
+	for i, c := range src {
+		if covered[i] || unicode.IsSpace(rune(c)) {
+			continue
+		}
+		line := len(f.lines) - 1
+		for f.lines[line] > i {
+			line--
+		}
+		column := i - f.lines[line] + 1
+		if len(diags) == 0 || diags[len(diags)-1].Line != line {
+			diags = append(diags, compiler.Diagnostic{
+				File:      name,
+				Line:      line,
+				Column:    column,
+				EndLine:   line,
+				EndColumn: column + 1,
+				Code:      compiler.CodeSyntax,
+				Severity:  compiler.SeverityError,
+				Message:   fmt.Sprintf("unexpected character %q", c),
+			})
+		}
+	}
+	if len(diags) > 0 {
+		return nil, diags
+	}
+
+	parser := compiler.NewParser(f.tokens)
+	f.ast = parser.Parse()
+	if errs := parser.Errors(); len(errs) > 0 {
+		for i := range errs {
+			errs[i].File = name
+		}
+		return nil, errs
+	}
+	return f, nil
+}
+
+// blank reports whether a line between after and before, exclusive, is
+// blank.
+func (f *file) blank(after, before int) bool {
+	for line := after + 1; line < before && line+1 < len(f.lines); line++ {
+		if len(bytes.TrimSpace(f.src[f.lines[line]:f.lines[line+1]])) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// token returns the index of the token node is positioned at.
+func (f *file) token(node compiler.Node) int {
+	return f.at[position{node.Line, node.Column}]
+}
+
+// nameEnd returns the index of the last token of the dotted name at
+// token i.
+func (f *file) nameEnd(i int) int {
+	for i+2 < len(f.tokens) && f.tokens[i+1].Type == compiler.TokenDot && f.tokens[i+2].Type == compiler.TokenIdentifier {
+		i += 2
+	}
+	return i
+}
+
+// last returns the index of the last token of an expression or of a
+// call statement.
+func (f *file) last(node compiler.Node) int {
+	i := f.token(node)
+	if node.Type == compiler.NodeString {
+		return i
+	}
+	i = f.nameEnd(i)
+	if node.Type != compiler.NodeCall || f.tokens[i+1].Type != compiler.TokenLeftParen {
+		return i
+	}
+	depth := 0
+	for i++; i < len(f.tokens); i++ {
+		switch f.tokens[i].Type {
+		case compiler.TokenLeftParen:
+			depth++
+		case compiler.TokenRightParen:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(f.tokens) - 1
+}
+
+// end returns the line a statement ends on.
+func (f *file) end(stmt compiler.Node) int {
+	switch stmt.Type {
+	case compiler.NodeFunction:
+		for i := f.token(stmt); i < len(f.tokens); i++ {
+			if f.tokens[i].Type == compiler.TokenColon {
+				return f.tokens[i].Line
+			}
+		}
+	case compiler.NodeCall, compiler.NodeString, compiler.NodeIdentifier:
+		return f.tokens[f.last(stmt)].Line
+	}
+	return stmt.Line
+}
+
+// printer prints a file in the canonical layout.
+type printer struct {
+	*file
+	out bytes.Buffer
+	// next is the index of the next comment to print.
+	next int
+	// line is the source line the last statement or comment printed
+	// ends on.
+	line int
+	// open is set at the start of a block, where no blank line goes.
+	open bool
+}
+
+func (p *printer) program() {
+	stmts := p.ast.Children
+	for i, stmt := range stmts {
+		// Functions are set apart by blank lines, and so are the
+		// imports from the code after them.
+		separate := false
+		if i > 0 {
+			prev := stmts[i-1]
+			separate = prev.Type == compiler.NodeFunction || stmt.Type == compiler.NodeFunction ||
+				(isImport(prev) && !isImport(stmt))
+		}
+		p.statement(stmt, 0, separate)
+		if stmt.Type != compiler.NodeFunction {
+			continue
+		}
+
+		limit := math.MaxInt
+		if i+1 < len(stmts) {
+			limit = stmts[i+1].Line
+		}
+		p.open = true
+		for _, body := range stmt.Children {
+			p.statement(body, 1, false)
+		}
+		// Indented comments after the last statement stay in the body.
+		for p.next < len(p.comments) && p.comments[p.next].Line < limit && p.comments[p.next].Column > 1 {
+			p.comment(1, false)
+		}
+		p.open = false
+	}
+	for p.next < len(p.comments) {
+		p.comment(0, false)
+	}
+}
+
+func isImport(stmt compiler.Node) bool {
+	return stmt.Type == compiler.NodeImport || stmt.Type == compiler.NodeFromImport
+}
+
+// separate writes a blank line before something starting on line when
+// the source has one there, or when forced, except at the start of the
+// file or of a block.
+func (p *printer) separate(line int, forced bool) {
+	if p.out.Len() > 0 && !p.open && (forced || p.blank(p.line, line)) {
+		p.out.WriteByte('\n')
+	}
+	p.open = false
+}
+
+// comment prints the next comment on a line of its own.
+func (p *printer) comment(depth int, forced bool) {
+	c := p.comments[p.next]
+	p.next++
+	p.separate(c.Line, forced)
+	p.out.WriteString(strings.Repeat(indent, depth) + normalize(c.Value) + "\n")
+	p.line = c.Line
+}
+
+// statement prints stmt at depth with the comments above and after it.
+// A forced blank line goes above the comments.
+func (p *printer) statement(stmt compiler.Node, depth int, forced bool) {
+	for p.next < len(p.comments) && p.comments[p.next].Line < stmt.Line {
+		p.comment(depth, forced)
+		forced = false
+	}
+	p.separate(stmt.Line, forced)
+
+	prefix := strings.Repeat(indent, depth)
+	var lines []string
+	switch stmt.Type {
+	case compiler.NodeImport:
+		lines = []string{prefix + "import " + stmt.Value}
+	case compiler.NodeFromImport:
+		names := make([]string, len(stmt.Children))
+		for i, name := range stmt.Children {
+			names[i] = name.Value
+		}
+		lines = []string{prefix + "from " + stmt.Value + " import " + strings.Join(names, ", ")}
+	case compiler.NodeFunction:
+		lines = []string{prefix + "function " + stmt.Value + "():"}
+	case compiler.NodeReturn:
+		lines = []string{prefix + "return"}
+	default:
+		lines = p.expression(stmt, depth, 0)
+	}
+
+	end := p.end(stmt)
+	if p.next < len(p.comments) && p.comments[p.next].Line == end {
+		lines[len(lines)-1] += "  " + normalize(p.comments[p.next].Value)
+		p.next++
+	}
+	for _, line := range lines {
+		p.out.WriteString(line + "\n")
+	}
+	p.line = end
+}
+
+// expression returns the lines of node printed at depth, indentation
+// included. extra is the width of what follows it on its last line.
+func (p *printer) expression(node compiler.Node, depth, extra int) []string {
+	prefix := strings.Repeat(indent, depth)
+	if node.Type != compiler.NodeCall {
+		return []string{prefix + p.flat(node)}
+	}
+
+	// Calls that do not fit, or have comments inside, get one argument
+	// per line.
+	closeLine := p.tokens[p.last(node)].Line
+	flat := p.flat(node)
+	if !p.commentBefore(closeLine) && (len(node.Children) == 0 || len(prefix)+len(flat)+extra <= Width) {
+		return []string{prefix + flat}
+	}
+
+	lines := []string{prefix + node.Value + "("}
+	for _, arg := range node.Children {
+		for p.commentBefore(arg.Line) {
+			lines = append(lines, p.ownLine(depth+1))
+		}
+		last := p.last(arg)
+		arg, end := p.expression(arg, depth+1, len(",")), p.tokens[last].Line
+		arg[len(arg)-1] += ","
+		// A comment on the line the argument ends on is its own only
+		// when nothing else of the call follows it there; otherwise it
+		// goes after what does, the closing parenthesis at the latest.
+		if p.commentBefore(end+1) && p.tokens[p.after(last)].Line > end {
+			arg[len(arg)-1] += "  " + normalize(p.comments[p.next].Value)
+			p.next++
+		}
+		lines = append(lines, arg...)
+	}
+	for p.commentBefore(closeLine) {
+		lines = append(lines, p.ownLine(depth+1))
+	}
+	return append(lines, prefix+")")
+}
+
+// after returns the index of the token following the argument ending at
+// token i, past its comma.
+func (p *printer) after(i int) int {
+	if i+1 < len(p.tokens) && p.tokens[i+1].Type == compiler.TokenComma {
+		i++
+	}
+	return min(i+1, len(p.tokens)-1)
+}
+
+// ownLine consumes the next comment, returning it as a line at depth.
+func (p *printer) ownLine(depth int) string {
+	c := p.comments[p.next]
+	p.next++
+	return strings.Repeat(indent, depth) + normalize(c.Value)
+}
+
+// commentBefore reports whether the next comment starts before line.
+func (p *printer) commentBefore(line int) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Line < line
+}
+
+// flat returns node printed on one line.
+func (p *printer) flat(node compiler.Node) string {
+	switch node.Type {
+	case compiler.NodeString:
+		// The token keeps the string as written, escapes included.
+		return p.tokens[p.token(node)].Value
+	case compiler.NodeCall:
+		args := make([]string, len(node.Children))
+		for i, arg := range node.Children {
+			args[i] = p.flat(arg)
+		}
+		return node.Value + "(" + strings.Join(args, ", ") + ")"
+	default:
+		return node.Value
+	}
+}
+
+// normalize puts a space after the # of a comment, except in #! lines
+// and ## banners, and drops trailing spaces.
+func normalize(comment string) string {
+	comment = strings.TrimRight(comment, " \t")
+	if len(comment) > 1 && !strings.ContainsRune(" \t!#", rune(comment[1])) {
+		comment = "# " + comment[1:]
+	}
+	return comment
+}
+
+// sameTree reports whether a and b are the same tree, positions aside.
+func sameTree(a, b compiler.Node) bool {
+	return reflect.DeepEqual(strip(a), strip(b))
+}
+
+func strip(node compiler.Node) compiler.Node {
+	node.Line, node.Column = 0, 0
+	children := node.Children
+	node.Children = nil
+	for _, child := range children {
+		node.Children = append(node.Children, strip(child))
+	}
+	return node
+}
+
+// sameComments reports whether formatted has the comments of original,
+// normalized, in the same order.
+func sameComments(original, formatted []compiler.Token) bool {
+	if len(original) != len(formatted) {
+		return false
+	}
+	for i := range original {
+		if normalize(original[i].Value) != formatted[i].Value {
+			return false
+		}
+	}
+	return true
+}
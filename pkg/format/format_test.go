@@ -0,0 +1,158 @@
+package format
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "spacing",
+			src:  "print(  \"a\" ,\"b\"  )\nprint( lib . strings.upper( \"x\" ) )\n",
+			want: "print(\"a\", \"b\")\nprint(lib.strings.upper(\"x\"))\n",
+		},
+		{
+			name: "indentation",
+			src:  "function greet( ) :\n  print(\"hi\")\n  return\n\ngreet\n",
+			want: "function greet():\n    print(\"hi\")\n    return\n\ngreet()\n",
+		},
+		{
+			name: "blank lines",
+			src:  "\n\nimport utils\nfrom lib import a,b\nutils.run()\n\n\n\na()\nfunction f():\n\n    print(\"x\")\n\n\n    print(\"y\")\nf()\n\n\n",
+			want: "import utils\nfrom lib import a, b\n\nutils.run()\n\na()\n\nfunction f():\n    print(\"x\")\n\n    print(\"y\")\n\nf()\n",
+		},
+		{
+			name: "comments",
+			src:  "#!/usr/bin/env mob\nimport utils\n#about f\nfunction f():\n        #first\n        print(\"# not a comment\")   #trailing   \n        ## banner\n# top level\nf()\n",
+			want: "#!/usr/bin/env mob\nimport utils\n\n# about f\nfunction f():\n    # first\n    print(\"# not a comment\")  # trailing\n    ## banner\n\n# top level\nf()\n",
+		},
+		{
+			name: "long call",
+			src:  "print(\"a long string that fills most of the line\", \"and another one\", upper(\"x\"))\n",
+			want: "print(\n    \"a long string that fills most of the line\",\n    \"and another one\",\n    upper(\"x\"),\n)\n",
+		},
+		{
+			name: "long nested call",
+			src:  "print(upper(\"a long string that fills most of the line\", \"and another one\", \"and more\"))\n",
+			want: "print(\n    upper(\n        \"a long string that fills most of the line\",\n        \"and another one\",\n        \"and more\",\n    ),\n)\n",
+		},
+		{
+			name: "short call joined",
+			src:  "print(\n    \"a\",\n    \"b\",\n)\n",
+			want: "print(\"a\", \"b\")\n",
+		},
+		{
+			name: "comments in a call",
+			src:  "print(  # why\n    \"a\",   # first\n    # before b\n    \"b\"\n    # last\n)  # done\n",
+			want: "print(\n    # why\n    \"a\",  # first\n    # before b\n    \"b\",\n    # last\n)  # done\n",
+		},
+		{
+			name: "trailing comment on a wrapped call",
+			src:  "print(\"a\", \"a long string that fills most of the line and then quite a bit more\")   #c\n",
+			want: "print(\n    \"a\",\n    \"a long string that fills most of the line and then quite a bit more\",\n)  # c\n",
+		},
+		{
+			name: "comment after arguments sharing a line",
+			src:  "print(\"a\", \"b\",  # after b\n    \"a long string that fills most of the line and then quite a bit more\")\n",
+			want: "print(\n    \"a\",\n    \"b\",  # after b\n    \"a long string that fills most of the line and then quite a bit more\",\n)\n",
+		},
+		{
+			name: "escapes",
+			src:  "print(\"say \\\"hi\\\"\\n\")\n",
+			want: "print(\"say \\\"hi\\\"\\n\")\n",
+		},
+		{
+			name: "empty",
+			src:  "\n\n",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Source("main.mob", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			again, err := Source("main.mob", got)
+			if err != nil || string(again) != string(got) {
+				t.Errorf("formatting the output again changed it:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"print(\"a\"\nprint(\"b\")\n", "main.mob:1:6: error: '(' is never closed"},
+		{"x = 1\n", "main.mob:1:3: error: unexpected character '='"},
+	}
+	for _, tt := range tests {
+		_, err := Source("main.mob", []byte(tt.src))
+		var diags compiler.Diagnostics
+		if !errors.As(err, &diags) {
+			t.Fatalf("%q: expected diagnostics, got %v", tt.src, err)
+		}
+		if diags[0].String() != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.src, tt.want, diags[0])
+		}
+	}
+}
+
+// TestExamples formats every example: the ones that parse must already
+// be formatted, and formatting must be idempotent.
+func TestExamples(t *testing.T) {
+	var files []string
+	err := filepath.Walk(filepath.Join("..", "..", "examples"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".mob") {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no examples found")
+	}
+
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := Source(file, src)
+		if err != nil {
+			// Examples of syntax the language does not have yet are
+			// refused, not mangled.
+			var diags compiler.Diagnostics
+			if !errors.As(err, &diags) || diags[0].Internal {
+				t.Errorf("%s: %v", file, err)
+			}
+			continue
+		}
+		if string(once) != string(src) {
+			t.Errorf("%s is not formatted:\n%s", file, once)
+		}
+		twice, err := Source(file, once)
+		if err != nil || string(twice) != string(once) {
+			t.Errorf("%s: formatting is not idempotent: %v\n%s", file, err, twice)
+		}
+	}
+}
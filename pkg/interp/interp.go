@@ -0,0 +1,138 @@
+// Package interp runs Mob programs by walking their checked AST. It needs
+// no Go toolchain and behaves like the code the compiler generates: both
+// share the builtins of compiler.Builtins and the module order of
+// compiler.InitOrder, and the tests run every example through both.
+package interp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+// scope is one module while it runs: its file and its functions.
+type scope struct {
+	file      string
+	functions map[string]compiler.Node
+	values    map[string]string
+}
+
+type interpreter struct {
+	ctx    context.Context
+	rt     *compiler.Runtime
+	scopes map[string]*scope
+	depth  int
+}
+
+// Run runs the program in res, which must have been compiled without
+// errors: the top-level statements of the modules it uses, in
+// compiler.InitOrder, then those of the entry file. It stops early when
+// ctx is done.
+func Run(ctx context.Context, res *compiler.Result, rt *compiler.Runtime) error {
+	in := &interpreter{ctx: ctx, rt: rt, scopes: make(map[string]*scope)}
+	for _, mod := range res.Modules {
+		in.scopes[mod.Path] = newScope(mod.File, mod.AST, mod.Values)
+	}
+	entry := newScope(res.File, res.AST, nil)
+
+	for _, mod := range compiler.InitOrder(res) {
+		if _, err := in.exec(in.scopes[mod.Path], mod.AST.Children); err != nil {
+			return err
+		}
+	}
+	_, err := in.exec(entry, res.AST.Children)
+	return err
+}
+
+func newScope(file string, program compiler.Node, values map[string]string) *scope {
+	s := &scope{file: file, functions: make(map[string]compiler.Node), values: values}
+	for _, stmt := range program.Children {
+		if stmt.Type == compiler.NodeFunction {
+			if _, ok := s.functions[stmt.Value]; !ok {
+				s.functions[stmt.Value] = stmt
+			}
+		}
+	}
+	return s
+}
+
+// exec runs stmts and reports whether they returned. Like codegen, it
+// skips statements that have no runtime effect, such as imports and
+// function definitions.
+func (in *interpreter) exec(s *scope, stmts []compiler.Node) (bool, error) {
+	for _, stmt := range stmts {
+		switch stmt.Type {
+		case compiler.NodeCall:
+			if _, err := in.call(s, stmt); err != nil {
+				return false, err
+			}
+		case compiler.NodeReturn:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// call evaluates a resolved call: to a module function, a function of
+// s, or a builtin.
+func (in *interpreter) call(s *scope, call compiler.Node) (any, error) {
+	if err := in.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if i := strings.LastIndex(call.Value, "."); i >= 0 {
+		target, ok := in.scopes[call.Value[:i]]
+		if !ok {
+			return nil, in.errorf(s, call, "module %s is not loaded", call.Value[:i])
+		}
+		return in.callIn(s, target, call, call.Value[i+1:])
+	}
+	if _, ok := s.functions[call.Value]; ok {
+		return in.callIn(s, s, call, call.Value)
+	}
+
+	builtin, ok := compiler.Builtins[call.Value]
+	if !ok {
+		return nil, in.errorf(s, call, "undefined function: %s", call.Value)
+	}
+	var args []any
+	for _, arg := range call.Children {
+		switch arg.Type {
+		case compiler.NodeString:
+			args = append(args, arg.Value)
+		case compiler.NodeCall:
+			v, err := in.call(s, arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+	}
+	return builtin.Call(in.rt, args)
+}
+
+// callIn runs the function name of target, called from s.
+func (in *interpreter) callIn(s, target *scope, call compiler.Node, name string) (any, error) {
+	if v, ok := target.values[name]; ok {
+		return v, nil
+	}
+	fn, ok := target.functions[name]
+	if !ok {
+		return nil, in.errorf(s, call, "undefined function: %s", call.Value)
+	}
+
+	if in.depth >= compiler.MaxDepth {
+		return nil, in.errorf(s, call, "stack overflow: more than %d nested calls", compiler.MaxDepth)
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+
+	_, err := in.exec(target, fn.Children)
+	return nil, err
+}
+
+func (in *interpreter) errorf(s *scope, node compiler.Node, format string, args ...any) error {
+	return &compiler.RuntimeError{File: s.file, Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,398 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+var programArgs = []string{"one", "two words"}
+
+// interpret runs file with the interpreter and returns its output.
+func interpret(t *testing.T, file string) (string, error) {
+	t.Helper()
+	comp := compiler.NewCompiler()
+	comp.Build = compiler.BuildInfo{Version: "1.2.3", Commit: "abc123"}
+	res, err := comp.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Diagnostics.HasErrors() {
+		return "", res.Diagnostics
+	}
+
+	var stdout bytes.Buffer
+	err = Run(context.Background(), res, &compiler.Runtime{Stdout: &stdout, Args: programArgs})
+	return stdout.String(), err
+}
+
+// compileAndRun runs file as a compiled binary and returns its output.
+func compileAndRun(t *testing.T, file string) (string, error) {
+	t.Helper()
+	comp := compiler.NewCompiler()
+	comp.Build = compiler.BuildInfo{Version: "1.2.3", Commit: "abc123"}
+	var stdout bytes.Buffer
+	comp.Stdout = &stdout
+	comp.Stderr = &bytes.Buffer{}
+	err := comp.CompileAndRun(file, programArgs...)
+	return stdout.String(), err
+}
+
+func requireGo(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+}
+
+// differential runs file through both backends and checks that they
+// agree: same output, or the same diagnostics when the file is invalid.
+// Go stops runaway recursion only at its 1 GB stack limit, so programs
+// that fail at run time are left to the other backends.
+func differential(t *testing.T, file string) {
+	t.Helper()
+	var runtimeErr *compiler.RuntimeError
+	if _, err := interpret(t, file); errors.As(err, &runtimeErr) {
+		return
+	}
+	want, compileErr := compileAndRun(t, file)
+	got, interpErr := interpret(t, file)
+
+	var diags compiler.Diagnostics
+	if errors.As(compileErr, &diags) {
+		if interpErr == nil || interpErr.Error() != compileErr.Error() {
+			t.Fatalf("compiler rejected %s with:\n%v\ninterpreter: %v", file, compileErr, interpErr)
+		}
+		return
+	}
+	if compileErr != nil {
+		t.Fatalf("compiled program failed: %v", compileErr)
+	}
+	if interpErr != nil {
+		t.Fatalf("interpreter failed: %v", interpErr)
+	}
+	if got != want {
+		t.Errorf("output differs\ncompiled:\n%s\ninterpreted:\n%s", want, got)
+	}
+}
+
+// examples returns every .mob file below examples/.
+func examples(t *testing.T) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(filepath.Join("..", "..", "examples"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".mob") {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no examples found")
+	}
+	return files
+}
+
+// forEachExample runs check on every example in a parallel subtest.
+func forEachExample(t *testing.T, check func(t *testing.T, file string)) {
+	for _, file := range examples(t) {
+		file := file
+		name, _ := filepath.Rel(filepath.Join("..", "..", "examples"), file)
+		t.Run(filepath.ToSlash(name), func(t *testing.T) {
+			t.Parallel()
+			check(t, file)
+		})
+	}
+}
+
+// programs are the cases the examples do not cover, keyed by name.
+var programs = map[string]map[string]string{
+	"args": {
+		"main.mob": "print(args())\nprint(\"got\", args(), \"args\")\n",
+	},
+	"functions": {
+		"main.mob": "function greet():\n    print(\"hi\")\n    return\n    print(\"unreachable\")\n\ngreet()\ngreet()\n",
+	},
+	"init order": {
+		"main.mob":   "import zeta\nimport alpha\n\nprint(\"main\")\nzeta.z()\nalpha.a()\n",
+		"zeta.mob":   "import mid\n\nprint(\"zeta init\")\n\nfunction z():\n    mid.m()\n",
+		"mid.mob":    "print(\"mid init\")\n\nfunction m():\n    print(\"mid\")\n",
+		"alpha.mob":  "print(\"alpha init\")\n\nfunction a():\n    print(\"alpha\")\n",
+		"unused.mob": "print(\"never runs\")\n\nfunction u():\n    return\n",
+	},
+	"unused import": {
+		"main.mob":   "import unused\n\nprint(\"main\")\n",
+		"unused.mob": "print(\"never runs\")\n\nfunction u():\n    return\n",
+	},
+	"mob.build": {
+		"main.mob": "from mob.build import version, commit\n\nprint(\"version\", version(), commit())\n",
+	},
+	"escapes": {
+		"main.mob": "print(\"tab\there\", \"??=\", \"caf\u00e9\", \"\\\\n\")\n",
+	},
+	"stack overflow": {
+		"main.mob": "print(\"before\")\n\nfunction f():\n    f()\n\nf()\n",
+	},
+	"name clashes": {
+		"main.mob": "import a.b\nimport a_b\nimport type\n\na.b.foo()\na.b.Foo()\na_b.foo()\ntype.x_y()\ntype.X_y()\n",
+		"a/b.mob":  "function foo():\n    print(\"a.b foo\")\n\nfunction Foo():\n    print(\"a.b Foo\")\n",
+		"a_b.mob":  "function foo():\n    print(\"a_b foo\")\n",
+		"type.mob": "function x_y():\n    print(\"x_y\")\n\nfunction X_y():\n    print(\"X_y\")\n",
+	},
+	"invalid": {
+		"main.mob": "function greet():\n    print(\"hi\")\n\nprint(greet())\nmissing()\n",
+	},
+}
+
+// forEachProgram writes every program to a temp dir and runs check on
+// its main.mob in a parallel subtest.
+func forEachProgram(t *testing.T, check func(t *testing.T, file string)) {
+	for name, files := range programs {
+		files := files
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			for file, src := range files {
+				path := filepath.Join(dir, file)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			check(t, filepath.Join(dir, "main.mob"))
+		})
+	}
+}
+
+func TestExamplesDifferential(t *testing.T) {
+	requireGo(t)
+	forEachExample(t, differential)
+}
+
+func TestProgramsDifferential(t *testing.T) {
+	requireGo(t)
+	forEachProgram(t, differential)
+}
+
+// buildCAndRun builds file with the C backend and returns the output of
+// the binary and what it wrote to stderr.
+func buildCAndRun(t *testing.T, file string) (string, string, error) {
+	t.Helper()
+	comp := compiler.NewCompiler()
+	comp.Build = compiler.BuildInfo{Version: "1.2.3", Commit: "abc123"}
+	comp.Backend = compiler.BackendC
+	binary := filepath.Join(t.TempDir(), "main")
+	if err := comp.Compile(file, binary); err != nil {
+		return "", "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, programArgs...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// differentialC checks the C backend against the interpreter, which the
+// other differential tests check against Go.
+func differentialC(t *testing.T, file string) {
+	t.Helper()
+	want, interpErr := interpret(t, file)
+	got, stderr, compileErr := buildCAndRun(t, file)
+
+	var diags compiler.Diagnostics
+	if errors.As(interpErr, &diags) {
+		if compileErr == nil || compileErr.Error() != interpErr.Error() {
+			t.Fatalf("interpreter rejected %s with:\n%v\nC backend: %v", file, interpErr, compileErr)
+		}
+		return
+	}
+	// Runtime errors exit with status 2 and the interpreter's message,
+	// as they do with mob run.
+	var runtimeErr *compiler.RuntimeError
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(interpErr, &runtimeErr):
+		if !errors.As(compileErr, &exitErr) || exitErr.ExitCode() != 2 || stderr != interpErr.Error()+"\n" {
+			t.Fatalf("interpreter failed with:\n%v\nC backend: %v\n%s", interpErr, compileErr, stderr)
+		}
+	case interpErr != nil:
+		t.Fatalf("interpreter failed: %v", interpErr)
+	case compileErr != nil:
+		t.Fatalf("C backend failed: %v", compileErr)
+	}
+	if got != want {
+		t.Errorf("output differs\ninterpreted:\n%s\nC:\n%s", want, got)
+	}
+}
+
+func requireCC(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not available")
+	}
+}
+
+// runLLVM returns a command running the IR in file: a binary built by
+// clang, or lli, which needs -opaque-pointers before LLVM 15.
+func runLLVM(t *testing.T, file string) *exec.Cmd {
+	t.Helper()
+	if clang, err := exec.LookPath("clang"); err == nil {
+		binary := strings.TrimSuffix(file, ".ll")
+		if out, err := exec.Command(clang, "-o", binary, file).CombinedOutput(); err != nil {
+			t.Fatalf("clang failed: %v\n%s", err, out)
+		}
+		return exec.Command(binary, programArgs...)
+	}
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("neither clang nor lli available")
+	}
+	var args []string
+	out, _ := exec.Command(lli, "--version").Output()
+	if m := regexp.MustCompile(`LLVM version (\d+)`).FindSubmatch(out); m != nil {
+		if major, _ := strconv.Atoi(string(m[1])); major < 15 {
+			args = append(args, "-opaque-pointers")
+		}
+	}
+	return exec.Command(lli, append(append(args, file), programArgs...)...)
+}
+
+// differentialLLVM checks the program emitted as LLVM IR against the
+// interpreter.
+func differentialLLVM(t *testing.T, file string) {
+	t.Helper()
+	want, interpErr := interpret(t, file)
+	var runtimeErr *compiler.RuntimeError
+	if interpErr != nil && !errors.As(interpErr, &runtimeErr) {
+		// Invalid programs emit no IR; the other tests cover them.
+		return
+	}
+
+	comp := compiler.NewCompiler()
+	comp.Build = compiler.BuildInfo{Version: "1.2.3", Commit: "abc123"}
+	comp.Emit = compiler.EmitLLVM
+	var ir bytes.Buffer
+	comp.Stdout = &ir
+	if err := comp.Compile(file, ""); err != nil {
+		t.Fatalf("emitting LLVM IR failed: %v", err)
+	}
+	ll := filepath.Join(t.TempDir(), "main.ll")
+	if err := os.WriteFile(ll, ir.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := runLLVM(t, ll)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// Runtime errors exit with status 2 and the interpreter's message,
+	// as they do in the C backend.
+	var exitErr *exec.ExitError
+	switch {
+	case runtimeErr != nil:
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 || stderr.String() != interpErr.Error()+"\n" {
+			t.Fatalf("interpreter failed with:\n%v\nLLVM: %v\n%s", interpErr, err, stderr.String())
+		}
+	case err != nil:
+		t.Fatalf("running the IR failed: %v\n%s", err, stderr.String())
+	}
+	if got := stdout.String(); got != want {
+		t.Errorf("output differs\ninterpreted:\n%s\nLLVM:\n%s", want, got)
+	}
+}
+
+func TestExamplesLLVM(t *testing.T) {
+	forEachExample(t, differentialLLVM)
+}
+
+func TestProgramsLLVM(t *testing.T) {
+	forEachProgram(t, differentialLLVM)
+}
+
+func TestExamplesC(t *testing.T) {
+	requireCC(t)
+	forEachExample(t, differentialC)
+}
+
+func TestProgramsC(t *testing.T) {
+	requireCC(t)
+	forEachProgram(t, differentialC)
+}
+
+func TestInitOrder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.mob":  "import zeta\nimport alpha\n\nzeta.z()\nalpha.a()\n",
+		"zeta.mob":  "import mid\n\nprint(\"zeta\")\n\nfunction z():\n    mid.m()\n",
+		"mid.mob":   "print(\"mid\")\n\nfunction m():\n    return\n",
+		"alpha.mob": "print(\"alpha\")\n\nfunction a():\n    return\n",
+	}
+	for file, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := interpret(t, filepath.Join(dir, "main.mob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "alpha\nmid\nzeta\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.mob")
+	src := "function loop():\n    loop()\n\nloop()\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := interpret(t, file)
+	var runtimeErr *compiler.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+	if runtimeErr.Line != 2 || !strings.Contains(runtimeErr.Message, "stack overflow") {
+		t.Errorf("unexpected error: %v", runtimeErr)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.mob")
+	if err := os.WriteFile(file, []byte("print(\"hi\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := compiler.NewCompiler().Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var stdout bytes.Buffer
+	if err := Run(ctx, res, &compiler.Runtime{Stdout: &stdout}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("canceled program printed %q", stdout.String())
+	}
+}
@@ -0,0 +1,123 @@
+package lint
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Edit replaces the bytes Start to End of a file with New. Start ==
+// End inserts.
+type Edit struct {
+	Start, End int
+	New        string
+}
+
+// Fix is a change that resolves a finding. Its edits are applied
+// together or not at all.
+type Fix struct {
+	Message string
+	Edits   []Edit
+}
+
+// maxFixRounds bounds how many times Fix lints again to apply fixes
+// that overlapped the ones of the previous round.
+const maxFixRounds = 10
+
+// Fix applies the fixes of the findings of src, the content of the
+// file called name. Fixes that overlap one already applied wait for
+// the next round, which lints the fixed source again; a round whose
+// result does not parse is dropped. It returns the fixed source, the
+// number of fixes applied and the findings left.
+func (l *Linter) Fix(name string, src []byte) ([]byte, int, []Finding) {
+	applied := 0
+	findings, _ := l.lint(name, src)
+	for round := 0; round < maxFixRounds; round++ {
+		var fixes []*Fix
+		for _, f := range findings {
+			if f.Fix != nil {
+				fixes = append(fixes, f.Fix)
+			}
+		}
+		fixed, n := Apply(src, fixes)
+		if n == 0 {
+			break
+		}
+		next, ok := l.lint(name, fixed)
+		if !ok {
+			break
+		}
+		src, findings = fixed, next
+		applied += n
+	}
+	return src, applied, findings
+}
+
+// Apply applies fixes to src, in order, skipping those with an edit
+// that overlaps an edit of a fix already taken. It returns the new
+// source and the number of fixes applied.
+func Apply(src []byte, fixes []*Fix) ([]byte, int) {
+	var edits []Edit
+	applied := 0
+	for _, fix := range fixes {
+		if overlaps(edits, fix.Edits) {
+			continue
+		}
+		edits = append(edits, fix.Edits...)
+		applied++
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Start < edits[j].Start })
+	var b bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		b.Write(src[pos:e.Start])
+		b.WriteString(e.New)
+		pos = e.End
+	}
+	b.Write(src[pos:])
+	return b.Bytes(), applied
+}
+
+// overlaps reports whether an edit of b overlaps one of a or another
+// one of b. Insertions at the same offset overlap, since their order
+// would be arbitrary.
+func overlaps(a, b []Edit) bool {
+	for i, e := range b {
+		for _, other := range append(a[:len(a):len(a)], b[:i]...) {
+			if e.Start == other.Start || (e.Start < other.End && other.Start < e.End) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteFile replaces the file at path with data, keeping its mode. The
+// data goes to a temporary file that is renamed over path, so readers
+// see the old content or the new one, never a mix.
+func WriteFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,341 @@
+// Package lint checks .mob files for code that compiles but is likely
+// wrong or unidiomatic. Each check is a Rule; rules are registered with
+// Register and run by a Linter.
+package lint
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/moblang/mob/pkg/compiler"
+	"github.com/moblang/mob/pkg/project"
+)
+
+// Rule is one check. Run inspects the file of a Pass and reports what
+// it finds with Pass.Reportf.
+type Rule struct {
+	// Name identifies the rule in output, configuration and
+	// "# mob:ignore name" comments.
+	Name string
+	// Code is the stable code of the rule's findings, such as L0101,
+	// in machine-readable output and mob explain.
+	Code string
+	Doc  string
+	// Explanation is the long-form description mob explain prints.
+	Explanation string
+	// Severity is used unless the Linter overrides it.
+	Severity compiler.Severity
+	Run      func(p *Pass)
+}
+
+var registry = make(map[string]*Rule)
+
+// Register adds a rule to the ones run by default. It panics when a
+// rule with the same name exists.
+func Register(r *Rule) {
+	if _, ok := registry[r.Name]; ok {
+		panic("lint: rule " + r.Name + " registered twice")
+	}
+	if other, ok := LookupCode(r.Code); ok {
+		panic("lint: rules " + other.Name + " and " + r.Name + " have the same code")
+	}
+	registry[r.Name] = r
+}
+
+// Rules returns the registered rules, sorted by name.
+func Rules() []*Rule {
+	rules := make([]*Rule, 0, len(registry))
+	for _, r := range registry {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// Lookup returns the registered rule called name.
+func Lookup(name string) (*Rule, bool) {
+	r, ok := registry[name]
+	return r, ok
+}
+
+// LookupCode returns the registered rule whose code is code.
+func LookupCode(code string) (*Rule, bool) {
+	for _, r := range registry {
+		if r.Code == code {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Finding is a problem reported by a rule.
+type Finding struct {
+	compiler.Diagnostic
+	Rule string
+	// Fix resolves the problem, when the rule knows how.
+	Fix *Fix
+}
+
+func (f Finding) String() string {
+	return f.Diagnostic.String() + " (" + f.Rule + ")"
+}
+
+// Pass is a rule running on one parsed file.
+type Pass struct {
+	File     string
+	Src      []byte
+	AST      compiler.Node
+	Comments []compiler.Token
+
+	rule     *Rule
+	severity compiler.Severity
+	// entry is set when no other file imports this one.
+	entry    bool
+	tokens   []compiler.Token
+	findings []Finding
+	// lines holds the offset of the start of each line, from 1.
+	lines []int
+}
+
+// Reportf reports a finding of the running rule at node.
+func (p *Pass) Reportf(node compiler.Node, format string, args ...any) {
+	p.ReportFixf(node, nil, format, args...)
+}
+
+// ReportFixf reports a finding of the running rule at node that fix
+// resolves. fix may be nil.
+func (p *Pass) ReportFixf(node compiler.Node, fix *Fix, format string, args ...any) {
+	d := compiler.Diagnostic{
+		File:     p.File,
+		Line:     node.Line,
+		Column:   node.Column,
+		Code:     p.rule.Code,
+		Severity: p.severity,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	d.EndLine, d.EndColumn = node.End()
+	if fix != nil {
+		s := compiler.Suggestion{Message: fix.Message}
+		for _, e := range fix.Edits {
+			edit := compiler.TextEdit{NewText: e.New}
+			edit.Line, edit.Column = p.position(e.Start)
+			edit.EndLine, edit.EndColumn = p.position(e.End)
+			s.Edits = append(s.Edits, edit)
+		}
+		d.Suggestions = []compiler.Suggestion{s}
+	}
+	p.findings = append(p.findings, Finding{Diagnostic: d, Rule: p.rule.Name, Fix: fix})
+}
+
+// Offset returns the offset in Src of a line and column, both from 1.
+func (p *Pass) Offset(line, column int) int {
+	return p.LineStart(line) + column - 1
+}
+
+// position returns the line and column, from 1, of an offset in Src.
+func (p *Pass) position(offset int) (line, column int) {
+	p.LineStart(1)
+	line = sort.Search(len(p.lines), func(i int) bool { return p.lines[i] > offset }) - 1
+	return line, offset - p.lines[line] + 1
+}
+
+// LineStart returns the offset in Src where line starts, or len(Src)
+// past the last line, so LineStart(n+1) ends line n with its newline.
+func (p *Pass) LineStart(line int) int {
+	if p.lines == nil {
+		p.lines = []int{0, 0}
+		for i, c := range p.Src {
+			if c == '\n' {
+				p.lines = append(p.lines, i+1)
+			}
+		}
+	}
+	if line >= len(p.lines) {
+		return len(p.Src)
+	}
+	return p.lines[line]
+}
+
+// Linter runs rules on files.
+type Linter struct {
+	// Rules are the rules to run; nil means every registered rule.
+	Rules []*Rule
+	// Severity overrides the severity of rules by name.
+	Severity map[string]compiler.Severity
+	// Disabled rules do not run.
+	Disabled map[string]bool
+	// Entries are the files, by absolute path, that no other file
+	// imports, such as the entry of a project. Fixes that rename
+	// functions are only offered in them: the functions of a module
+	// are called from the files importing it, which a fix to the
+	// module alone would break.
+	Entries map[string]bool
+}
+
+// Configure sets the level of the rule called name: "error",
+// "warning" or "off".
+func (l *Linter) Configure(name, level string) error {
+	if _, ok := Lookup(name); !ok {
+		return fmt.Errorf("unknown lint rule %q", name)
+	}
+	if l.Severity == nil {
+		l.Severity = make(map[string]compiler.Severity)
+	}
+	if l.Disabled == nil {
+		l.Disabled = make(map[string]bool)
+	}
+
+	delete(l.Disabled, name)
+	switch level {
+	case "error":
+		l.Severity[name] = compiler.SeverityError
+	case "warning":
+		l.Severity[name] = compiler.SeverityWarning
+	case "off":
+		l.Disabled[name] = true
+	default:
+		return fmt.Errorf("invalid level %q for lint rule %s: use error, warning or off", level, name)
+	}
+	return nil
+}
+
+// File lints the file at path.
+func (l *Linter) File(path string) ([]Finding, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return l.Source(path, src), nil
+}
+
+// Source lints src, the content of the file called name. Rules need a
+// valid syntax tree, so a file with syntax errors has no findings; the
+// compiler reports those errors.
+func (l *Linter) Source(name string, src []byte) []Finding {
+	findings, _ := l.lint(name, src)
+	return findings
+}
+
+// lint is Source, also reporting whether src parsed.
+func (l *Linter) lint(name string, src []byte) ([]Finding, bool) {
+	lexer := compiler.NewLexer(string(src))
+	tokens := lexer.Tokenize()
+	parser := compiler.NewParser(tokens)
+	ast := parser.Parse()
+	if len(parser.Errors()) > 0 {
+		return nil, false
+	}
+
+	code := make(map[int]bool)
+	for _, tok := range tokens {
+		switch tok.Type {
+		case compiler.TokenNewline, compiler.TokenIndent, compiler.TokenDedent, compiler.TokenEOF:
+		default:
+			code[tok.Line] = true
+		}
+	}
+	ignored := ignores(lexer.Comments(), code)
+
+	rules := l.Rules
+	if rules == nil {
+		rules = Rules()
+	}
+
+	entry := false
+	if abs, err := filepath.Abs(name); err == nil {
+		entry = l.Entries[abs]
+	}
+
+	var findings []Finding
+	for _, r := range rules {
+		if l.Disabled[r.Name] {
+			continue
+		}
+		p := &Pass{File: name, Src: src, AST: ast, Comments: lexer.Comments(), rule: r, severity: r.Severity, entry: entry, tokens: tokens}
+		if severity, ok := l.Severity[r.Name]; ok {
+			p.severity = severity
+		}
+		r.Run(p)
+		for _, f := range p.findings {
+			if !ignored[f.Line][r.Name] {
+				findings = append(findings, f)
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Line != findings[j].Line {
+			return findings[i].Line < findings[j].Line
+		}
+		return findings[i].Column < findings[j].Column
+	})
+	return findings, true
+}
+
+// ignorePrefix starts a comment that suppresses rules.
+const ignorePrefix = "mob:ignore"
+
+// ignores returns the rules suppressed on each line. "# mob:ignore a, b"
+// suppresses rules a and b on its own line, and on the next line when
+// the comment is alone on its line, which code reports.
+func ignores(comments []compiler.Token, code map[int]bool) map[int]map[string]bool {
+	ignored := make(map[int]map[string]bool)
+	for _, c := range comments {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Value, "#"))
+		if !strings.HasPrefix(text, ignorePrefix) {
+			continue
+		}
+		names := strings.FieldsFunc(text[len(ignorePrefix):], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		lines := []int{c.Line}
+		if !code[c.Line] {
+			lines = append(lines, c.Line+1)
+		}
+		for _, line := range lines {
+			if ignored[line] == nil {
+				ignored[line] = make(map[string]bool)
+			}
+			for _, name := range names {
+				ignored[line][name] = true
+			}
+		}
+	}
+	return ignored
+}
+
+// Files returns path itself when it is a file, or every .mob file below
+// it, skipping hidden directories and the vendor directories of
+// projects: vendored packages are checked against mob.lock and must not
+// be edited, or reported on, in place.
+func Files(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p != path && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() && p != path && d.Name() == project.VendorDir {
+			if _, err := os.Stat(filepath.Join(filepath.Dir(p), project.ManifestName)); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".mob") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,454 @@
+package lint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+// lintSource returns the findings of src as file:line:col: severity:
+// message (rule) strings.
+func lintSource(t *testing.T, l *Linter, src string) []string {
+	t.Helper()
+	var got []string
+	for _, f := range l.Source("main.mob", []byte(src)) {
+		got = append(got, f.String())
+	}
+	return got
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "unused-import",
+			src:  "import utils\nimport lib.strings\nfrom helpers import a, b\n\nlib.strings.shout()\nb()\n",
+			want: []string{
+				"main.mob:1:1: warning: utils is imported but not used (unused-import)",
+				"main.mob:3:21: warning: a is imported from helpers but not used (unused-import)",
+			},
+		},
+		{
+			name: "used in arguments",
+			src:  "import strings\nfrom sys import argv\n\nprint(strings.upper(argv()))\n",
+		},
+		{
+			name: "shadowing",
+			src:  "from io import print\n\nfunction args():\n    print(\"x\")\n\nargs()\n",
+			want: []string{
+				"main.mob:1:16: warning: print imported from io hides the builtin print (shadowing)",
+				"main.mob:3:1: warning: function args hides the builtin args (shadowing)",
+			},
+		},
+		{
+			name: "unreachable-code",
+			src:  "function f():\n    print(\"a\")\n    return\n    print(\"b\")\n    print(\"c\")\n\nf()\n",
+			want: []string{
+				"main.mob:4:5: warning: unreachable code after return at line 3 (unreachable-code)",
+			},
+		},
+		{
+			name: "trailing return",
+			src:  "function f():\n    print(\"a\")\n    return\n\nf()\n",
+		},
+		{
+			name: "naming",
+			src:  "function sayHello():\n    return\n\nfunction parse_HTTPRequest():\n    return\n\nfunction save__file_():\n    return\n\nfunction ok_2():\n    return\n",
+			want: []string{
+				"main.mob:1:1: warning: function sayHello should be snake_case: say_hello (naming)",
+				"main.mob:4:1: warning: function parse_HTTPRequest should be snake_case: parse_http_request (naming)",
+				"main.mob:7:1: warning: function save__file_ should be snake_case: save_file (naming)",
+			},
+		},
+		{
+			name: "syntax errors",
+			src:  "function Bad(:\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lintSource(t, &Linter{}, tt.src)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	src := "import a # mob:ignore unused-import\n" +
+		"# mob:ignore naming, unused-import\n" +
+		"from b import c\n" +
+		"import d\n" +
+		"\n" +
+		"function badName(): # mob:ignore shadowing\n" +
+		"    return\n"
+	got := lintSource(t, &Linter{}, src)
+	want := []string{
+		"main.mob:4:1: warning: d is imported but not used (unused-import)",
+		"main.mob:6:1: warning: function badName should be snake_case: bad_name (naming)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	l := &Linter{}
+	if err := l.Configure("naming", "error"); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	if err := l.Configure("unused-import", "off"); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	got := lintSource(t, l, "import a\n\nfunction F():\n    return\n")
+	want := []string{"main.mob:3:1: error: function F should be snake_case: f (naming)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if l.Severity["naming"] != compiler.SeverityError {
+		t.Errorf("Expected naming to be an error")
+	}
+
+	if err := l.Configure("no-such-rule", "error"); err == nil {
+		t.Error("Expected an error for an unknown rule")
+	}
+	if err := l.Configure("naming", "fatal"); err == nil {
+		t.Error("Expected an error for an invalid level")
+	}
+}
+
+func TestRulesRegistered(t *testing.T) {
+	var names []string
+	for _, r := range Rules() {
+		names = append(names, r.Name)
+		if r.Doc == "" || r.Run == nil {
+			t.Errorf("rule %s has no doc or no Run", r.Name)
+		}
+	}
+	want := []string{"naming", "shadowing", "unreachable-code", "unused-import"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected rules %v, got %v", want, names)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.mob", "lib/strings.mob", "lib/notes.txt", ".git/hook.mob", "mob.toml", "vendor/dep/dep.mob", "lib/vendor/v.mob"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("print(\"x\")\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	// Only the vendor directory next to mob.toml is the project's.
+	want := []string{filepath.Join(dir, "lib", "strings.mob"), filepath.Join(dir, "lib", "vendor", "v.mob"), filepath.Join(dir, "main.mob")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Expected %v, got %v", want, files)
+	}
+
+	single := filepath.Join(dir, "main.mob")
+	if files, err := Files(single); err != nil || len(files) != 1 || files[0] != single {
+		t.Errorf("Expected only %s, got %v (%v)", single, files, err)
+	}
+}
+
+func TestFix(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			name: "unused imports",
+			src:  "import a\nfrom b import c, d, e\nfrom f import g, h\nfrom i import j\n\nd()\nj()\n",
+			want: "from b import d\nfrom i import j\n\nd()\nj()\n",
+		},
+		{
+			name: "last name of an import",
+			src:  "from b import c, d\n\nc()\n",
+			want: "from b import c\n\nc()\n",
+		},
+		{
+			name: "rename",
+			src:  "function sayHello():\n    print(\"hi\")\n\nfunction run():\n    sayHello()\n\nsayHello()\nrun()\n",
+			want: "function say_hello():\n    print(\"hi\")\n\nfunction run():\n    say_hello()\n\nsay_hello()\nrun()\n",
+		},
+		{
+			// The snake_case name is taken, so there is no fix.
+			name: "rename clash",
+			src:  "function aB():\n    return\n\nfunction a_b():\n    return\n",
+			want: "function aB():\n    return\n\nfunction a_b():\n    return\n",
+		},
+		{
+			name: "unreachable code",
+			src:  "function f():\n    print(\"a\")\n    return\n    print(\"b\")\n    # note\n    print(\"c\")\n# after\nf()\n",
+			want: "function f():\n    print(\"a\")\n    return\n# after\nf()\n",
+		},
+		{
+			name: "unreachable wrapped call",
+			src:  "function f():\n    return\n    print(\n        \"a\",\n        \"b\nc\",\n    )  # done\n\nf()\n",
+			want: "function f():\n    return\n\nf()\n",
+		},
+	}
+
+	main, err := filepath.Abs("main.mob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Linter{Entries: map[string]bool{main: true}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _ := l.Fix("main.mob", []byte(tt.src))
+			if string(got) != tt.want {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFixRenameOnlyInEntries(t *testing.T) {
+	dir := t.TempDir()
+	utils := filepath.Join(dir, "utils.mob")
+	main := filepath.Join(dir, "main.mob")
+	files := map[string]string{
+		utils: "function sayHi():\n    print(\"hi\")\n",
+		main:  "import utils\n\nfunction runAll():\n    utils.sayHi()\n\nrunAll()\n",
+	}
+	for file, src := range files {
+		if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// main.mob imports utils.mob, so only main.mob is an entry.
+	l := &Linter{Entries: map[string]bool{main: true}}
+	for file, src := range files {
+		out, _, findings := l.Fix(file, []byte(src))
+		if err := os.WriteFile(file, out, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if file == utils && (string(out) != src || len(findings) != 1 || findings[0].Fix != nil) {
+			t.Errorf("Expected utils.mob unchanged with a finding and no fix, got %v:\n%s", findings, out)
+		}
+	}
+	if got, _ := os.ReadFile(main); !strings.Contains(string(got), "function run_all():") {
+		t.Errorf("Expected runAll renamed in main.mob, got:\n%s", got)
+	}
+
+	// The program still builds.
+	diags, err := compiler.NewCompiler().Check(main)
+	if err != nil || diags.HasErrors() {
+		t.Errorf("Expected the fixed program to check, got %v %v", diags, err)
+	}
+}
+
+func TestFixRounds(t *testing.T) {
+	// Removing d and e overlaps; the second round removes the other.
+	src := "from b import c, d, e\n\nc()\n"
+	got, applied, findings := (&Linter{}).Fix("main.mob", []byte(src))
+	if string(got) != "from b import c\n\nc()\n" {
+		t.Errorf("Unexpected fix:\n%s", got)
+	}
+	if applied != 2 || len(findings) != 0 {
+		t.Errorf("Expected 2 fixes and no findings left, got %d and %v", applied, findings)
+	}
+}
+
+func TestApply(t *testing.T) {
+	src := []byte("abcdef")
+	fixes := []*Fix{
+		{Edits: []Edit{{Start: 1, End: 3, New: "X"}}},
+		{Edits: []Edit{{Start: 2, End: 4, New: "Y"}}},
+		{Edits: []Edit{{Start: 5, End: 5, New: "Z"}, {Start: 0, End: 1}}},
+	}
+	got, n := Apply(src, fixes)
+	if string(got) != "XdeZf" || n != 2 {
+		t.Errorf("Expected XdeZf from 2 fixes, got %s from %d", got, n)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	new := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn"
+	want := "--- a/main.mob\n+++ b/main.mob\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -11,3 +11,4 @@\n k\n l\n m\n+n\n\\ No newline at end of file\n"
+	if got := Diff("main.mob", []byte(old), []byte(new)); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	if got := Diff("main.mob", []byte("a\n"), []byte("")); got != "--- a/main.mob\n+++ b/main.mob\n@@ -1 +0,0 @@\n-a\n" {
+		t.Errorf("Unexpected diff of a deleted line:\n%s", got)
+	}
+	if got := Diff("main.mob", []byte(old), []byte(old)); got != "" {
+		t.Errorf("Expected no diff, got:\n%s", got)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.mob")
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(path, []byte("new")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	info, _ := os.Stat(path)
+	if string(data) != "new" || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected new with mode 0600, got %q with %v", data, info.Mode())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("Expected no temporary file left, got %v", entries)
+	}
+}
+
+func TestFindingSuggestions(t *testing.T) {
+	main, err := filepath.Abs("main.mob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := (&Linter{Entries: map[string]bool{main: true}}).Source("main.mob", []byte("import a\n\nfunction sayHi():\n    return\n\nsayHi()\n"))
+	if len(findings) != 2 {
+		t.Fatalf("Expected 2 findings, got %v", findings)
+	}
+
+	imp := findings[0]
+	if imp.Code != "L0101" || imp.EndLine != 1 || imp.EndColumn != 7 {
+		t.Errorf("Expected L0101 ending at 1:7, got %s ending at %d:%d", imp.Code, imp.EndLine, imp.EndColumn)
+	}
+	want := []compiler.TextEdit{{Line: 1, Column: 1, EndLine: 2, EndColumn: 1}}
+	if len(imp.Suggestions) != 1 || !reflect.DeepEqual(imp.Suggestions[0].Edits, want) {
+		t.Errorf("Expected edits %v, got %v", want, imp.Suggestions)
+	}
+
+	rename := findings[1].Suggestions
+	want = []compiler.TextEdit{
+		{Line: 3, Column: 10, EndLine: 3, EndColumn: 15, NewText: "say_hi"},
+		{Line: 6, Column: 1, EndLine: 6, EndColumn: 6, NewText: "say_hi"},
+	}
+	if len(rename) != 1 || !reflect.DeepEqual(rename[0].Edits, want) {
+		t.Errorf("Expected edits %v, got %v", want, rename)
+	}
+}
+
+func TestRuleCodes(t *testing.T) {
+	for _, r := range Rules() {
+		if got, ok := LookupCode(r.Code); !ok || got != r {
+			t.Errorf("rule %s: code %q does not look it up", r.Name, r.Code)
+		}
+		if r.Explanation == "" {
+			t.Errorf("rule %s has no explanation", r.Name)
+		}
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	diags := []compiler.Diagnostic{
+		{File: "main.mob", Line: 2, Column: 1, EndLine: 2, EndColumn: 6, Code: compiler.CodeUndefined, Severity: compiler.SeverityError, Message: "undefined function: greet"},
+	}
+	for _, f := range (&Linter{}).Source("main.mob", []byte("import a\n")) {
+		diags = append(diags, f.Diagnostic)
+	}
+
+	var b strings.Builder
+	if err := WriteSARIF(&b, "1.2.3", "https://example.com", diags); err != nil {
+		t.Fatalf("WriteSARIF failed: %v", err)
+	}
+
+	var log struct {
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Version        string
+					InformationURI string
+					Rules          []struct {
+						ID   string
+						Name string
+					}
+				}
+			}
+			Results []struct {
+				RuleID    string
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn, EndLine, EndColumn int }
+					}
+				}
+				Fixes []struct {
+					ArtifactChanges []struct {
+						Replacements []struct {
+							DeletedRegion   struct{ StartLine, EndLine int }
+							InsertedContent struct{ Text string }
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(b.String()), &log); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, b.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("Unexpected log:\n%s", b.String())
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Version != "1.2.3" || run.Tool.Driver.InformationURI != "https://example.com" || len(run.Tool.Driver.Rules) != 2 ||
+		run.Tool.Driver.Rules[0].ID != "E0201" || run.Tool.Driver.Rules[1].Name != "unused-import" {
+		t.Errorf("Unexpected driver: %+v", run.Tool.Driver)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("Expected 2 results, got %+v", run.Results)
+	}
+	first := run.Results[0]
+	region := first.Locations[0].PhysicalLocation.Region
+	if first.RuleID != "E0201" || first.Level != "error" || first.Locations[0].PhysicalLocation.ArtifactLocation.URI != "main.mob" ||
+		region.StartLine != 2 || region.EndColumn != 6 {
+		t.Errorf("Unexpected result: %+v", first)
+	}
+	second := run.Results[1]
+	if second.Level != "warning" || len(second.Fixes) != 1 {
+		t.Fatalf("Expected a warning with a fix, got %+v", second)
+	}
+	deleted := second.Fixes[0].ArtifactChanges[0].Replacements[0].DeletedRegion
+	if deleted.StartLine != 1 || deleted.EndLine != 2 {
+		t.Errorf("Expected the fix to delete line 1, got %+v", deleted)
+	}
+
+	// Columns count code points, not bytes.
+	file := filepath.Join(t.TempDir(), "main.mob")
+	if err := os.WriteFile(file, []byte("print(\"é\", missing())\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	diags = []compiler.Diagnostic{{File: file, Line: 1, Column: 13, EndLine: 1, EndColumn: 20, Code: compiler.CodeUndefined, Severity: compiler.SeverityError, Message: "undefined function: missing"}}
+	if err := WriteSARIF(&b, "1.2.3", "https://example.com", diags); err != nil {
+		t.Fatalf("WriteSARIF failed: %v", err)
+	}
+	if err := json.Unmarshal([]byte(b.String()), &log); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, b.String())
+	}
+	region = log.Runs[0].Results[0].Locations[0].PhysicalLocation.Region
+	if region.StartColumn != 12 || region.EndColumn != 19 {
+		t.Errorf("Expected columns 12 to 19, got %+v", region)
+	}
+}
@@ -0,0 +1,329 @@
+package lint
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+// The language has no variables, classes or constants yet, so the rules
+// for unused variables and for PascalCase and UPPER_SNAKE_CASE names
+// have nothing to check; they belong here once the parser has them.
+func init() {
+	Register(&Rule{
+		Name:     "unused-import",
+		Code:     "L0101",
+		Doc:      "Imported modules and from-imported names that are never called.",
+		Severity: compiler.SeverityWarning,
+		Run:      unusedImport,
+		Explanation: `A module is imported, or a name is imported from one, but never called.
+
+Example:
+
+    import strings
+    from utils import helper, other
+
+    other()
+
+Unused imports still load, and run the top-level code of, the module;
+they also make it harder to see what a file depends on. The fix
+removes the import, or only the unused names of a from-import:
+
+    from utils import other
+
+    other()
+`,
+	})
+	Register(&Rule{
+		Name:     "shadowing",
+		Code:     "L0102",
+		Doc:      "Functions and from-imported names that hide a builtin such as print.",
+		Severity: compiler.SeverityWarning,
+		Run:      shadowing,
+		Explanation: `A function of the file, or a from-imported name, has the name of a builtin.
+
+Example:
+
+    function print():
+        args()
+
+Calls in the file then reach the function instead of the builtin,
+which surprises readers. Choose another name, or import the module
+and call its function qualified, such as log.print().
+`,
+	})
+	Register(&Rule{
+		Name:     "unreachable-code",
+		Code:     "L0103",
+		Doc:      "Statements after a return, which never run.",
+		Severity: compiler.SeverityWarning,
+		Run:      unreachableCode,
+		Explanation: `Statements follow a return in the same block, so they never run.
+
+Example:
+
+    function greet():
+        print("hello")
+        return
+        print("bye")
+
+The fix deletes the statements after the return. If they should run,
+move the return after them.
+`,
+	})
+	Register(&Rule{
+		Name:     "naming",
+		Code:     "L0104",
+		Doc:      "Function names that are not snake_case.",
+		Severity: compiler.SeverityWarning,
+		Run:      naming,
+		Explanation: `A function name is not snake_case: lower case words joined by single
+underscores, as the language conventions ask.
+
+Example:
+
+    function sayHello():
+        print("hello")
+
+The fix renames the function and the calls to it in the same file:
+
+    function say_hello():
+        print("hello")
+
+The fix is only offered in files no other file imports, such as the
+entry of the project: the functions of a module are called from the
+files importing it, which the fix would not update. Rename those by
+hand, or silence the rule with # mob:ignore naming.
+`,
+	})
+}
+
+// calls returns every call in nodes, arguments included, by name as
+// written: rules see the tree before from-imported names are resolved.
+func calls(nodes []compiler.Node) map[string]bool {
+	names := make(map[string]bool)
+	var walk func(nodes []compiler.Node)
+	walk = func(nodes []compiler.Node) {
+		for _, node := range nodes {
+			if node.Type == compiler.NodeCall {
+				names[node.Value] = true
+			}
+			walk(node.Children)
+		}
+	}
+	walk(nodes)
+	return names
+}
+
+func unusedImport(p *Pass) {
+	called := calls(p.AST.Children)
+	prefixes := make(map[string]bool)
+	for name := range called {
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			prefixes[name[:i]] = true
+		}
+	}
+
+	for _, stmt := range p.AST.Children {
+		switch stmt.Type {
+		case compiler.NodeImport:
+			if !prefixes[stmt.Value] {
+				fix := &Fix{Message: "remove import " + stmt.Value, Edits: []Edit{p.deleteLines(stmt.Line, stmt.Line)}}
+				p.ReportFixf(stmt, fix, "%s is imported but not used", stmt.Value)
+			}
+		case compiler.NodeFromImport:
+			used := 0
+			for _, name := range stmt.Children {
+				if called[name.Value] {
+					used++
+				}
+			}
+			for i, name := range stmt.Children {
+				if called[name.Value] {
+					continue
+				}
+				fix := &Fix{Message: "remove " + name.Value + " from the import"}
+				switch {
+				case used == 0:
+					fix.Edits = []Edit{p.deleteLines(stmt.Line, stmt.Line)}
+				case i+1 < len(stmt.Children):
+					// Remove the name and the ", " up to the next one.
+					next := stmt.Children[i+1]
+					fix.Edits = []Edit{{Start: p.Offset(name.Line, name.Column), End: p.Offset(next.Line, next.Column)}}
+				default:
+					prev := stmt.Children[i-1]
+					fix.Edits = []Edit{{Start: p.Offset(prev.Line, prev.Column) + len(prev.Value), End: p.Offset(name.Line, name.Column) + len(name.Value)}}
+				}
+				p.ReportFixf(name, fix, "%s is imported from %s but not used", name.Value, stmt.Value)
+			}
+		}
+	}
+}
+
+// deleteLines returns an edit deleting lines first to last, with their
+// newlines.
+func (p *Pass) deleteLines(first, last int) Edit {
+	return Edit{Start: p.LineStart(first), End: p.LineStart(last + 1)}
+}
+
+// lastLine returns the line stmt ends on: the line of its last token
+// before the newline ending it. The lexer drops newlines inside
+// parentheses, so a wrapped call ends at its closing parenthesis.
+func (p *Pass) lastLine(stmt compiler.Node) int {
+	line := stmt.Line
+	for _, tok := range p.tokens {
+		if tok.Line < stmt.Line || (tok.Line == stmt.Line && tok.Column < stmt.Column) {
+			continue
+		}
+		switch tok.Type {
+		case compiler.TokenNewline, compiler.TokenIndent, compiler.TokenDedent, compiler.TokenEOF:
+			return line
+		}
+		// A string can span lines.
+		line = tok.Line + strings.Count(tok.Value, "\n")
+	}
+	return line
+}
+
+func shadowing(p *Pass) {
+	for _, stmt := range p.AST.Children {
+		switch stmt.Type {
+		case compiler.NodeFunction:
+			if _, ok := compiler.Builtins[stmt.Value]; ok {
+				p.Reportf(stmt, "function %s hides the builtin %s", stmt.Value, stmt.Value)
+			}
+		case compiler.NodeFromImport:
+			for _, name := range stmt.Children {
+				if _, ok := compiler.Builtins[name.Value]; ok {
+					p.Reportf(name, "%s imported from %s hides the builtin %s", name.Value, stmt.Value, name.Value)
+				}
+			}
+		}
+	}
+}
+
+func unreachableCode(p *Pass) {
+	for _, stmt := range p.AST.Children {
+		if stmt.Type != compiler.NodeFunction {
+			continue
+		}
+		for i, body := range stmt.Children {
+			if body.Type == compiler.NodeReturn && i+1 < len(stmt.Children) {
+				// The unreachable code is the lines from the first
+				// statement to the end of the last, which a wrapped call
+				// takes past the line it starts on.
+				first, last := stmt.Children[i+1], stmt.Children[len(stmt.Children)-1]
+				fix := &Fix{Message: "delete the unreachable code", Edits: []Edit{p.deleteLines(first.Line, p.lastLine(last))}}
+				p.ReportFixf(first, fix, "unreachable code after return at line %d", body.Line)
+				break
+			}
+		}
+	}
+}
+
+func naming(p *Pass) {
+	// Names a rename must not take.
+	taken := make(map[string]bool)
+	for name := range compiler.Builtins {
+		taken[name] = true
+	}
+	for _, stmt := range p.AST.Children {
+		switch stmt.Type {
+		case compiler.NodeFunction:
+			taken[stmt.Value] = true
+		case compiler.NodeFromImport:
+			for _, name := range stmt.Children {
+				taken[name.Value] = true
+			}
+		}
+	}
+
+	for _, stmt := range p.AST.Children {
+		if stmt.Type != compiler.NodeFunction || isSnakeCase(stmt.Value) {
+			continue
+		}
+		want := snakeCase(stmt.Value)
+		var fix *Fix
+		if p.entry && isSnakeCase(want) && !taken[want] {
+			taken[want] = true
+			fix = p.rename(stmt, want)
+		}
+		p.ReportFixf(stmt, fix, "function %s should be snake_case: %s", stmt.Value, want)
+	}
+}
+
+// rename returns a fix renaming fn, and the calls to it in this file,
+// to name. Calls from other files are not renamed, so it is only safe
+// in an entry file.
+func (p *Pass) rename(fn compiler.Node, name string) *Fix {
+	// fn is positioned at the function keyword; the name follows it.
+	start := p.Offset(fn.Line, fn.Column) + len("function")
+	for start < len(p.Src) && (p.Src[start] == ' ' || p.Src[start] == '\t') {
+		start++
+	}
+	fix := &Fix{
+		Message: "rename " + fn.Value + " to " + name,
+		Edits:   []Edit{{Start: start, End: start + len(fn.Value), New: name}},
+	}
+
+	var walk func(nodes []compiler.Node)
+	walk = func(nodes []compiler.Node) {
+		for _, node := range nodes {
+			if node.Type == compiler.NodeCall && node.Value == fn.Value {
+				start := p.Offset(node.Line, node.Column)
+				fix.Edits = append(fix.Edits, Edit{Start: start, End: start + len(fn.Value), New: name})
+			}
+			walk(node.Children)
+		}
+	}
+	walk(p.AST.Children)
+	return fix
+}
+
+// isSnakeCase reports whether name is lower case words and digits
+// joined by single underscores.
+func isSnakeCase(name string) bool {
+	if name == "" || name[0] == '_' || name[len(name)-1] == '_' || strings.Contains(name, "__") {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !unicode.IsLower(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// snakeCase converts camelCase, PascalCase and badly joined names to
+// snake_case: sayHelloHTTP becomes say_hello_http.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case r == '_':
+			flush()
+			continue
+		case unicode.IsUpper(r):
+			// A capital starts a word, unless it continues an acronym
+			// that is not followed by a lower case letter.
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			prevUpper := i > 0 && unicode.IsUpper(runes[i-1])
+			if prevLower || (prevUpper && nextLower) {
+				flush()
+			}
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	flush()
+	return strings.Join(words, "_")
+}
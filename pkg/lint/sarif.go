@@ -0,0 +1,174 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+// sarifSchema is the SARIF version WriteSARIF writes.
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// WriteSARIF writes diags, compiler diagnostics and lint findings
+// alike, as a SARIF 2.1.0 log of one run of the mob tool at version,
+// documented at uri, for code scanning services. Each code is a rule,
+// described by its explanation. Diagnostics count columns in bytes;
+// SARIF counts code points, read from the files the diagnostics are in.
+func WriteSARIF(w io.Writer, version, uri string, diags []compiler.Diagnostic) error {
+	type object = map[string]any
+
+	src := make(sources)
+	sarifLocation := func(file string, line, column, endLine, endColumn int, hasRegion bool) object {
+		physical := object{"artifactLocation": object{"uri": sarifURI(file)}}
+		if hasRegion {
+			physical["region"] = src.region(file, line, column, endLine, endColumn)
+		}
+		return object{"physicalLocation": physical}
+	}
+
+	codes := make(map[string]bool)
+	results := []object{}
+	for _, d := range diags {
+		result := object{
+			"level":   sarifLevel(d.Severity),
+			"message": object{"text": d.Message},
+		}
+		if d.Code != "" {
+			codes[d.Code] = true
+			result["ruleId"] = d.Code
+		}
+		if d.File != "" {
+			result["locations"] = []object{sarifLocation(d.File, d.Line, d.Column, d.EndLine, d.EndColumn, d.Line > 0)}
+		}
+		var related []object
+		for i, note := range d.Notes {
+			loc := sarifLocation(note.File, note.Line, note.Column, note.EndLine, note.EndColumn, note.Line > 0)
+			loc["id"] = i
+			loc["message"] = object{"text": note.Message}
+			related = append(related, loc)
+		}
+		if related != nil {
+			result["relatedLocations"] = related
+		}
+		var fixes []object
+		for _, s := range d.Suggestions {
+			var replacements []object
+			for _, e := range s.Edits {
+				replacements = append(replacements, object{
+					"deletedRegion":   src.region(d.File, e.Line, e.Column, e.EndLine, e.EndColumn),
+					"insertedContent": object{"text": e.NewText},
+				})
+			}
+			fixes = append(fixes, object{
+				"description":     object{"text": s.Message},
+				"artifactChanges": []object{{"artifactLocation": object{"uri": sarifURI(d.File)}, "replacements": replacements}},
+			})
+		}
+		if fixes != nil {
+			result["fixes"] = fixes
+		}
+		results = append(results, result)
+	}
+
+	sorted := make([]string, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, code)
+	}
+	sort.Strings(sorted)
+	rules := []object{}
+	for _, code := range sorted {
+		rule := object{"id": code}
+		if r, ok := LookupCode(code); ok {
+			rule["name"] = r.Name
+			rule["shortDescription"] = object{"text": r.Doc}
+			rule["fullDescription"] = object{"text": r.Explanation}
+			rule["defaultConfiguration"] = object{"level": sarifLevel(r.Severity)}
+		} else if e, ok := compiler.Explain(code); ok {
+			rule["name"] = e.Title
+			rule["shortDescription"] = object{"text": e.Title}
+			rule["fullDescription"] = object{"text": e.Text}
+			rule["defaultConfiguration"] = object{"level": "error"}
+		}
+		rules = append(rules, rule)
+	}
+
+	log := object{
+		"$schema": sarifSchema,
+		"version": "2.1.0",
+		"runs": []object{{
+			"tool": object{"driver": object{
+				"name":           "mob",
+				"version":        version,
+				"informationUri": uri,
+				"rules":          rules,
+			}},
+			"columnKind": "unicodeCodePoints",
+			"results":    results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+func sarifLevel(s compiler.Severity) string {
+	if s == compiler.SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// sources holds the lines of the files diagnostics are in, read once,
+// or nil for files that cannot be read.
+type sources map[string][][]byte
+
+// region returns a SARIF region, with byte columns converted to code
+// points. Columns in files that cannot be read stay as they are.
+func (s sources) region(file string, line, column, endLine, endColumn int) map[string]any {
+	region := map[string]any{"startLine": line, "startColumn": s.column(file, line, column)}
+	if endLine > 0 {
+		region["endLine"] = endLine
+		region["endColumn"] = s.column(file, endLine, endColumn)
+	}
+	return region
+}
+
+// column converts a byte column on line of file to a code point column.
+// Columns past the end of the line count the bytes beyond it.
+func (s sources) column(file string, line, column int) int {
+	lines, ok := s[file]
+	if !ok {
+		if data, err := os.ReadFile(file); err == nil {
+			lines = bytes.Split(data, []byte("\n"))
+		}
+		s[file] = lines
+	}
+	if line < 1 || line > len(lines) || column < 1 {
+		return column
+	}
+	text := lines[line-1]
+	if n := column - 1; n <= len(text) {
+		return utf8.RuneCount(text[:n]) + 1
+	}
+	return utf8.RuneCount(text) + column - len(text)
+}
+
+// sarifURI is file as a URI: relative paths stay relative, with forward
+// slashes, and absolute ones become file URIs.
+func sarifURI(file string) string {
+	uri := filepath.ToSlash(file)
+	if filepath.IsAbs(file) {
+		if !strings.HasPrefix(uri, "/") {
+			uri = "/" + uri
+		}
+		return "file://" + uri
+	}
+	return uri
+}
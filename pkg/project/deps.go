@@ -0,0 +1,460 @@
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// VendorDir holds copies of every dependency made by Vendor. When it
+// exists, dependencies are read from it instead of their sources.
+const VendorDir = "vendor"
+
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Dependency is one entry of [dependencies]. It comes either from a
+// local path or, when Path is empty, from the registry.
+type Dependency struct {
+	// Name is the package name, which is also the first component of
+	// the import paths that load it.
+	Name string
+	// Version is a constraint such as "^1.2.0". For path dependencies it
+	// is optional and checked against the package's own version.
+	Version string
+	// Path is relative to the manifest's directory.
+	Path string
+}
+
+func parseDependency(name string, value any) (Dependency, error) {
+	dep := Dependency{Name: name}
+	if !identPattern.MatchString(name) {
+		return dep, fmt.Errorf("dependency name %q must be a valid identifier", name)
+	}
+
+	switch v := value.(type) {
+	case string:
+		dep.Version = v
+	case table:
+		for _, key := range sortedKeys(v) {
+			var err error
+			switch key {
+			case "version":
+				dep.Version, err = stringValue(v, key)
+			case "path":
+				dep.Path, err = stringValue(v, key)
+			default:
+				err = fmt.Errorf("unknown key %q", key)
+			}
+			if err != nil {
+				return dep, fmt.Errorf("dependency %s: %w", name, err)
+			}
+		}
+	default:
+		return dep, fmt.Errorf("dependency %s must be a version string or a table", name)
+	}
+
+	if dep.Path == "" && dep.Version == "" {
+		return dep, fmt.Errorf("dependency %s needs a version or a path", name)
+	}
+	if _, err := ParseConstraint(dep.Version); err != nil {
+		return dep, fmt.Errorf("dependency %s: %w", name, err)
+	}
+	return dep, nil
+}
+
+// spec is the manifest value for the dependency.
+func (d Dependency) spec() string {
+	switch {
+	case d.Path == "":
+		return fmt.Sprintf("%q", d.Version)
+	case d.Version == "":
+		return fmt.Sprintf("{ path = %q }", filepath.ToSlash(d.Path))
+	default:
+		return fmt.Sprintf("{ path = %q, version = %q }", filepath.ToSlash(d.Path), d.Version)
+	}
+}
+
+// Package is a resolved dependency.
+type Package struct {
+	Name    string
+	Version string
+	// Source records where the package came from: "path+<dir>" with dir
+	// relative to the root project, or "registry".
+	Source string
+	// Hash is the sha256 of the package's .mob files and manifest.
+	Hash string
+	// Dir is where the package is read from: its source, or its copy in
+	// the vendor directory.
+	Dir      string
+	Manifest *Manifest
+}
+
+// Entry is the absolute path of the package's entry file.
+func (p *Package) Entry() string {
+	return p.Manifest.EntryPath()
+}
+
+// SourceDirs are the absolute directories its imports are looked up in.
+func (p *Package) SourceDirs() []string {
+	return p.Manifest.SourceDirs()
+}
+
+// RegistryDir is the local registry: $MOB_REGISTRY, then the manifest's
+// registry setting, then ~/.mob/registry.
+func (m *Manifest) RegistryDir() string {
+	if dir := os.Getenv("MOB_REGISTRY"); dir != "" {
+		return dir
+	}
+	if m.Registry != "" {
+		return filepath.Join(m.Dir, filepath.FromSlash(m.Registry))
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".mob", "registry")
+}
+
+// Resolve finds every package m depends on, directly or not, sorted by
+// name. Versions locked in lock are kept while they still satisfy the
+// manifest, so builds are repeatable; the registry and vendored copies
+// must match the locked hashes. Each package can only be used at one
+// version.
+func Resolve(m *Manifest, lock *Lock) ([]*Package, error) {
+	return resolve(m, lock, filepath.Join(m.Dir, VendorDir))
+}
+
+// ResolveSources is Resolve ignoring the vendor directory, as needed to
+// vendor again.
+func ResolveSources(m *Manifest, lock *Lock) ([]*Package, error) {
+	return resolve(m, lock, "")
+}
+
+func resolve(m *Manifest, lock *Lock, vendor string) ([]*Package, error) {
+	r := &resolver{
+		root:     m,
+		lock:     lock,
+		registry: m.RegistryDir(),
+		vendor:   vendor,
+		extra:    make(map[string][]Constraint),
+	}
+
+	// A registry package picked too new for a later constraint is
+	// resolved again with that constraint from the start. Every retry
+	// adds a constraint, so this ends.
+	for {
+		r.resolved = make(map[string]*Package)
+		err := r.resolveAll(m, "mob.toml")
+		if errors.Is(err, errRetry) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	pkgs := make([]*Package, 0, len(r.resolved))
+	for _, pkg := range r.resolved {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+type resolver struct {
+	root     *Manifest
+	lock     *Lock
+	registry string
+	vendor   string
+	resolved map[string]*Package
+	// extra are constraints learned from conflicts, applied when picking
+	// registry versions.
+	extra map[string][]Constraint
+}
+
+var errRetry = errors.New("retry")
+
+func (r *resolver) resolveAll(m *Manifest, from string) error {
+	for _, dep := range m.Dependencies {
+		if dep.Name == r.root.Name {
+			return fmt.Errorf("%s: %s cannot depend on the root package %s", from, m.Name, dep.Name)
+		}
+
+		constraint, _ := ParseConstraint(dep.Version)
+		if pkg, ok := r.resolved[dep.Name]; ok {
+			if v, _ := ParseVersion(pkg.Version); !constraint.Match(v) {
+				if pkg.Source == "registry" && !r.learned(dep.Name, constraint) {
+					r.extra[dep.Name] = append(r.extra[dep.Name], constraint)
+					return errRetry
+				}
+				return fmt.Errorf("%s: %s requires %s %s, but %s is already used", from, m.Name, dep.Name, constraint, pkg.Version)
+			}
+			continue
+		}
+
+		pkg, err := r.resolve(m, dep, constraint)
+		if err != nil {
+			return fmt.Errorf("%s: %w", from, err)
+		}
+		r.resolved[dep.Name] = pkg
+
+		if err := r.resolveAll(pkg.Manifest, pkg.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) learned(name string, c Constraint) bool {
+	for _, known := range r.extra[name] {
+		if known.String() == c.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether v satisfies c and every learned constraint.
+func (r *resolver) matches(name string, c Constraint, v Version) bool {
+	if !c.Match(v) {
+		return false
+	}
+	for _, known := range r.extra[name] {
+		if !known.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *resolver) resolve(m *Manifest, dep Dependency, constraint Constraint) (*Package, error) {
+	locked := r.lock.find(dep.Name)
+
+	if locked != nil && r.vendor != "" {
+		dir := filepath.Join(r.vendor, dep.Name)
+		if _, err := os.Stat(dir); err == nil {
+			pkg, err := r.load(dep.Name, dir)
+			if err != nil {
+				return nil, err
+			}
+			if pkg.Hash != locked.Hash {
+				return nil, fmt.Errorf("%s does not match mob.lock; run 'mob vendor' again", filepath.Join(VendorDir, dep.Name))
+			}
+			pkg.Source = locked.Source
+			return pkg, r.check(dep, constraint, pkg)
+		}
+	}
+
+	if dep.Path != "" {
+		dir := filepath.Join(m.Dir, filepath.FromSlash(dep.Path))
+		pkg, err := r.load(dep.Name, dir)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(r.root.Dir, dir)
+		if err != nil {
+			rel = dir
+		}
+		pkg.Source = "path+" + filepath.ToSlash(rel)
+		return pkg, r.check(dep, constraint, pkg)
+	}
+
+	versions, err := r.registryVersions(dep.Name)
+	if err != nil {
+		return nil, err
+	}
+	var chosen *Version
+	for i := len(versions) - 1; i >= 0; i-- {
+		if r.matches(dep.Name, constraint, versions[i]) {
+			chosen = &versions[i]
+			break
+		}
+	}
+	if locked != nil && locked.Source == "registry" {
+		if v, err := ParseVersion(locked.Version); err == nil && r.matches(dep.Name, constraint, v) {
+			chosen = &v
+		}
+	}
+	if chosen == nil {
+		wanted := []string{constraint.String()}
+		for _, known := range r.extra[dep.Name] {
+			wanted = append(wanted, known.String())
+		}
+		return nil, fmt.Errorf("no version of %s in %s matches %s", dep.Name, r.registry, strings.Join(wanted, " and "))
+	}
+
+	pkg, err := r.load(dep.Name, filepath.Join(r.registry, dep.Name, chosen.String()))
+	if err != nil {
+		return nil, err
+	}
+	pkg.Source = "registry"
+	if locked != nil && locked.Version == pkg.Version && locked.Hash != pkg.Hash {
+		return nil, fmt.Errorf("%s %s in the registry does not match the hash in mob.lock", dep.Name, pkg.Version)
+	}
+	return pkg, r.check(dep, constraint, pkg)
+}
+
+// check verifies the package is the one the dependency asked for.
+func (r *resolver) check(dep Dependency, constraint Constraint, pkg *Package) error {
+	if pkg.Manifest.Name != dep.Name {
+		return fmt.Errorf("dependency %s points at package %s", dep.Name, pkg.Manifest.Name)
+	}
+	v, err := ParseVersion(pkg.Version)
+	if err != nil {
+		return fmt.Errorf("package %s: %w", dep.Name, err)
+	}
+	if !constraint.Match(v) {
+		return fmt.Errorf("package %s is version %s, which does not match %s", dep.Name, pkg.Version, constraint)
+	}
+	return nil
+}
+
+func (r *resolver) load(name, dir string) (*Package, error) {
+	m, err := Load(filepath.Join(dir, ManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("package %s not found: no %s in %s", name, ManifestName, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := HashDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Package{Name: name, Version: m.Version, Hash: hash, Dir: m.Dir, Manifest: m}, nil
+}
+
+// registryVersions lists the published versions of name, oldest first.
+func (r *resolver) registryVersions(name string) ([]Version, error) {
+	entries, err := os.ReadDir(filepath.Join(r.registry, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("package %s not found in registry %s", name, r.registry)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, e := range entries {
+		if v, err := ParseVersion(e.Name()); err == nil && e.IsDir() {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Compare(versions[j]) < 0 })
+	return versions, nil
+}
+
+// packageFiles lists the files that make up a package, relative to dir:
+// its manifest and .mob sources, skipping hidden and vendor directories.
+func packageFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == VendorDir) {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() && (strings.HasSuffix(path, ".mob") || d.Name() == ManifestName) {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// HashDir hashes the files of the package in dir, as recorded in mob.lock.
+func HashDir(dir string) (string, error) {
+	files, err := packageFiles(dir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", file, len(data))
+		h.Write(data)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Vendor copies every package into the vendor directory of m, replacing
+// what was there.
+func Vendor(m *Manifest, pkgs []*Package) error {
+	vendor := filepath.Join(m.Dir, VendorDir)
+	tmp, err := os.MkdirTemp(m.Dir, ".vendor-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, pkg := range pkgs {
+		files, err := packageFiles(pkg.Dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := copyFile(filepath.Join(pkg.Dir, filepath.FromSlash(file)), filepath.Join(tmp, pkg.Name, filepath.FromSlash(file))); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.RemoveAll(vendor); err != nil {
+		return err
+	}
+	return os.Rename(tmp, vendor)
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// LatestVersion is the newest release of name in the registry of m.
+// Prereleases are skipped.
+func LatestVersion(m *Manifest, name string) (Version, error) {
+	r := &resolver{registry: m.RegistryDir()}
+	versions, err := r.registryVersions(name)
+	if err != nil {
+		return Version{}, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Pre == "" {
+			return versions[i], nil
+		}
+	}
+	return Version{}, fmt.Errorf("package %s has no releases in registry %s", name, r.registry)
+}
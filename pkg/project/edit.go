@@ -0,0 +1,90 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AddDependency returns data, the content of the manifest at path, with
+// dep added, or replacing the entry with the same name. The text is
+// edited so comments and layout are kept. Nothing is written: callers
+// write the result once the new dependencies resolve.
+func AddDependency(path string, data []byte, dep Dependency) ([]byte, error) {
+	if !identPattern.MatchString(dep.Name) {
+		return nil, fmt.Errorf("dependency name %q must be a valid identifier", dep.Name)
+	}
+	line := dep.Name + " = " + dep.spec()
+
+	return editDependencies(path, data, dep.Name, func(lines []string, start, end, found int) []string {
+		switch {
+		case found >= 0:
+			lines[found] = line
+		case start < 0:
+			if len(lines) > 0 && lines[len(lines)-1] != "" {
+				lines = append(lines, "")
+			}
+			lines = append(lines, "[dependencies]", line)
+		default:
+			at := end
+			for at > start+1 && strings.TrimSpace(lines[at-1]) == "" {
+				at--
+			}
+			lines = append(lines[:at], append([]string{line}, lines[at:]...)...)
+		}
+		return lines
+	})
+}
+
+// RemoveDependency returns data, the content of the manifest at path,
+// without the dependency called name. Nothing is written.
+func RemoveDependency(path string, data []byte, name string) ([]byte, error) {
+	return editDependencies(path, data, name, func(lines []string, start, end, found int) []string {
+		if found < 0 {
+			return nil
+		}
+		return append(lines[:found], lines[found+1:]...)
+	})
+}
+
+// editDependencies calls edit with the manifest's lines, the index of
+// the [dependencies] header and of the first line after the section
+// (-1 when there is no section) and the index of the line for name (-1
+// when absent). A nil result means name was not found. The edited
+// manifest must still parse.
+func editDependencies(path string, data []byte, name string, edit func(lines []string, start, end, found int) []string) ([]byte, error) {
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	start, end, found := -1, -1, -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if start >= 0 && end < 0 {
+				end = i
+			}
+			if trimmed == "[dependencies]" {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && end < 0 {
+			key, _, ok := strings.Cut(trimmed, "=")
+			if ok && strings.Trim(strings.TrimSpace(key), `"`) == name {
+				found = i
+			}
+		}
+	}
+	if start >= 0 && end < 0 {
+		end = len(lines)
+	}
+
+	lines = edit(lines, start, end, found)
+	if lines == nil {
+		return nil, fmt.Errorf("%s has no dependency %s", path, name)
+	}
+
+	content := []byte(strings.Join(lines, "\n") + "\n")
+	if _, err := Parse(path, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
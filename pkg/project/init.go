@@ -0,0 +1,56 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// ValidName reports whether name can be used as a project name, which
+// is also the default binary name.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Init scaffolds a project named name in dir: a manifest, a hello world
+// main.mob, an empty tests directory and a .gitignore for the binary.
+// Existing files are never overwritten.
+func Init(dir, name string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("invalid project name %q: use letters, digits, '-' and '_', starting with a letter", name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ManifestName)); err == nil {
+		return fmt.Errorf("%s already exists in %s", ManifestName, dir)
+	}
+
+	files := []struct{ path, content string }{
+		{ManifestName, fmt.Sprintf(`[package]
+name = %q
+version = "0.1.0"
+entry = "main.mob"
+output = %q
+targets = [%q]
+`, name, name, runtime.GOOS+"/"+runtime.GOARCH)},
+		{"main.mob", fmt.Sprintf("print(%q)\n", "Hello from "+name+"!")},
+		{filepath.Join("tests", ".gitkeep"), ""},
+		{".gitignore", "/" + name + "\n"},
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(f.content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LockName is the file that records the resolved dependencies.
+const LockName = "mob.lock"
+
+// Lock pins the version, source and content hash of every package a
+// project uses.
+type Lock struct {
+	// Packages are sorted by name.
+	Packages []LockedPackage
+}
+
+type LockedPackage struct {
+	Name    string
+	Version string
+	Source  string
+	Hash    string
+}
+
+// NewLock records the resolved packages.
+func NewLock(pkgs []*Package) *Lock {
+	lock := &Lock{}
+	for _, pkg := range pkgs {
+		lock.Packages = append(lock.Packages, LockedPackage{
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			Source:  pkg.Source,
+			Hash:    pkg.Hash,
+		})
+	}
+	return lock
+}
+
+// LoadLock reads the lock file of the project in dir. A missing lock
+// file is an empty lock.
+func LoadLock(dir string) (*Lock, error) {
+	path := filepath.Join(dir, LockName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Lock{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parseTOML(path, data)
+	if err != nil {
+		return nil, err
+	}
+	deps, _ := doc["dependency"].(table)
+
+	lock := &Lock{}
+	for _, name := range sortedKeys(deps) {
+		t, ok := deps[name].(table)
+		if !ok {
+			return nil, fmt.Errorf("%s: dependency.%s must be a table", path, name)
+		}
+		pkg := LockedPackage{Name: name}
+		pkg.Version, _ = t["version"].(string)
+		pkg.Source, _ = t["source"].(string)
+		pkg.Hash, _ = t["hash"].(string)
+		lock.Packages = append(lock.Packages, pkg)
+	}
+	return lock, nil
+}
+
+func (l *Lock) find(name string) *LockedPackage {
+	if l == nil {
+		return nil
+	}
+	for i := range l.Packages {
+		if l.Packages[i].Name == name {
+			return &l.Packages[i]
+		}
+	}
+	return nil
+}
+
+func (l *Lock) String() string {
+	var b strings.Builder
+	b.WriteString("# This file is generated by mob. Do not edit it by hand.\n")
+	for _, pkg := range l.Packages {
+		fmt.Fprintf(&b, "\n[dependency.%s]\nversion = %q\nsource = %q\nhash = %q\n", pkg.Name, pkg.Version, pkg.Source, pkg.Hash)
+	}
+	return b.String()
+}
+
+// Write saves the lock file of the project in dir, leaving it untouched
+// when nothing changed. Projects without dependencies get no lock file.
+func (l *Lock) Write(dir string) error {
+	path := filepath.Join(dir, LockName)
+	if len(l.Packages) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	content := l.String()
+	if old, err := os.ReadFile(path); err == nil && string(old) == content {
+		return nil
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
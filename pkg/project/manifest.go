@@ -0,0 +1,275 @@
+// Package project reads mob.toml project manifests and scaffolds new
+// projects.
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestName is the file that marks the root of a Mob project.
+const ManifestName = "mob.toml"
+
+// ErrNoManifest is returned by Find when no directory up to the
+// filesystem root contains a manifest.
+var ErrNoManifest = errors.New("no " + ManifestName + " found")
+
+// Manifest describes a project:
+//
+//	[package]
+//	name = "hello"
+//	version = "0.1.0"
+//	entry = "main.mob"
+//	sources = ["src"]
+//	output = "hello"
+//	targets = ["linux/amd64", "linux/arm64"]
+//
+//	[dependencies]
+//	strutil = { path = "../strutil" }
+//	mathx = "^1.2.0"
+//
+//	[toolchain]
+//	goroot = "/usr/local/go"
+//
+//	[lint]
+//	naming = "error"
+//	unused-import = "off"
+type Manifest struct {
+	Name    string
+	Version string
+	// Entry is the file run and built when no file is given, relative to
+	// Dir. It defaults to main.mob.
+	Entry string
+	// Sources are the directories searched for imports, relative to Dir.
+	// They default to Dir itself.
+	Sources []string
+	// Output is the binary name. It defaults to Name.
+	Output string
+	// Targets are the GOOS/GOARCH pairs the project is built for.
+	Targets []string
+	// Registry is a local directory of published packages, laid out as
+	// <registry>/<name>/<version>/. It is relative to Dir.
+	Registry string
+	// Dependencies are sorted by name.
+	Dependencies []Dependency
+	// GoRoot is the Go installation used to build, relative to Dir. When
+	// empty, go is looked up on PATH.
+	GoRoot string
+	// Lint sets the level of lint rules by name: "error", "warning" or
+	// "off". Rule names are checked by the linter.
+	Lint map[string]string
+
+	// Dir is the directory holding the manifest.
+	Dir string
+}
+
+// Find looks for a manifest in dir and then in each of its parents.
+func Find(dir string) (*Manifest, error) {
+	path, err := FindPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	return Load(path)
+}
+
+// FindPath is Find without reading the manifest: it returns the path of
+// the nearest one, valid or not.
+func FindPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := filepath.Join(dir, ManifestName)
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			return path, err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ErrNoManifest
+		}
+		dir = parent
+	}
+}
+
+// Load reads the manifest at path.
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := Parse(path, data)
+	if err != nil {
+		return nil, err
+	}
+	m.Dir, _ = filepath.Abs(filepath.Dir(path))
+	return m, nil
+}
+
+// Parse decodes a manifest. file is only used in error messages.
+func Parse(file string, data []byte) (*Manifest, error) {
+	doc, err := parseTOML(file, data)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	for _, key := range sortedKeys(doc) {
+		if key != "package" && key != "dependencies" && key != "toolchain" && key != "lint" {
+			return nil, fmt.Errorf("%s: unknown section [%s]", file, key)
+		}
+	}
+
+	pkg, ok := doc["package"].(table)
+	if !ok {
+		return nil, fmt.Errorf("%s: missing [package] section", file)
+	}
+
+	for _, key := range sortedKeys(pkg) {
+		var err error
+		switch key {
+		case "name":
+			m.Name, err = stringValue(pkg, key)
+		case "version":
+			m.Version, err = stringValue(pkg, key)
+		case "entry":
+			m.Entry, err = stringValue(pkg, key)
+		case "sources":
+			m.Sources, err = stringsValue(pkg, key)
+		case "output":
+			m.Output, err = stringValue(pkg, key)
+		case "targets":
+			m.Targets, err = stringsValue(pkg, key)
+		case "registry":
+			m.Registry, err = stringValue(pkg, key)
+		default:
+			err = fmt.Errorf("unknown key %q in [package]", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	if deps, ok := doc["dependencies"].(table); ok {
+		for _, name := range sortedKeys(deps) {
+			dep, err := parseDependency(name, deps[name])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			m.Dependencies = append(m.Dependencies, dep)
+		}
+	} else if _, ok := doc["dependencies"]; ok {
+		return nil, fmt.Errorf("%s: dependencies must be a table", file)
+	}
+
+	if toolchain, ok := doc["toolchain"].(table); ok {
+		for _, key := range sortedKeys(toolchain) {
+			if key != "goroot" {
+				return nil, fmt.Errorf("%s: unknown key %q in [toolchain]", file, key)
+			}
+			if m.GoRoot, err = stringValue(toolchain, key); err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	}
+
+	if lint, ok := doc["lint"].(table); ok {
+		m.Lint = make(map[string]string)
+		for _, rule := range sortedKeys(lint) {
+			level, err := stringValue(lint, rule)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			if level != "error" && level != "warning" && level != "off" {
+				return nil, fmt.Errorf("%s: invalid level %q for lint rule %s: use error, warning or off", file, level, rule)
+			}
+			m.Lint[rule] = level
+		}
+	} else if _, ok := doc["lint"]; ok {
+		return nil, fmt.Errorf("%s: lint must be a table", file)
+	}
+
+	if m.Name == "" {
+		return nil, fmt.Errorf("%s: package name is required", file)
+	}
+	if m.Entry == "" {
+		m.Entry = "main.mob"
+	}
+	if m.Output == "" {
+		m.Output = m.Name
+	}
+	for _, target := range m.Targets {
+		if os, arch, ok := strings.Cut(target, "/"); target != "wasm" && (!ok || os == "" || arch == "") {
+			return nil, fmt.Errorf("%s: target %q must be os/arch, such as linux/amd64", file, target)
+		}
+	}
+
+	return m, nil
+}
+
+// EntryPath is the absolute path of the entry file.
+func (m *Manifest) EntryPath() string {
+	return filepath.Join(m.Dir, filepath.FromSlash(m.Entry))
+}
+
+// GoRootDir is the absolute path of the manifest's Go installation, or
+// "" when it does not set one.
+func (m *Manifest) GoRootDir() string {
+	if m.GoRoot == "" || filepath.IsAbs(m.GoRoot) {
+		return m.GoRoot
+	}
+	return filepath.Join(m.Dir, filepath.FromSlash(m.GoRoot))
+}
+
+// SourceDirs are the absolute directories searched for imports.
+func (m *Manifest) SourceDirs() []string {
+	if len(m.Sources) == 0 {
+		return []string{m.Dir}
+	}
+	dirs := make([]string, len(m.Sources))
+	for i, src := range m.Sources {
+		dirs[i] = filepath.Join(m.Dir, filepath.FromSlash(src))
+	}
+	return dirs
+}
+
+func sortedKeys(t table) []string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func stringValue(t table, key string) (string, error) {
+	s, ok := t[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
+
+func stringsValue(t table, key string) ([]string, error) {
+	values, ok := t[key].([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of strings", key)
+	}
+	strs := make([]string, len(values))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be an array of strings", key)
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
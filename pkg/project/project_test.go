@@ -0,0 +1,444 @@
+package project
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	src := `# Project settings
+[package]
+name = "hello"   # the binary name too
+version = "1.2.0"
+entry = "src/app.mob"
+sources = [
+    "src",
+    "lib",  # trailing comma is fine
+]
+output = "hello-app"
+targets = ["linux/amd64", "darwin/arm64"]
+`
+	m, err := Parse("mob.toml", []byte(src))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	want := &Manifest{
+		Name:    "hello",
+		Version: "1.2.0",
+		Entry:   "src/app.mob",
+		Sources: []string{"src", "lib"},
+		Output:  "hello-app",
+		Targets: []string{"linux/amd64", "darwin/arm64"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Expected %+v, got %+v", want, m)
+	}
+}
+
+func TestParseManifestToolchain(t *testing.T) {
+	m, err := Parse("mob.toml", []byte("[package]\nname = \"a\"\n\n[toolchain]\ngoroot = \"tools/go\"\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	m.Dir = "/project"
+	if got := m.GoRootDir(); got != filepath.Join("/project", "tools", "go") {
+		t.Errorf("Unexpected GOROOT %s", got)
+	}
+
+	if _, err := Parse("mob.toml", []byte("[package]\nname = \"a\"\n[toolchain]\ngo = \"1.22\"\n")); err == nil {
+		t.Error("Expected an error for an unknown toolchain key")
+	}
+}
+
+func TestParseManifestLint(t *testing.T) {
+	m, err := Parse("mob.toml", []byte("[package]\nname = \"a\"\n\n[lint]\nnaming = \"error\"\nunused-import = \"off\"\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if m.Lint["naming"] != "error" || m.Lint["unused-import"] != "off" || len(m.Lint) != 2 {
+		t.Errorf("Unexpected lint levels %v", m.Lint)
+	}
+
+	if _, err := Parse("mob.toml", []byte("[package]\nname = \"a\"\n[lint]\nnaming = \"fatal\"\n")); err == nil {
+		t.Error("Expected an error for an invalid lint level")
+	}
+}
+
+func TestParseManifestDefaults(t *testing.T) {
+	m, err := Parse("mob.toml", []byte("[package]\nname = \"tool\"\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if m.Entry != "main.mob" || m.Output != "tool" {
+		t.Errorf("Unexpected defaults: %+v", m)
+	}
+
+	m.Dir = "/project"
+	if got := m.SourceDirs(); !reflect.DeepEqual(got, []string{"/project"}) {
+		t.Errorf("Expected the project dir as the only source dir, got %v", got)
+	}
+	if got := m.EntryPath(); got != filepath.Join("/project", "main.mob") {
+		t.Errorf("Unexpected entry path %s", got)
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"[package]\nname = \"a\"\nentyr = \"main.mob\"\n", `mob.toml: unknown key "entyr" in [package]`},
+		{"[package]\nversion = \"1.0.0\"\n", "mob.toml: package name is required"},
+		{"[package]\nname = \"a\"\ntargets = [\"linux\"]\n", `mob.toml: target "linux" must be os/arch, such as linux/amd64`},
+		{"[package]\nname = 1\n", "mob.toml: name must be a string"},
+		{"[package]\nname \"a\"\n", `mob.toml:2: expected '=' after key "name"`},
+		{"[package]\nname = \"a\n", "mob.toml:2: unterminated string"},
+		{"[package]\nname = \"a\"\nname = \"b\"\n", `mob.toml:3: duplicate key "name"`},
+		{"[tools]\n", "mob.toml: unknown section [tools]"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse("mob.toml", []byte(tt.src))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Parse(%q): expected %q, got %v", tt.src, tt.want, err)
+		}
+	}
+}
+
+func TestParseTOMLInlineTables(t *testing.T) {
+	doc, err := parseTOML("x.toml", []byte("[a.b]\nc = { path = \"../c\", n = 3, on = true }\n"))
+	if err != nil {
+		t.Fatalf("parseTOML failed: %v", err)
+	}
+	want := table{"a": table{"b": table{"c": table{"path": "../c", "n": int64(3), "on": true}}}}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("Expected %v, got %v", want, doc)
+	}
+}
+
+func TestFindWalksUp(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ManifestName), []byte("[package]\nname = \"app\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(root, "src", "deep")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Find(sub)
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if m.Name != "app" || m.Dir != root {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+
+	if _, err := Find(t.TempDir()); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("Expected ErrNoManifest outside a project, got %v", err)
+	}
+
+	// FindPath locates a manifest without requiring it to be valid.
+	if err := os.WriteFile(filepath.Join(root, ManifestName), []byte("not toml\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := FindPath(sub); err != nil || path != filepath.Join(root, ManifestName) {
+		t.Errorf("FindPath returned %q, %v", path, err)
+	}
+	if _, err := Find(sub); err == nil {
+		t.Error("Expected Find to reject the invalid manifest")
+	}
+}
+
+func TestInit(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.mob"), []byte("print(\"mine\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Init(dir, "demo"); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	m, err := Load(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatalf("Scaffolded manifest does not load: %v", err)
+	}
+	if m.Name != "demo" || m.Output != "demo" || len(m.Targets) != 1 {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+
+	if src, _ := os.ReadFile(filepath.Join(dir, "main.mob")); string(src) != "print(\"mine\")\n" {
+		t.Errorf("Init overwrote main.mob: %q", src)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "tests")); err != nil || !info.IsDir() {
+		t.Errorf("Expected a tests directory: %v", err)
+	}
+	if ignore, _ := os.ReadFile(filepath.Join(dir, ".gitignore")); !strings.Contains(string(ignore), "/demo") {
+		t.Errorf("Expected .gitignore to ignore the binary, got %q", ignore)
+	}
+
+	if err := Init(dir, "demo"); err == nil {
+		t.Error("Init should refuse to overwrite an existing manifest")
+	}
+	if err := Init(t.TempDir(), "bad name"); err == nil {
+		t.Error("Init should reject invalid names")
+	}
+}
+
+func TestConstraintMatch(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		reject     []string
+	}{
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0", "1.5.0-rc.1"}},
+		{"1.2", []string{"1.2.0", "1.8.1"}, []string{"1.1.9", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0"}},
+		{"~1", []string{"1.0.0", "1.9.9"}, []string{"2.0.0"}},
+		{"=1.0.0", []string{"1.0.0"}, []string{"1.0.1"}},
+		{">=1.0.0, <3.0.0", []string{"1.0.0", "2.9.9"}, []string{"0.9.0", "3.0.0"}},
+		{">=2.0.0-rc.1", []string{"2.0.0-rc.2", "2.0.0", "3.1.0"}, []string{"2.0.0-beta", "1.9.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, []string{"1.0.0-alpha"}},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%q) failed: %v", tt.constraint, err)
+		}
+		for _, s := range tt.match {
+			if v, _ := ParseVersion(s); !c.Match(v) {
+				t.Errorf("%s should match %s", tt.constraint, s)
+			}
+		}
+		for _, s := range tt.reject {
+			if v, _ := ParseVersion(s); c.Match(v) {
+				t.Errorf("%s should not match %s", tt.constraint, s)
+			}
+		}
+	}
+
+	for _, bad := range []string{"1.2.x", "^", ">=1.2", "01.0.0"} {
+		if _, err := ParseConstraint(bad); err == nil {
+			t.Errorf("ParseConstraint(%q) should fail", bad)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// In order, from the SemVer spec and then some.
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0-rc.2",
+		"1.0.0-rc.10", "1.0.0", "1.0.1", "1.10.0", "2.0.0",
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			v, err := ParseVersion(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := ParseVersion(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := v.Compare(w); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+
+	for _, bad := range []string{"1.0.0-rc..1", "1.0.0-rc.01", "1.0.0-rc."} {
+		if _, err := ParseVersion(bad); err == nil {
+			t.Errorf("ParseVersion(%q) should fail", bad)
+		}
+	}
+}
+
+// writeFiles creates files under dir from a map of slash-separated
+// paths to contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func pkgManifest(name, version, deps string) string {
+	return "[package]\nname = \"" + name + "\"\nversion = \"" + version + "\"\n" + deps
+}
+
+func TestResolve(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"registry/mathx/1.0.0/mob.toml": pkgManifest("mathx", "1.0.0", ""),
+		"registry/mathx/1.4.0/mob.toml": pkgManifest("mathx", "1.4.0", ""),
+		"registry/mathx/2.0.0/mob.toml": pkgManifest("mathx", "2.0.0", ""),
+		"strutil/mob.toml":              pkgManifest("strutil", "0.3.0", "[dependencies]\nmathx = \"~1.0\"\n"),
+		"strutil/main.mob":              "print(\"strutil\")\n",
+		"app/mob.toml":                  pkgManifest("app", "1.0.0", "registry = \"../registry\"\n[dependencies]\nmathx = \"^1.0.0\"\nstrutil = { path = \"../strutil\", version = \"^0.3\" }\n"),
+	})
+	t.Setenv("MOB_REGISTRY", "")
+
+	m, err := Load(filepath.Join(root, "app", ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := Resolve(m, &Lock{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	// The newest ^1.0.0 release is 1.4.0, but strutil needs ~1.0.
+	if len(pkgs) != 2 || pkgs[0].Name != "mathx" || pkgs[0].Version != "1.0.0" || pkgs[1].Source != "path+../strutil" {
+		t.Fatalf("Unexpected packages: %+v, %+v", pkgs[0], pkgs[1])
+	}
+
+	writeFiles(t, root, map[string]string{
+		"app/mob.toml": pkgManifest("app", "1.0.0", "registry = \"../registry\"\n[dependencies]\nmathx = \"^1.4.0\"\nstrutil = { path = \"../strutil\" }\n"),
+	})
+	m, _ = Load(filepath.Join(root, "app", ManifestName))
+	if _, err := Resolve(m, &Lock{}); err == nil || !strings.Contains(err.Error(), "matches ^1.4.0 and ~1.0") {
+		t.Errorf("Expected a version conflict, got %v", err)
+	}
+}
+
+func TestResolveHonorsLock(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"registry/mathx/1.0.0/mob.toml": pkgManifest("mathx", "1.0.0", ""),
+		"app/mob.toml":                  pkgManifest("app", "1.0.0", "registry = \"../registry\"\n[dependencies]\nmathx = \"^1.0.0\"\n"),
+	})
+	t.Setenv("MOB_REGISTRY", "")
+	app := filepath.Join(root, "app")
+	m, _ := Load(filepath.Join(app, ManifestName))
+
+	pkgs, err := Resolve(m, &Lock{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if err := NewLock(pkgs).Write(app); err != nil {
+		t.Fatal(err)
+	}
+
+	// A newer release does not replace the locked version.
+	writeFiles(t, root, map[string]string{"registry/mathx/1.1.0/mob.toml": pkgManifest("mathx", "1.1.0", "")})
+	lock, err := LoadLock(app)
+	if err != nil {
+		t.Fatalf("LoadLock failed: %v", err)
+	}
+	if !reflect.DeepEqual(lock, NewLock(pkgs)) {
+		t.Errorf("Lock did not round trip: %+v", lock)
+	}
+	if pkgs, err := Resolve(m, lock); err != nil || pkgs[0].Version != "1.0.0" {
+		t.Errorf("Expected the locked 1.0.0, got %v (%v)", pkgs, err)
+	}
+
+	// Published packages must not change under a lock.
+	writeFiles(t, root, map[string]string{"registry/mathx/1.0.0/main.mob": "print(\"changed\")\n"})
+	if _, err := Resolve(m, lock); err == nil || !strings.Contains(err.Error(), "does not match the hash in mob.lock") {
+		t.Errorf("Expected a hash mismatch, got %v", err)
+	}
+}
+
+func TestVendor(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"lib/mob.toml":  pkgManifest("lib", "0.1.0", ""),
+		"lib/main.mob":  "print(\"lib\")\n",
+		"lib/notes.txt": "not part of the package\n",
+		"app/mob.toml":  pkgManifest("app", "1.0.0", "[dependencies]\nlib = { path = \"../lib\" }\n"),
+	})
+	app := filepath.Join(root, "app")
+	m, _ := Load(filepath.Join(app, ManifestName))
+
+	pkgs, err := Resolve(m, &Lock{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	lock := NewLock(pkgs)
+	if err := Vendor(m, pkgs); err != nil {
+		t.Fatalf("Vendor failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(app, VendorDir, "lib", "notes.txt")); err == nil {
+		t.Error("Only package files should be vendored")
+	}
+
+	// The vendored copy is used even when the source is gone.
+	if err := os.RemoveAll(filepath.Join(root, "lib")); err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err = Resolve(m, lock)
+	if err != nil {
+		t.Fatalf("Resolve from vendor failed: %v", err)
+	}
+	if pkgs[0].Dir != filepath.Join(app, VendorDir, "lib") || pkgs[0].Source != "path+../lib" {
+		t.Errorf("Unexpected vendored package %+v", pkgs[0])
+	}
+
+	writeFiles(t, app, map[string]string{"vendor/lib/main.mob": "print(\"patched\")\n"})
+	if _, err := Resolve(m, lock); err == nil || !strings.Contains(err.Error(), "does not match mob.lock") {
+		t.Errorf("Expected edited vendor copy to be rejected, got %v", err)
+	}
+}
+
+func TestAddAndRemoveDependency(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ManifestName)
+	writeFiles(t, dir, map[string]string{ManifestName: "# my app\n[package]\nname = \"app\"\n"})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := string(data)
+	for _, dep := range []Dependency{
+		{Name: "lib", Path: "../lib"},
+		{Name: "mathx", Version: "^1.0.0"},
+		{Name: "lib", Path: "../lib", Version: "^0.2"},
+	} {
+		if data, err = AddDependency(path, data, dep); err != nil {
+			t.Fatalf("AddDependency failed: %v", err)
+		}
+	}
+
+	want := "# my app\n[package]\nname = \"app\"\n\n[dependencies]\nlib = { path = \"../lib\", version = \"^0.2\" }\nmathx = \"^1.0.0\"\n"
+	if string(data) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, data)
+	}
+	// The edits are only written by the caller.
+	if onDisk, _ := os.ReadFile(path); string(onDisk) != original {
+		t.Errorf("AddDependency wrote the manifest:\n%s", onDisk)
+	}
+
+	if data, err = RemoveDependency(path, data, "lib"); err != nil {
+		t.Fatalf("RemoveDependency failed: %v", err)
+	}
+	m, err := Parse(path, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Dependencies) != 1 || m.Dependencies[0].Name != "mathx" {
+		t.Errorf("Unexpected dependencies %+v", m.Dependencies)
+	}
+	if _, err := RemoveDependency(path, data, "lib"); err == nil {
+		t.Error("Removing a missing dependency should fail")
+	}
+}
@@ -0,0 +1,247 @@
+package project
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, MAJOR.MINOR.PATCH with an optional
+// -prerelease suffix. Build metadata is not supported.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+// ParseVersion parses a full semantic version such as 1.4.2 or 2.0.0-rc.1.
+func ParseVersion(s string) (Version, error) {
+	v, n, err := parsePartial(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if n != 3 {
+		return Version{}, fmt.Errorf("invalid version %q: expected MAJOR.MINOR.PATCH", s)
+	}
+	return v, nil
+}
+
+// parsePartial parses a version that may leave out the minor and patch
+// numbers, as constraints like ^1.2 do, and returns how many numbers
+// were given.
+func parsePartial(s string) (Version, int, error) {
+	var v Version
+	core, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		if pre == "" {
+			return v, 0, fmt.Errorf("invalid version %q: empty prerelease", s)
+		}
+		for _, id := range strings.Split(pre, ".") {
+			if id == "" || (len(id) > 1 && id[0] == '0' && isNumeric(id)) {
+				return v, 0, fmt.Errorf("invalid version %q: bad prerelease identifier %q", s, id)
+			}
+		}
+		v.Pre = pre
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return v, 0, fmt.Errorf("invalid version %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (len(part) > 1 && part[0] == '0') {
+			return v, 0, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+	if hasPre && len(parts) != 3 {
+		return v, 0, fmt.Errorf("invalid version %q", s)
+	}
+	return v, len(parts), nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 as v sorts before, equal to or after w.
+// Prereleases sort before the release they precede.
+func (v Version) Compare(w Version) int {
+	for _, d := range []int{v.Major - w.Major, v.Minor - w.Minor, v.Patch - w.Patch} {
+		if d != 0 {
+			if d < 0 {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == w.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case w.Pre == "":
+		return -1
+	}
+	return comparePre(v.Pre, w.Pre)
+}
+
+// comparePre orders prereleases by their dot-separated identifiers, as
+// SemVer says: numeric ones by value and before alphanumeric ones, the
+// others in ASCII order, and a prefix before what extends it, so
+// rc.2 < rc.10 < rc.a < rc.a.1.
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, y := as[i], bs[i]
+		xNum, yNum := isNumeric(x), isNumeric(y)
+		switch {
+		case xNum && yNum:
+			// No leading zeros, so the longer number is the larger.
+			if d := len(x) - len(y); d != 0 {
+				return sign(d)
+			}
+			if x != y {
+				return strings.Compare(x, y)
+			}
+		case xNum:
+			return -1
+		case yNum:
+			return 1
+		case x != y:
+			return strings.Compare(x, y)
+		}
+	}
+	return sign(len(as) - len(bs))
+}
+
+func isNumeric(id string) bool {
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return id != ""
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// Constraint is a set of comparators that must all hold, such as
+// ">=1.2.0, <2.0.0". An empty constraint, or "*", matches anything.
+type Constraint struct {
+	text  string
+	terms []comparator
+}
+
+type comparator struct {
+	op string
+	v  Version
+}
+
+// ParseConstraint accepts comma-separated comparators: =, >, >=, <, <=,
+// ^ (compatible: same major, or same minor below 1.0) and ~ (same
+// minor). A bare version means ^, as in Cargo.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{text: strings.TrimSpace(s)}
+	if c.text == "" || c.text == "*" {
+		return c, nil
+	}
+
+	for _, part := range strings.Split(c.text, ",") {
+		part = strings.TrimSpace(part)
+		op := ""
+		for _, candidate := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				break
+			}
+		}
+		v, n, err := parsePartial(strings.TrimSpace(strings.TrimPrefix(part, op)))
+		if err != nil {
+			return c, fmt.Errorf("invalid constraint %q: %w", s, err)
+		}
+
+		switch op {
+		case "", "^":
+			upper := Version{Major: v.Major + 1}
+			switch {
+			case v.Major == 0 && n >= 2 && v.Minor > 0:
+				upper = Version{Minor: v.Minor + 1}
+			case v.Major == 0 && n == 3:
+				upper = Version{Patch: v.Patch + 1}
+			case v.Major == 0 && n == 2:
+				upper = Version{Minor: 1}
+			}
+			c.terms = append(c.terms, comparator{">=", v}, comparator{"<", upper})
+		case "~":
+			upper := Version{Major: v.Major, Minor: v.Minor + 1}
+			if n == 1 {
+				upper = Version{Major: v.Major + 1}
+			}
+			c.terms = append(c.terms, comparator{">=", v}, comparator{"<", upper})
+		default:
+			if n != 3 {
+				return c, fmt.Errorf("invalid constraint %q: %s needs a full version", s, op)
+			}
+			c.terms = append(c.terms, comparator{op, v})
+		}
+	}
+	return c, nil
+}
+
+// Match reports whether v satisfies every comparator. Prereleases only
+// match comparators that name a prerelease of the same version.
+func (c Constraint) Match(v Version) bool {
+	if v.Pre != "" && !c.allowsPre(v) {
+		return false
+	}
+	for _, t := range c.terms {
+		cmp := v.Compare(t.v)
+		ok := false
+		switch t.op {
+		case "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Constraint) allowsPre(v Version) bool {
+	for _, t := range c.terms {
+		if t.v.Pre != "" && t.v.Major == v.Major && t.v.Minor == v.Minor && t.v.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Constraint) String() string {
+	if c.text == "" {
+		return "*"
+	}
+	return c.text
+}
@@ -0,0 +1,294 @@
+package project
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// table is a decoded TOML table. Values are string, int64, bool, []any
+// or table.
+type table map[string]any
+
+// parseTOML decodes the subset of TOML that manifests use: [tables],
+// dotted table names, key = value pairs with strings, integers, booleans,
+// arrays (which may span lines) and inline tables, and # comments.
+func parseTOML(file string, data []byte) (table, error) {
+	p := &tomlParser{file: file, src: string(data), line: 1}
+	return p.parse()
+}
+
+type tomlParser struct {
+	file string
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", p.file, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) parse() (table, error) {
+	root := table{}
+	current := root
+
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.src) {
+			return root, nil
+		}
+
+		if p.src[p.pos] == '[' {
+			p.pos++
+			p.skipSpace(false)
+			keys, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if !p.accept(']') {
+				return nil, p.errorf("expected ']' after table name")
+			}
+			current, err = p.defineTable(root, keys)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			keys, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if !p.accept('=') {
+				return nil, p.errorf("expected '=' after key %q", strings.Join(keys, "."))
+			}
+			p.skipSpace(false)
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.set(current, keys, value); err != nil {
+				return nil, err
+			}
+		}
+
+		p.skipSpace(false)
+		if p.pos < len(p.src) && p.src[p.pos] != '\n' {
+			return nil, p.errorf("unexpected %q after value", p.src[p.pos])
+		}
+	}
+}
+
+// defineTable returns the table named by keys, creating it and any
+// parent tables as needed.
+func (p *tomlParser) defineTable(root table, keys []string) (table, error) {
+	t := root
+	for _, key := range keys {
+		switch v := t[key].(type) {
+		case nil:
+			next := table{}
+			t[key] = next
+			t = next
+		case table:
+			t = v
+		default:
+			return nil, p.errorf("%s is not a table", strings.Join(keys, "."))
+		}
+	}
+	return t, nil
+}
+
+func (p *tomlParser) set(t table, keys []string, value any) error {
+	t, err := p.defineTable(t, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if _, ok := t[key]; ok {
+		return p.errorf("duplicate key %q", strings.Join(keys, "."))
+	}
+	t[key] = value
+	return nil
+}
+
+// skipSpace skips blanks and comments, and newlines too when newlines is
+// set.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) accept(c byte) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		var key string
+		if p.pos < len(p.src) && p.src[p.pos] == '"' {
+			s, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		} else {
+			start := p.pos
+			for p.pos < len(p.src) && isBareKeyChar(p.src[p.pos]) {
+				p.pos++
+			}
+			key = p.src[start:p.pos]
+			if key == "" {
+				return nil, p.errorf("expected a key")
+			}
+		}
+		keys = append(keys, key)
+
+		p.skipSpace(false)
+		if !p.accept('.') {
+			return keys, nil
+		}
+		p.skipSpace(false)
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c < unicode.MaxASCII && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || c == '_' || c == '-')
+}
+
+func (p *tomlParser) parseValue() (any, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '"':
+		return p.parseString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return p.parseInlineTable()
+	case strings.HasPrefix(p.src[p.pos:], "true"):
+		p.pos += len("true")
+		return true, nil
+	case strings.HasPrefix(p.src[p.pos:], "false"):
+		p.pos += len("false")
+		return false, nil
+	case c == '-' || c == '+' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '_') {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(strings.ReplaceAll(p.src[start:p.pos], "_", ""), 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.src[start:p.pos])
+		}
+		return n, nil
+	default:
+		return nil, p.errorf("unexpected %q, expected a value", c)
+	}
+}
+
+func (p *tomlParser) parseString() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) && p.src[p.pos] != '"' {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '\n':
+			return "", p.errorf("unterminated string")
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+
+	s, err := strconv.Unquote(p.src[start:p.pos])
+	if err != nil {
+		return "", p.errorf("invalid string %s", p.src[start:p.pos])
+	}
+	return s, nil
+}
+
+func (p *tomlParser) parseArray() ([]any, error) {
+	p.pos++
+	values := []any{}
+	for {
+		p.skipSpace(true)
+		if p.accept(']') {
+			return values, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipSpace(true)
+		if p.accept(']') {
+			return values, nil
+		}
+		if !p.accept(',') {
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (table, error) {
+	p.pos++
+	t := table{}
+	p.skipSpace(false)
+	if p.accept('}') {
+		return t, nil
+	}
+	for {
+		p.skipSpace(false)
+		keys, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if !p.accept('=') {
+			return nil, p.errorf("expected '=' after key %q", strings.Join(keys, "."))
+		}
+		p.skipSpace(false)
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.set(t, keys, value); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace(false)
+		if p.accept('}') {
+			return t, nil
+		}
+		if !p.accept(',') {
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package vm compiles checked Mob programs to a compact bytecode and runs
+// it on a stack machine. Bytecode starts faster than a Go build and is
+// stored in .mobc files; it behaves like the generated Go code, sharing
+// compiler.Builtins and compiler.InitOrder with it.
+package vm
+
+import "fmt"
+
+// Extension is the file extension of compiled bytecode.
+const Extension = ".mobc"
+
+// Opcode is a bytecode instruction. Operands follow the opcode byte;
+// constant and function indexes are two bytes, big endian.
+type Opcode byte
+
+const (
+	// OpConst pushes constant a.
+	OpConst Opcode = iota + 1
+	// OpCall calls function a.
+	OpCall
+	// OpBuiltin calls the builtin named by constant a with the top b
+	// values of the stack as arguments, and pushes its result if it
+	// returns one.
+	OpBuiltin
+	// OpPop discards the top of the stack.
+	OpPop
+	// OpReturn returns from the current function.
+	OpReturn
+)
+
+var opcodes = [...]struct {
+	name string
+	// operands are the byte widths of the operands.
+	operands []int
+}{
+	OpConst:   {"CONST", []int{2}},
+	OpCall:    {"CALL", []int{2}},
+	OpBuiltin: {"BUILTIN", []int{2, 1}},
+	OpPop:     {"POP", nil},
+	OpReturn:  {"RETURN", nil},
+}
+
+func (op Opcode) String() string {
+	if op.valid() {
+		return opcodes[op].name
+	}
+	return fmt.Sprintf("OP(%d)", byte(op))
+}
+
+func (op Opcode) valid() bool {
+	return int(op) < len(opcodes) && opcodes[op].name != ""
+}
+
+// width is the size of the instruction, opcode included.
+func (op Opcode) width() int {
+	n := 1
+	for _, w := range opcodes[op].operands {
+		n += w
+	}
+	return n
+}
+
+// Program is a compiled program.
+type Program struct {
+	// Constants is the constant pool. All constants are strings.
+	Constants []string
+	// Files are the source files, relative to the entry file's dir.
+	Files     []string
+	Functions []Function
+	// Init lists the functions run at startup, in order: the top-level
+	// code of each module in compiler.InitOrder, then the entry's.
+	Init []int
+}
+
+// Function is a Mob function, or the top-level code of a module.
+type Function struct {
+	// Name is the qualified name, such as "lib.strings.shout", or the
+	// module path in angle brackets for top-level code.
+	Name string
+	// File indexes Program.Files.
+	File  int
+	Code  []byte
+	Lines []Line
+}
+
+// Line maps the instructions from PC on to a source position.
+type Line struct {
+	PC     int
+	Line   int
+	Column int
+}
+
+// position returns the source position of the instruction at pc.
+func (fn *Function) position(pc int) (line, column int) {
+	for _, l := range fn.Lines {
+		if l.PC > pc {
+			break
+		}
+		line, column = l.Line, l.Column
+	}
+	return line, column
+}
+
+// operand reads the n-byte operand at code[i:].
+func operand(code []byte, i, n int) int {
+	v := 0
+	for _, b := range code[i : i+n] {
+		v = v<<8 | int(b)
+	}
+	return v
+}
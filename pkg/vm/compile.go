@@ -0,0 +1,226 @@
+package vm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+// maxOperand is the largest constant or function index.
+const maxOperand = 1<<16 - 1
+
+// Compile compiles res, which must have been compiled without errors.
+func Compile(res *compiler.Result) (*Program, error) {
+	c := &bytecodeCompiler{
+		prog:      &Program{},
+		dir:       filepath.Dir(res.File),
+		constants: make(map[string]int),
+		files:     make(map[string]int),
+		functions: make(map[string]int),
+		modules:   make(map[string]*compiler.Module),
+	}
+
+	// Every function gets its index first, so calls can be compiled
+	// before the function they call.
+	c.declare("", res.File, res.AST)
+	for _, mod := range res.Modules {
+		c.modules[mod.Path] = mod
+		if mod.Functions == nil {
+			c.declare(mod.Path, mod.File, mod.AST)
+		}
+	}
+	if len(c.prog.Functions) > maxOperand {
+		return nil, fmt.Errorf("program has too many functions for bytecode (%d, at most %d)", len(c.prog.Functions), maxOperand)
+	}
+
+	c.module("", res.AST)
+	for _, mod := range res.Modules {
+		if mod.Functions == nil {
+			c.module(mod.Path, mod.AST)
+		}
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	for _, mod := range compiler.InitOrder(res) {
+		if mod.Functions == nil {
+			c.prog.Init = append(c.prog.Init, c.functions[initName(mod.Path)])
+		}
+	}
+	c.prog.Init = append(c.prog.Init, c.functions[initName("")])
+	return c.prog, nil
+}
+
+type bytecodeCompiler struct {
+	prog *Program
+	// dir is the entry file's dir; file names are stored relative to it.
+	dir       string
+	constants map[string]int
+	files     map[string]int
+	functions map[string]int
+	modules   map[string]*compiler.Module
+	err       error
+}
+
+// initName is the name of the function holding a module's top-level
+// code; the entry module's path is empty.
+func initName(path string) string {
+	if path == "" {
+		return "<main>"
+	}
+	return "<" + path + ">"
+}
+
+func qualify(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func (c *bytecodeCompiler) declare(path, file string, program compiler.Node) {
+	fileIndex := c.file(file)
+	add := func(name string) {
+		c.functions[name] = len(c.prog.Functions)
+		c.prog.Functions = append(c.prog.Functions, Function{Name: name, File: fileIndex})
+	}
+
+	add(initName(path))
+	for _, stmt := range program.Children {
+		if stmt.Type != compiler.NodeFunction {
+			continue
+		}
+		if _, ok := c.functions[qualify(path, stmt.Value)]; !ok {
+			add(qualify(path, stmt.Value))
+		}
+	}
+}
+
+func (c *bytecodeCompiler) file(name string) int {
+	if rel, err := filepath.Rel(c.dir, name); err == nil && !strings.HasPrefix(rel, "..") {
+		name = rel
+	}
+	name = filepath.ToSlash(name)
+	if i, ok := c.files[name]; ok {
+		return i
+	}
+	c.files[name] = len(c.prog.Files)
+	c.prog.Files = append(c.prog.Files, name)
+	return c.files[name]
+}
+
+// module compiles the top-level code and the functions of the module
+// at path. Duplicate functions were reported by the resolver; like
+// codegen, the first definition wins.
+func (c *bytecodeCompiler) module(path string, program compiler.Node) {
+	c.function(initName(path), path, program.Children)
+	seen := make(map[string]bool)
+	for _, stmt := range program.Children {
+		if stmt.Type == compiler.NodeFunction && !seen[stmt.Value] {
+			seen[stmt.Value] = true
+			c.function(qualify(path, stmt.Value), path, stmt.Children)
+		}
+	}
+}
+
+// function compiles stmts into the body of the function called name.
+// Statements without runtime effect, such as imports and function
+// definitions, produce no code.
+func (c *bytecodeCompiler) function(name, path string, stmts []compiler.Node) {
+	fn := &c.prog.Functions[c.functions[name]]
+	for _, stmt := range stmts {
+		switch stmt.Type {
+		case compiler.NodeCall:
+			if c.call(fn, path, stmt) {
+				c.emit(fn, stmt, OpPop)
+			}
+		case compiler.NodeReturn:
+			c.emit(fn, stmt, OpReturn)
+		}
+	}
+	c.emit(fn, compiler.Node{}, OpReturn)
+}
+
+// call compiles a resolved call and reports whether it leaves a value
+// on the stack.
+func (c *bytecodeCompiler) call(fn *Function, path string, call compiler.Node) bool {
+	if i := strings.LastIndex(call.Value, "."); i >= 0 {
+		if mod := c.modules[call.Value[:i]]; mod != nil && mod.Functions != nil {
+			// Standard modules are known at compile time, like in the
+			// generated Go code.
+			value, ok := mod.Values[call.Value[i+1:]]
+			if !ok {
+				c.fail("%s is not available in bytecode", call.Value)
+			}
+			c.emit(fn, call, OpConst, c.constant(value))
+			return true
+		}
+		c.emit(fn, call, OpCall, c.functions[call.Value])
+		return false
+	}
+	if index, ok := c.functions[qualify(path, call.Value)]; ok {
+		c.emit(fn, call, OpCall, index)
+		return false
+	}
+
+	builtin, ok := compiler.Builtins[call.Value]
+	if !ok {
+		c.fail("undefined function: %s", call.Value)
+		return false
+	}
+	argc := 0
+	for _, arg := range call.Children {
+		switch arg.Type {
+		case compiler.NodeString:
+			c.emit(fn, arg, OpConst, c.constant(arg.Value))
+			argc++
+		case compiler.NodeCall:
+			c.call(fn, path, arg)
+			argc++
+		}
+	}
+	if argc > 255 {
+		c.fail("%s has too many arguments for bytecode (%d, at most 255)", call.Value, argc)
+	}
+	c.emit(fn, call, OpBuiltin, c.constant(call.Value), argc)
+	return builtin.Value
+}
+
+func (c *bytecodeCompiler) constant(s string) int {
+	if i, ok := c.constants[s]; ok {
+		return i
+	}
+	if len(c.prog.Constants) > maxOperand {
+		c.fail("program has too many constants for bytecode (at most %d)", maxOperand+1)
+		return 0
+	}
+	c.constants[s] = len(c.prog.Constants)
+	c.prog.Constants = append(c.prog.Constants, s)
+	return c.constants[s]
+}
+
+// emit appends an instruction, recording node's position in the line
+// table when it differs from the previous one.
+func (c *bytecodeCompiler) emit(fn *Function, node compiler.Node, op Opcode, operands ...int) {
+	if node.Line > 0 {
+		if n := len(fn.Lines); n == 0 || fn.Lines[n-1].Line != node.Line || fn.Lines[n-1].Column != node.Column {
+			fn.Lines = append(fn.Lines, Line{PC: len(fn.Code), Line: node.Line, Column: node.Column})
+		}
+	}
+
+	fn.Code = append(fn.Code, byte(op))
+	for i, w := range opcodes[op].operands {
+		for shift := 8 * (w - 1); shift >= 0; shift -= 8 {
+			fn.Code = append(fn.Code, byte(operands[i]>>shift))
+		}
+	}
+}
+
+func (c *bytecodeCompiler) fail(format string, args ...any) {
+	if c.err == nil {
+		c.err = fmt.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Disassemble writes a listing of the program: the constant pool, the
+// init order and each function's instructions. Each instruction shows
+// its offset, its source position where the line table starts a new one
+// and its operands, with constants and functions spelled out.
+func (p *Program) Disassemble(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "constants (%d):\n", len(p.Constants))
+	for i, s := range p.Constants {
+		fmt.Fprintf(bw, "  %4d  %s\n", i, strconv.Quote(s))
+	}
+
+	fmt.Fprintf(bw, "\ninit:")
+	for _, index := range p.Init {
+		fmt.Fprintf(bw, " %s", p.Functions[index].Name)
+	}
+	fmt.Fprintln(bw)
+
+	for i := range p.Functions {
+		fn := &p.Functions[i]
+		fmt.Fprintf(bw, "\nfunction %d %s (%s, %d bytes):\n", i, fn.Name, p.Files[fn.File], len(fn.Code))
+
+		line := 0
+		for pc := 0; pc < len(fn.Code); {
+			op := Opcode(fn.Code[pc])
+
+			position := "    "
+			for _, l := range fn.Lines[line:] {
+				if l.PC > pc {
+					break
+				}
+				position = fmt.Sprintf("%d:%d", l.Line, l.Column)
+				line++
+			}
+			var operands string
+			switch op {
+			case OpConst:
+				c := operand(fn.Code, pc+1, 2)
+				operands = fmt.Sprintf("%d  %s", c, strconv.Quote(p.Constants[c]))
+			case OpCall:
+				f := operand(fn.Code, pc+1, 2)
+				operands = fmt.Sprintf("%d  %s", f, p.Functions[f].Name)
+			case OpBuiltin:
+				operands = fmt.Sprintf("%s %d", p.Constants[operand(fn.Code, pc+1, 2)], operand(fn.Code, pc+3, 1))
+			}
+			if operands == "" {
+				fmt.Fprintf(bw, "  %04d  %-7s %s\n", pc, position, op)
+			} else {
+				fmt.Fprintf(bw, "  %04d  %-7s %-8s %s\n", pc, position, op, operands)
+			}
+			pc += op.width()
+		}
+	}
+	return bw.Flush()
+}
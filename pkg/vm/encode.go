@@ -0,0 +1,274 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+// magic starts every .mobc file. formatVersion changes whenever the
+// encoding or the meaning of an opcode does.
+const (
+	magic         = "MOBC"
+	formatVersion = 1
+)
+
+// ErrFormat is wrapped by the errors of Read for files that are not
+// valid bytecode.
+var ErrFormat = errors.New("invalid bytecode")
+
+// WriteTo encodes the program. After the header, every number is an
+// unsigned varint and every string is its length followed by its bytes:
+// the constants, the files, the functions (name, file, code, and the
+// line table with pc deltas) and the init list.
+func (p *Program) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	b.WriteString(magic)
+	b.WriteByte(formatVersion)
+
+	num := func(n int) { b.Write(binary.AppendUvarint(nil, uint64(n))) }
+	str := func(s string) { num(len(s)); b.WriteString(s) }
+
+	num(len(p.Constants))
+	for _, s := range p.Constants {
+		str(s)
+	}
+	num(len(p.Files))
+	for _, s := range p.Files {
+		str(s)
+	}
+	num(len(p.Functions))
+	for _, fn := range p.Functions {
+		str(fn.Name)
+		num(fn.File)
+		num(len(fn.Code))
+		b.Write(fn.Code)
+		num(len(fn.Lines))
+		pc := 0
+		for _, l := range fn.Lines {
+			num(l.PC - pc)
+			num(l.Line)
+			num(l.Column)
+			pc = l.PC
+		}
+	}
+	num(len(p.Init))
+	for _, index := range p.Init {
+		num(index)
+	}
+	return b.WriteTo(w)
+}
+
+// WriteFile writes the program to a .mobc file.
+func (p *Program) WriteFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := p.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read decodes a program written by WriteTo and checks that it is safe
+// to run. Errors for malformed input wrap ErrFormat.
+func Read(r io.Reader) (*Program, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(d.r, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: not a .mobc file", ErrFormat)
+	}
+	if v := header[len(magic)]; v != formatVersion {
+		return nil, fmt.Errorf("%w: bytecode format %d, but this mob reads format %d; rebuild it with 'mob build --backend=vm'", ErrFormat, v, formatVersion)
+	}
+
+	p := &Program{}
+	if n := d.num(); n > 0 {
+		p.Constants = make([]string, n)
+	}
+	for i := range p.Constants {
+		p.Constants[i] = d.str()
+	}
+	p.Files = make([]string, d.num())
+	for i := range p.Files {
+		p.Files[i] = d.str()
+	}
+	p.Functions = make([]Function, d.num())
+	for i := range p.Functions {
+		fn := &p.Functions[i]
+		fn.Name = d.str()
+		fn.File = d.num()
+		fn.Code = d.bytes()
+		if n := d.num(); n > 0 {
+			fn.Lines = make([]Line, n)
+		}
+		pc := 0
+		for j := range fn.Lines {
+			pc += d.num()
+			fn.Lines[j] = Line{PC: pc, Line: d.num(), Column: d.num()}
+		}
+	}
+	p.Init = make([]int, d.num())
+	for i := range p.Init {
+		p.Init[i] = d.num()
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, d.err)
+	}
+	if _, err := d.r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("%w: trailing data", ErrFormat)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	return p, nil
+}
+
+// ReadFile reads a .mobc file.
+func ReadFile(name string) (*Program, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return p, nil
+}
+
+// IsBytecode reports whether the file name is bytecode: it has the
+// .mobc extension, or starts like one, as 'mob build --backend=vm -o'
+// can write it under any name.
+func IsBytecode(name string) bool {
+	if strings.HasSuffix(name, Extension) {
+		return true
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, len(magic))
+	_, err = io.ReadFull(f, header)
+	return err == nil && string(header) == magic
+}
+
+// validate checks what Run relies on: operands in range, known builtins
+// called with a valid number of arguments, no stack underflow, and
+// every function ending in a return with an empty stack.
+func (p *Program) validate() error {
+	for i := range p.Functions {
+		fn := &p.Functions[i]
+		if fn.File >= len(p.Files) {
+			return fmt.Errorf("%s: file %d out of range", fn.Name, fn.File)
+		}
+
+		depth := 0
+		last := Opcode(0)
+		for pc := 0; pc < len(fn.Code); {
+			op := Opcode(fn.Code[pc])
+			if !op.valid() || pc+op.width() > len(fn.Code) {
+				return fmt.Errorf("%s+%d: bad instruction", fn.Name, pc)
+			}
+
+			switch op {
+			case OpConst:
+				if operand(fn.Code, pc+1, 2) >= len(p.Constants) {
+					return fmt.Errorf("%s+%d: constant out of range", fn.Name, pc)
+				}
+				depth++
+			case OpCall:
+				if operand(fn.Code, pc+1, 2) >= len(p.Functions) {
+					return fmt.Errorf("%s+%d: function out of range", fn.Name, pc)
+				}
+			case OpBuiltin:
+				name := operand(fn.Code, pc+1, 2)
+				if name >= len(p.Constants) {
+					return fmt.Errorf("%s+%d: constant out of range", fn.Name, pc)
+				}
+				builtin, ok := compiler.Builtins[p.Constants[name]]
+				argc := operand(fn.Code, pc+3, 1)
+				if !ok || !builtin.CheckArgs(argc) {
+					return fmt.Errorf("%s+%d: bad builtin call %s/%d", fn.Name, pc, p.Constants[name], argc)
+				}
+				if depth -= argc; depth < 0 {
+					return fmt.Errorf("%s+%d: stack underflow", fn.Name, pc)
+				}
+				if builtin.Value {
+					depth++
+				}
+			case OpPop:
+				if depth--; depth < 0 {
+					return fmt.Errorf("%s+%d: stack underflow", fn.Name, pc)
+				}
+			case OpReturn:
+				if depth != 0 {
+					return fmt.Errorf("%s+%d: return with values on the stack", fn.Name, pc)
+				}
+			}
+			last = op
+			pc += op.width()
+		}
+		if last != OpReturn {
+			return fmt.Errorf("%s: does not end with a return", fn.Name)
+		}
+	}
+
+	for _, index := range p.Init {
+		if index >= len(p.Functions) {
+			return fmt.Errorf("init function %d out of range", index)
+		}
+	}
+	return nil
+}
+
+// decoder reads varints and strings, keeping the first error.
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+// maxLength bounds lengths and counts, so corrupt files cannot make Read
+// allocate huge buffers.
+const maxLength = 1 << 20
+
+func (d *decoder) num() int {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err == nil && n > maxLength {
+		err = fmt.Errorf("number %d too large", n)
+	}
+	if err != nil {
+		d.err = err
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	b := make([]byte, d.num())
+	if d.err == nil {
+		_, d.err = io.ReadFull(d.r, b)
+	}
+	return b
+}
+
+func (d *decoder) str() string {
+	return string(d.bytes())
+}
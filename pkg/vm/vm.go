@@ -0,0 +1,88 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/moblang/mob/pkg/compiler"
+)
+
+type frame struct {
+	fn *Function
+	pc int
+}
+
+type machine struct {
+	ctx    context.Context
+	prog   *Program
+	rt     *compiler.Runtime
+	stack  []any
+	frames []frame
+}
+
+// Run runs prog, which must be valid: compiled by Compile or loaded by
+// Read. It stops early when ctx is done.
+func Run(ctx context.Context, prog *Program, rt *compiler.Runtime) error {
+	m := &machine{ctx: ctx, prog: prog, rt: rt}
+	for _, index := range prog.Init {
+		if err := m.run(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run executes function index until it returns.
+func (m *machine) run(index int) error {
+	m.frames = append(m.frames[:0], frame{fn: &m.prog.Functions[index]})
+	for len(m.frames) > 0 {
+		f := &m.frames[len(m.frames)-1]
+		code := f.fn.Code
+		pc := f.pc
+		op := Opcode(code[pc])
+		f.pc += op.width()
+
+		switch op {
+		case OpConst:
+			m.stack = append(m.stack, m.prog.Constants[operand(code, pc+1, 2)])
+		case OpCall:
+			if err := m.ctx.Err(); err != nil {
+				return err
+			}
+			if len(m.frames) >= compiler.MaxDepth {
+				return m.errorf(f.fn, pc, "stack overflow: more than %d nested calls", compiler.MaxDepth)
+			}
+			m.frames = append(m.frames, frame{fn: &m.prog.Functions[operand(code, pc+1, 2)]})
+		case OpBuiltin:
+			if err := m.ctx.Err(); err != nil {
+				return err
+			}
+			builtin := compiler.Builtins[m.prog.Constants[operand(code, pc+1, 2)]]
+			argc := operand(code, pc+3, 1)
+			args := m.stack[len(m.stack)-argc:]
+			result, err := builtin.Call(m.rt, args)
+			if err != nil {
+				return err
+			}
+			m.stack = m.stack[:len(m.stack)-argc]
+			if builtin.Value {
+				m.stack = append(m.stack, result)
+			}
+		case OpPop:
+			m.stack = m.stack[:len(m.stack)-1]
+		case OpReturn:
+			m.frames = m.frames[:len(m.frames)-1]
+		}
+	}
+	return nil
+}
+
+func (m *machine) errorf(fn *Function, pc int, format string, args ...any) error {
+	line, column := fn.position(pc)
+	return &compiler.RuntimeError{
+		File:    m.prog.Files[fn.File],
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,295 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/moblang/mob/pkg/compiler"
+	"github.com/moblang/mob/pkg/interp"
+)
+
+var programArgs = []string{"one", "two words"}
+
+func load(t *testing.T, file string) *compiler.Result {
+	t.Helper()
+	comp := compiler.NewCompiler()
+	comp.Build = compiler.BuildInfo{Version: "1.2.3", Commit: "abc123"}
+	res, err := comp.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// roundTrip compiles res and passes the program through its encoding.
+func roundTrip(t *testing.T, res *compiler.Result) *Program {
+	t.Helper()
+	prog, err := Compile(res)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := prog.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !reflect.DeepEqual(prog, decoded) {
+		t.Fatalf("program changed through encoding:\n%+v\n%+v", prog, decoded)
+	}
+	return decoded
+}
+
+// agree runs file on the VM and the interpreter, which is checked
+// against the compiled Go program, and compares their output.
+func agree(t *testing.T, file string) {
+	t.Helper()
+	res := load(t, file)
+	if res.Diagnostics.HasErrors() {
+		return
+	}
+
+	var want bytes.Buffer
+	if err := interp.Run(context.Background(), res, &compiler.Runtime{Stdout: &want, Args: programArgs}); err != nil {
+		t.Fatalf("interpreter failed: %v", err)
+	}
+
+	var got bytes.Buffer
+	prog := roundTrip(t, res)
+	if err := Run(context.Background(), prog, &compiler.Runtime{Stdout: &got, Args: programArgs}); err != nil {
+		t.Fatalf("VM failed: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("output differs\ninterpreted:\n%s\nVM:\n%s", want.String(), got.String())
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExamples(t *testing.T) {
+	examples := filepath.Join("..", "..", "examples")
+	err := filepath.WalkDir(examples, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".mob") {
+			return err
+		}
+		name, _ := filepath.Rel(examples, path)
+		t.Run(filepath.ToSlash(name), func(t *testing.T) {
+			agree(t, path)
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrograms(t *testing.T) {
+	programs := map[string]map[string]string{
+		"args": {
+			"main.mob": "print(args())\nargs()\nprint(\"got\", args(), \"args\")\n",
+		},
+		"functions": {
+			"main.mob": "function greet():\n    print(\"hi\")\n    return\n    print(\"unreachable\")\n\ngreet()\nreturn\ngreet()\n",
+		},
+		"init order": {
+			"main.mob":  "import zeta\nimport alpha\n\nprint(\"main\")\nzeta.z()\nalpha.a()\n",
+			"zeta.mob":  "import mid\n\nprint(\"zeta init\")\n\nfunction z():\n    mid.m()\n",
+			"mid.mob":   "print(\"mid init\")\n\nfunction m():\n    print(\"mid\")\n",
+			"alpha.mob": "print(\"alpha init\")\n\nfunction a():\n    print(\"alpha\")\n",
+		},
+		"mob.build": {
+			"main.mob": "import mob.build\nfrom mob.build import commit\n\nprint(mob.build.version(), commit())\nmob.build.version()\n",
+		},
+	}
+
+	for name, files := range programs {
+		files := files
+		t.Run(name, func(t *testing.T) {
+			agree(t, filepath.Join(writeFiles(t, files), "main.mob"))
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mob":        "from lib.strings import shout\n\nprint(\"a\", \"a\")\nshout()\n",
+		"lib/strings.mob": "function shout():\n    print(\"A\")\n",
+	})
+	prog, err := Compile(load(t, filepath.Join(dir, "main.mob")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a", "print", "A"}; !reflect.DeepEqual(prog.Constants, want) {
+		t.Errorf("constants %q, want %q (deduplicated, in order of use)", prog.Constants, want)
+	}
+	if want := []string{"main.mob", "lib/strings.mob"}; !reflect.DeepEqual(prog.Files, want) {
+		t.Errorf("files %q, want %q", prog.Files, want)
+	}
+
+	var names []string
+	for _, fn := range prog.Functions {
+		names = append(names, fn.Name)
+	}
+	if want := []string{"<main>", "<lib.strings>", "lib.strings.shout"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("functions %q, want %q", names, want)
+	}
+	if want := []int{1, 0}; !reflect.DeepEqual(prog.Init, want) {
+		t.Errorf("init %v, want %v", prog.Init, want)
+	}
+
+	main := prog.Functions[0]
+	wantCode := []byte{
+		byte(OpConst), 0, 0,
+		byte(OpConst), 0, 0,
+		byte(OpBuiltin), 0, 1, 2,
+		byte(OpCall), 0, 2,
+		byte(OpReturn),
+	}
+	if !bytes.Equal(main.Code, wantCode) {
+		t.Errorf("code %v, want %v", main.Code, wantCode)
+	}
+	wantLines := []Line{{PC: 0, Line: 3, Column: 7}, {PC: 3, Line: 3, Column: 12}, {PC: 6, Line: 3, Column: 1}, {PC: 10, Line: 4, Column: 1}}
+	if !reflect.DeepEqual(main.Lines, wantLines) {
+		t.Errorf("lines %v, want %v", main.Lines, wantLines)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mob": "function greet():\n    print(\"hi\")\n\ngreet()\n",
+	})
+	prog, err := Compile(load(t, filepath.Join(dir, "main.mob")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := prog.Disassemble(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `constants (2):
+     0  "hi"
+     1  "print"
+
+init: <main>
+
+function 0 <main> (main.mob, 4 bytes):
+  0000  4:1     CALL     1  greet
+  0003          RETURN
+
+function 1 greet (main.mob, 8 bytes):
+  0000  2:11    CONST    0  "hi"
+  0003  2:5     BUILTIN  print 1
+  0007          RETURN
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestReadRejectsInvalidBytecode(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.mob": "print(args())\n"})
+	prog, err := Compile(load(t, filepath.Join(dir, "main.mob")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	prog.WriteTo(&buf)
+	valid := buf.Bytes()
+
+	corrupt := func(edit func(p *Program)) []byte {
+		p := *prog
+		p.Functions = append([]Function(nil), prog.Functions...)
+		p.Functions[0].Code = append([]byte(nil), prog.Functions[0].Code...)
+		edit(&p)
+		var b bytes.Buffer
+		p.WriteTo(&b)
+		return b.Bytes()
+	}
+
+	tests := map[string][]byte{
+		"empty":         nil,
+		"not bytecode":  []byte("print(\"hi\")\n"),
+		"old format":    append([]byte(magic+"\x00"), valid[len(magic)+1:]...),
+		"truncated":     valid[:len(valid)-3],
+		"trailing data": append(append([]byte(nil), valid...), 0),
+		"bad opcode":    corrupt(func(p *Program) { p.Functions[0].Code[0] = 0xff }),
+		"no return":     corrupt(func(p *Program) { p.Functions[0].Code = p.Functions[0].Code[:len(p.Functions[0].Code)-1] }),
+		"bad constant":  corrupt(func(p *Program) { p.Constants = p.Constants[:1] }),
+		"bad init":      corrupt(func(p *Program) { p.Init = []int{7} }),
+		"underflow":     corrupt(func(p *Program) { p.Functions[0].Code = []byte{byte(OpPop), byte(OpReturn)} }),
+		"unbalanced":    corrupt(func(p *Program) { p.Functions[0].Code = []byte{byte(OpConst), 0, 0, byte(OpReturn)} }),
+		"bad builtin":   corrupt(func(p *Program) { p.Constants[0] = "exit" }),
+	}
+	for name, data := range tests {
+		if _, err := Read(bytes.NewReader(data)); !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: expected ErrFormat, got %v", name, err)
+		}
+	}
+}
+
+func TestIsBytecode(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.mob": "print(\"hi\")\n"})
+	prog, err := Compile(load(t, filepath.Join(dir, "main.mob")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 'mob build --backend=vm -o h' writes bytecode without the extension.
+	if err := prog.WriteFile(filepath.Join(dir, "h")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{
+		"h":        true,
+		"main.mob": false,
+		"new.mobc": true,
+		"missing":  false,
+	} {
+		if got := IsBytecode(filepath.Join(dir, name)); got != want {
+			t.Errorf("IsBytecode(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mob": "function loop():\n    print(\"x\")\n    loop()\n\nloop()\n",
+	})
+	prog, err := Compile(load(t, filepath.Join(dir, "main.mob")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Run(context.Background(), prog, &compiler.Runtime{Stdout: &bytes.Buffer{}})
+	var runtimeErr *compiler.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+	if runtimeErr.File != "main.mob" || runtimeErr.Line != 3 || runtimeErr.Column != 5 || !strings.Contains(runtimeErr.Message, "stack overflow") {
+		t.Errorf("unexpected error: %v", runtimeErr)
+	}
+}